@@ -0,0 +1,177 @@
+package btc
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// UtxoBackend is a source of chain data and a way to broadcast, implemented
+// by BtcClient (bitcoind), EsploraClient and ElectrumClient, so that callers
+// don't depend on which one is used.
+type UtxoBackend interface {
+	// ListUnspentByAddress returns the confirmed and mempool unspents of addresses.
+	ListUnspentByAddress(addresses []string) ([]BtcUnspent, error)
+	GetTransaction(txid string) (*wire.MsgTx, error)
+	// Broadcast sends a signed transaction and returns its txid.
+	Broadcast(tx *wire.MsgTx) (string, error)
+	// EstimateFeeRate returns the fee rate in sat/kvB for confirmation within confTarget blocks.
+	EstimateFeeRate(confTarget int64) (int64, error)
+	TipHeight() (int64, error)
+}
+
+var (
+	_ UtxoBackend = (*BtcClient)(nil)
+	_ UtxoBackend = (*EsploraClient)(nil)
+	_ UtxoBackend = (*ElectrumClient)(nil)
+)
+
+// FetchPrevouts returns the outputs spent by tx, in the order of its inputs,
+// fetching each parent transaction once.
+func FetchPrevouts(backend UtxoBackend, tx *wire.MsgTx, chainCfg *chaincfg.Params) ([]BtcUnspent, error) {
+	parents := make(map[chainhash.Hash]*wire.MsgTx)
+	prevouts := make([]BtcUnspent, 0, len(tx.TxIn))
+	for i, txIn := range tx.TxIn {
+		outPoint := txIn.PreviousOutPoint
+		parent, ok := parents[outPoint.Hash]
+		if !ok {
+			var err error
+			if parent, err = backend.GetTransaction(outPoint.Hash.String()); err != nil {
+				return nil, fmt.Errorf("input %d: %w", i, err)
+			}
+			parents[outPoint.Hash] = parent
+		}
+		if int(outPoint.Index) >= len(parent.TxOut) {
+			return nil, fmt.Errorf("input %d: output %v not found", i, outPoint)
+		}
+		prevouts = append(prevouts, newBtcUnspentFromTxOut(outPoint, parent.TxOut[outPoint.Index], chainCfg))
+	}
+	return prevouts, nil
+}
+
+// FetchPrevTxs returns the parent transactions of the inputs of tx, each once,
+// e.g. for NewEnvelope.
+func FetchPrevTxs(backend UtxoBackend, tx *wire.MsgTx) ([]*wire.MsgTx, error) {
+	fetched := make(map[chainhash.Hash]bool)
+	var parents []*wire.MsgTx
+	for i, txIn := range tx.TxIn {
+		hash := txIn.PreviousOutPoint.Hash
+		if fetched[hash] {
+			continue
+		}
+		parent, err := backend.GetTransaction(hash.String())
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+		fetched[hash] = true
+		parents = append(parents, parent)
+	}
+	return parents, nil
+}
+
+func newBtcUnspentFromTxOut(outPoint wire.OutPoint, txOut *wire.TxOut, chainCfg *chaincfg.Params) BtcUnspent {
+	u := BtcUnspent{TxID: outPoint.Hash.String(), Vout: outPoint.Index,
+		ScriptPubKey: hex.EncodeToString(txOut.PkScript), Amount: txOut.Value,
+		ScriptType: txscript.GetScriptClass(txOut.PkScript).String()}
+	if _, addrs, _, err := txscript.ExtractPkScriptAddrs(txOut.PkScript, chainCfg); err == nil && len(addrs) == 1 {
+		u.Address = addrs[0].EncodeAddress()
+	}
+	return u
+}
+
+func decodeAddresses(addresses []string, chainCfg *chaincfg.Params) ([]btcutil.Address, error) {
+	if chainCfg == nil {
+		return nil, errors.New("chain params unknown")
+	}
+	addrs := make([]btcutil.Address, 0, len(addresses))
+	for _, address := range addresses {
+		addr, err := btcutil.DecodeAddress(address, chainCfg)
+		if err != nil {
+			return nil, err
+		}
+		if !addr.IsForNet(chainCfg) {
+			return nil, errors.New("address is not the corresponding network address")
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+// ListUnspentByAddress lists the unspents of addresses imported in the bitcoind wallet.
+func (this *BtcClient) ListUnspentByAddress(addresses []string) ([]BtcUnspent, error) {
+	addrs, err := decodeAddresses(addresses, this.chainParams)
+	if err != nil {
+		return nil, err
+	}
+	utxos, err := this.RpcClient.ListUnspentMinMaxAddresses(0, 9999999, addrs)
+	if err != nil {
+		return nil, err
+	}
+	return NewBtcUnspentsFromListUnspent(utxos)
+}
+
+func (this *BtcClient) GetTransaction(txid string) (*wire.MsgTx, error) {
+	hash, err := chainhash.NewHashFromStr(txid)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := this.RpcClient.GetRawTransaction(hash)
+	if err != nil {
+		return nil, err
+	}
+	return tx.MsgTx(), nil
+}
+
+func (this *BtcClient) Broadcast(tx *wire.MsgTx) (string, error) {
+	hash, err := this.RpcClient.SendRawTransaction(tx, false)
+	if err != nil {
+		return "", err
+	}
+	return hash.String(), nil
+}
+
+func (this *BtcClient) EstimateFeeRate(confTarget int64) (int64, error) {
+	estimate, err := this.EstimateFee(confTarget, "", nil)
+	if err != nil {
+		return 0, err
+	}
+	return estimate.FeePerKb, nil
+}
+
+func (this *BtcClient) TipHeight() (int64, error) {
+	return this.RpcClient.GetBlockCount()
+}
+
+// ListUnspentByAddress returns the unspents of addresses, one request per address.
+func (c *ElectrumClient) ListUnspentByAddress(addresses []string) ([]BtcUnspent, error) {
+	var unspents []BtcUnspent
+	for _, address := range addresses {
+		u, err := c.ListUnspent(address)
+		if err != nil {
+			return nil, err
+		}
+		unspents = append(unspents, u...)
+	}
+	return unspents, nil
+}
+
+func (c *ElectrumClient) GetTransaction(txid string) (*wire.MsgTx, error) {
+	return c.GetRawTransaction(txid)
+}
+
+func (c *ElectrumClient) Broadcast(tx *wire.MsgTx) (string, error) {
+	signedHex, err := SerializeMsgTx(tx)
+	if err != nil {
+		return "", err
+	}
+	return c.SendRawTransaction(signedHex)
+}
+
+func (c *ElectrumClient) EstimateFeeRate(confTarget int64) (int64, error) {
+	return c.EstimateFeePerKb(int(confTarget))
+}
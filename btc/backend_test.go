@@ -0,0 +1,110 @@
+package btc
+
+import (
+	"fmt"
+	"github.com/lizc2003/hdwallet/wallet"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newFakeEsploraClient(t *testing.T, routes map[string]string) *EsploraClient {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		key := r.Method + " " + r.URL.Path
+		if r.Method == http.MethodPost {
+			body, _ := io.ReadAll(r.Body)
+			key += " " + string(body)
+		}
+		resp, ok := routes[key]
+		if !ok {
+			http.Error(rw, "not found", http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(rw, resp)
+	}))
+	t.Cleanup(srv.Close)
+
+	cli, err := NewEsploraClient(srv.URL+"/api/", wallet.BtcChainRegtest)
+	require.NoError(t, err)
+	return cli
+}
+
+func TestUtxoBackend(t *testing.T) {
+	wallets := newTestWallets(t)
+	w := wallets[2]
+	chainParams := w.ChainParams()
+	address := w.DeriveAddress()
+	parent, unspents := newFundingTx(t, wallets, 100000)
+	parentHex, err := SerializeMsgTx(parent)
+	require.NoError(t, err)
+
+	child, err := NewBtcTransaction(unspents[2:], []BtcOutput{{Address: wallets[0].DeriveNativeAddress(), Amount: 50000}},
+		w.DeriveNativeAddress(), 1000, chainParams)
+	require.NoError(t, err)
+	require.NoError(t, child.Sign(w))
+	childHex, err := child.Serialize()
+	require.NoError(t, err)
+
+	esplora := newFakeEsploraClient(t, map[string]string{
+		"GET /api/blocks/tip/height": "200",
+		"GET /api/address/" + address + "/utxo": fmt.Sprintf(
+			`[{"txid":"%s","vout":2,"status":{"confirmed":true,"block_height":195},"value":100000}]`, parent.TxHash()),
+		"GET /api/tx/" + parent.TxHash().String() + "/hex": parentHex,
+		"POST /api/tx " + childHex:                         child.GetTxid(),
+		"GET /api/fee-estimates":                           `{"1":20.5,"2":15.1,"6":10.25,"144":1.0}`,
+	})
+	bitcoind := newFakeBtcClient(t, map[string]interface{}{
+		"getblockcount":  200,
+		"getnetworkinfo": map[string]interface{}{"version": 220000, "subversion": "/Satoshi:22.0.0/"},
+		"listunspent": []map[string]interface{}{{"txid": parent.TxHash().String(), "vout": 2, "address": address,
+			"scriptPubKey": unspents[2].ScriptPubKey, "amount": 0.001, "confirmations": 6, "spendable": true}},
+		"getrawtransaction":  parentHex,
+		"sendrawtransaction": child.GetTxid(),
+		"estimatesmartfee":   map[string]interface{}{"feerate": 0.0001025, "blocks": 6},
+	})
+
+	for name, backend := range map[string]UtxoBackend{"esplora": esplora, "bitcoind": bitcoind} {
+		t.Run(name, func(t *testing.T) {
+			tipHeight, err := backend.TipHeight()
+			require.NoError(t, err)
+			require.Equal(t, int64(200), tipHeight)
+
+			u, err := backend.ListUnspentByAddress([]string{address})
+			require.NoError(t, err)
+			require.Equal(t, []BtcUnspent{{TxID: parent.TxHash().String(), Vout: 2, ScriptPubKey: unspents[2].ScriptPubKey,
+				Amount: 100000, Address: address, ScriptType: "witness_v0_keyhash", Confirmations: 6}}, u)
+
+			prevouts, err := FetchPrevouts(backend, child.Tx, chainParams)
+			require.NoError(t, err)
+			require.Len(t, prevouts, 1)
+			require.Equal(t, address, prevouts[0].Address)
+			require.Equal(t, int64(100000), prevouts[0].Amount)
+			require.Equal(t, unspents[2].ScriptPubKey, prevouts[0].ScriptPubKey)
+
+			txid, err := backend.Broadcast(child.Tx)
+			require.NoError(t, err)
+			require.Equal(t, child.GetTxid(), txid)
+
+			feePerKb, err := backend.EstimateFeeRate(6)
+			require.NoError(t, err)
+			require.Equal(t, int64(10250), feePerKb)
+
+			_, err = backend.ListUnspentByAddress([]string{"invalid"})
+			require.Error(t, err)
+		})
+	}
+
+	// The estimate of the closest lower target is used.
+	feePerKb, err := esplora.EstimateFeeRate(24)
+	require.NoError(t, err)
+	require.Equal(t, int64(10250), feePerKb)
+	_, err = esplora.EstimateFeeRate(0)
+	require.ErrorIs(t, err, ErrFeeNotAvailable)
+
+	_, err = esplora.GetTransaction(child.GetTxid())
+	var esploraErr *EsploraError
+	require.ErrorAs(t, err, &esploraErr)
+	require.Equal(t, http.StatusNotFound, esploraErr.StatusCode)
+}
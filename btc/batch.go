@@ -0,0 +1,262 @@
+package btc
+
+import (
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"sync"
+)
+
+const (
+	DefaultBatchMaxOutputs = 250
+	// DefaultBatchMaxWeight leaves room below MaxStandardTxWeight for the
+	// difference between the estimated and the signed size.
+	DefaultBatchMaxWeight = MaxStandardTxWeight - 4000
+)
+
+var ErrDuplicatePayout = errors.New("duplicate payout request")
+
+// PayoutRequest is a pending withdrawal.
+type PayoutRequest struct {
+	// ID identifies the request, e.g. the id of the withdrawal in the database.
+	ID      string
+	Address btcutil.Address
+	Amount  int64
+}
+
+type BatcherConfig struct {
+	ChainParams   *chaincfg.Params
+	ChangeAddress btcutil.Address
+	// MaxOutputs is the maximum number of payouts of a transaction,
+	// DefaultBatchMaxOutputs if zero.
+	MaxOutputs int
+	// MaxWeight is the maximum estimated weight of a transaction,
+	// DefaultBatchMaxWeight if zero.
+	MaxWeight int64
+	// TxOptions are used to build every transaction, see NewBtcTransactionWithOptions.
+	TxOptions *BtcTxOptions
+}
+
+// PayoutBatcher accumulates payout requests and plans the transactions paying
+// them, one output per request.
+type PayoutBatcher struct {
+	cfg BatcherConfig
+
+	mu      sync.Mutex
+	pending []PayoutRequest
+	ids     map[string]bool
+}
+
+// PayoutBatch is a transaction paying a set of requests.
+type PayoutBatch struct {
+	Requests []PayoutRequest
+	Tx       *BtcTransaction
+	// Total is the sum of the payouts.
+	Total int64
+	Fee   int64
+}
+
+// Vout returns the index of the output paying Requests[i].
+func (b *PayoutBatch) Vout(i int) uint32 {
+	// The change output, added last, is swapped with a random output.
+	if b.Tx.ChangeIndex >= 0 && i == b.Tx.ChangeIndex {
+		return uint32(len(b.Tx.Tx.TxOut) - 1)
+	}
+	return uint32(i)
+}
+
+// PayoutPlan is the result of PayoutBatcher.Plan, to be reviewed before the
+// transactions are signed.
+type PayoutPlan struct {
+	Batches []*PayoutBatch
+	// Deferred are the requests which could not be funded by the unspents.
+	Deferred []PayoutRequest
+	Total    int64
+	Fee      int64
+}
+
+func NewPayoutBatcher(cfg BatcherConfig) (*PayoutBatcher, error) {
+	if cfg.ChainParams == nil || cfg.ChangeAddress == nil {
+		return nil, errors.New("wrong params")
+	}
+	if !cfg.ChangeAddress.IsForNet(cfg.ChainParams) {
+		return nil, errors.New("change address is not the corresponding network address")
+	}
+	if cfg.MaxOutputs <= 0 {
+		cfg.MaxOutputs = DefaultBatchMaxOutputs
+	}
+	if cfg.MaxWeight <= 0 {
+		cfg.MaxWeight = DefaultBatchMaxWeight
+	}
+	if cfg.TxOptions == nil {
+		cfg.TxOptions = &BtcTxOptions{}
+	}
+	return &PayoutBatcher{cfg: cfg, ids: make(map[string]bool)}, nil
+}
+
+// Add queues a request. Its output must be standard and not dust.
+func (b *PayoutBatcher) Add(req PayoutRequest) error {
+	if req.ID == "" || req.Address == nil {
+		return errors.New("wrong params")
+	}
+	if !req.Address.IsForNet(b.cfg.ChainParams) {
+		return errors.New("address is not the corresponding network address")
+	}
+	pkScript, err := txscript.PayToAddrScript(req.Address)
+	if err != nil {
+		return err
+	}
+	if err = b.policy().CheckOutput(wire.NewTxOut(req.Amount, pkScript)); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ids[req.ID] {
+		return fmt.Errorf("%w: %s", ErrDuplicatePayout, req.ID)
+	}
+	b.ids[req.ID] = true
+	b.pending = append(b.pending, req)
+	return nil
+}
+
+// Remove drops a pending request, e.g. a cancelled withdrawal.
+func (b *PayoutBatcher) Remove(id string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, req := range b.pending {
+		if req.ID == id {
+			b.pending = append(b.pending[:i:i], b.pending[i+1:]...)
+			delete(b.ids, id)
+			return true
+		}
+	}
+	return false
+}
+
+// Pending returns the pending requests in the order they were added.
+func (b *PayoutBatcher) Pending() []PayoutRequest {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]PayoutRequest{}, b.pending...)
+}
+
+// Complete removes the requests of a batch once it is broadcast.
+func (b *PayoutBatcher) Complete(batch *PayoutBatch) {
+	for _, req := range batch.Requests {
+		b.Remove(req.ID)
+	}
+}
+
+// Plan builds the unsigned transactions paying the pending requests, oldest
+// first, from unspents at feePerKb. A transaction has at most MaxOutputs
+// payouts and MaxWeight, and no two transactions spend the same unspent.
+// Requests which cannot be funded are deferred. The pending requests are not
+// changed, call Complete for each batch once it is broadcast.
+func (b *PayoutBatcher) Plan(unspents []BtcUnspent, feePerKb int64) (*PayoutPlan, error) {
+	if feePerKb <= 0 {
+		return nil, errors.New("wrong params")
+	}
+	requests := b.Pending()
+	available := matureUnspents(unspents)
+	plan := &PayoutPlan{}
+
+	for len(requests) > 0 {
+		n := len(requests)
+		if n > b.cfg.MaxOutputs {
+			n = b.cfg.MaxOutputs
+		}
+		// Requests over the available amount cannot be paid in this batch.
+		var funds, total int64
+		for _, u := range available {
+			funds += u.Amount
+		}
+		for i := 0; i < n; i++ {
+			if total+requests[i].Amount > funds {
+				n = i
+				break
+			}
+			total += requests[i].Amount
+		}
+
+		var batch *PayoutBatch
+		for n > 0 {
+			var err error
+			batch, err = b.buildBatch(requests[:n], available, feePerKb)
+			if err == nil {
+				if weight := batch.Tx.Weight(); weight > b.cfg.MaxWeight {
+					n = shrinkBatch(n, weight, b.cfg.MaxWeight)
+					continue
+				}
+				break
+			}
+			switch {
+			case errors.Is(err, ErrTxTooLarge):
+				n = shrinkBatch(n, b.cfg.MaxWeight*2, b.cfg.MaxWeight)
+			case errors.Is(err, ErrInsufficientFunds):
+				n--
+			default:
+				return nil, err
+			}
+		}
+		if n == 0 {
+			// The oldest request cannot be funded, the next ones may be smaller.
+			plan.Deferred = append(plan.Deferred, requests[0])
+			requests = requests[1:]
+			continue
+		}
+
+		plan.Batches = append(plan.Batches, batch)
+		plan.Total += batch.Total
+		plan.Fee += batch.Fee
+		requests = requests[n:]
+		spent := make([]BtcUnspent, len(batch.Tx.Tx.TxIn))
+		for i, txIn := range batch.Tx.Tx.TxIn {
+			spent[i] = BtcUnspent{TxID: txIn.PreviousOutPoint.Hash.String(), Vout: txIn.PreviousOutPoint.Index}
+		}
+		available = excludeUnspents(available, spent)
+	}
+	return plan, nil
+}
+
+func (b *PayoutBatcher) buildBatch(requests []PayoutRequest, unspents []BtcUnspent, feePerKb int64) (*PayoutBatch, error) {
+	if len(unspents) == 0 {
+		return nil, ErrInsufficientFunds
+	}
+	outputs := make([]BtcOutput, len(requests))
+	var total int64
+	for i, req := range requests {
+		outputs[i] = BtcOutput{Address: req.Address, Amount: req.Amount}
+		total += req.Amount
+	}
+	tx, err := NewBtcTransactionWithOptions(unspents, outputs, b.cfg.ChangeAddress, feePerKb,
+		b.cfg.ChainParams, b.cfg.TxOptions)
+	if err != nil {
+		return nil, err
+	}
+	return &PayoutBatch{Requests: append([]PayoutRequest{}, requests...), Tx: tx,
+		Total: total, Fee: tx.GetFee()}, nil
+}
+
+func (b *PayoutBatcher) policy() *Policy {
+	if b.cfg.TxOptions.Policy != nil {
+		return b.cfg.TxOptions.Policy
+	}
+	return &DefaultPolicy
+}
+
+// shrinkBatch returns the number of outputs of a batch of n outputs and
+// weight which fits in maxWeight, assuming the weight is proportional.
+func shrinkBatch(n int, weight, maxWeight int64) int {
+	m := int(int64(n)*maxWeight/weight) - 1
+	if m >= n {
+		m = n - 1
+	}
+	if m < 0 {
+		m = 0
+	}
+	return m
+}
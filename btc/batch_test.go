@@ -0,0 +1,94 @@
+package btc
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestPayoutBatcher(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	change, err := btcutil.NewAddressWitnessPubKeyHash(bytes.Repeat([]byte{1}, 20), params)
+	require.NoError(t, err)
+	funding, err := btcutil.NewAddressWitnessPubKeyHash(bytes.Repeat([]byte{2}, 20), params)
+	require.NoError(t, err)
+
+	b, err := NewPayoutBatcher(BatcherConfig{ChainParams: params, ChangeAddress: change, MaxOutputs: 3})
+	require.NoError(t, err)
+	for i := 0; i < 7; i++ {
+		addr, err := btcutil.NewAddressWitnessPubKeyHash(bytes.Repeat([]byte{byte(10 + i)}, 20), params)
+		require.NoError(t, err)
+		require.NoError(t, b.Add(PayoutRequest{ID: fmt.Sprint(i), Address: addr, Amount: int64(100000 * (i + 1))}))
+	}
+	require.ErrorIs(t, b.Add(PayoutRequest{ID: "0", Address: change, Amount: 100000}), ErrDuplicatePayout)
+	require.ErrorIs(t, b.Add(PayoutRequest{ID: "dust", Address: change, Amount: 100}), ErrDustOutput)
+	mainnet, err := btcutil.NewAddressWitnessPubKeyHash(bytes.Repeat([]byte{3}, 20), &chaincfg.MainNetParams)
+	require.NoError(t, err)
+	require.Error(t, b.Add(PayoutRequest{ID: "net", Address: mainnet, Amount: 100000}))
+	require.Len(t, b.Pending(), 7)
+
+	// 2.8 BTC are requested, the unspents fund the first two batches only.
+	unspents := newTestUnspents(t, funding, 1000000, 1200000, 300000, 200000)
+	plan, err := b.Plan(unspents, 2000)
+	require.NoError(t, err)
+	require.Len(t, plan.Batches, 2)
+	require.Equal(t, []string{"6"}, payoutIDs(plan.Deferred))
+	require.Equal(t, []string{"0", "1", "2"}, payoutIDs(plan.Batches[0].Requests))
+	require.Equal(t, []string{"3", "4", "5"}, payoutIDs(plan.Batches[1].Requests))
+	require.Equal(t, int64(2100000), plan.Total)
+
+	spent := make(map[string]bool)
+	var fee int64
+	for _, batch := range plan.Batches {
+		require.Equal(t, batch.Tx.GetFee(), batch.Fee)
+		fee += batch.Fee
+		for i, req := range batch.Requests {
+			txOut := batch.Tx.Tx.TxOut[batch.Vout(i)]
+			script, err := txscript.PayToAddrScript(req.Address)
+			require.NoError(t, err)
+			require.Equal(t, script, txOut.PkScript)
+			require.Equal(t, req.Amount, txOut.Value)
+		}
+		for _, txIn := range batch.Tx.Tx.TxIn {
+			require.False(t, spent[txIn.PreviousOutPoint.String()])
+			spent[txIn.PreviousOutPoint.String()] = true
+		}
+	}
+	require.Equal(t, fee, plan.Fee)
+
+	// Planning doesn't change the pending requests.
+	require.Len(t, b.Pending(), 7)
+	b.Complete(plan.Batches[0])
+	require.Equal(t, []string{"3", "4", "5", "6"}, payoutIDs(b.Pending()))
+	require.True(t, b.Remove("6"))
+	require.False(t, b.Remove("6"))
+
+	// The weight limit splits a batch.
+	b, err = NewPayoutBatcher(BatcherConfig{ChainParams: params, ChangeAddress: change, MaxWeight: 2000})
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		require.NoError(t, b.Add(PayoutRequest{ID: fmt.Sprint(i), Address: funding, Amount: 10000}))
+	}
+	plan, err = b.Plan(newTestUnspents(t, change, 1000000, 1000000, 1000000, 1000000), 1000)
+	require.NoError(t, err)
+	require.Empty(t, plan.Deferred)
+	require.Greater(t, len(plan.Batches), 1)
+	count := 0
+	for _, batch := range plan.Batches {
+		require.LessOrEqual(t, batch.Tx.Weight(), int64(2000))
+		count += len(batch.Requests)
+	}
+	require.Equal(t, 20, count)
+}
+
+func payoutIDs(requests []PayoutRequest) []string {
+	ids := make([]string, len(requests))
+	for i, req := range requests {
+		ids[i] = req.ID
+	}
+	return ids
+}
@@ -0,0 +1,423 @@
+package btc
+
+import (
+	"encoding/hex"
+	"errors"
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcwallet/wallet/txrules"
+	"github.com/btcsuite/btcwallet/wallet/txsizes"
+	"math/rand"
+	"sort"
+)
+
+const (
+	bnbMaxTries      = 100000
+	knapsackMaxTries = 1000
+)
+
+var (
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	// ErrNoChangelessSolution is returned by BranchAndBoundSelector when the
+	// funds are sufficient but no selection avoids a change output.
+	ErrNoChangelessSolution = errors.New("no changeless coin selection")
+)
+
+// CoinSelectionParams are the fee parameters a CoinSelector works with.
+type CoinSelectionParams struct {
+	FeePerKb int64
+	// LongTermFeePerKb is the expected fee rate when the coins would be spent
+	// later, used by the waste metric. Defaults to FeePerKb.
+	LongTermFeePerKb int64
+	// ChangeScriptSize is the size of the change output script.
+	ChangeScriptSize int
+}
+
+type CoinSelection struct {
+	Unspents []BtcUnspent
+	// Changeless reports that the excess is small enough to be dropped to fees.
+	Changeless bool
+	// Waste is the cost in satoshi of this selection compared to spending the
+	// same coins at the long term fee rate, plus the change cost or the excess.
+	Waste int64
+}
+
+// CoinSelector chooses the unspents funding target satoshi (the sum of outputs
+// plus the fee of the transaction without inputs).
+type CoinSelector interface {
+	Select(unspents []BtcUnspent, target int64, params CoinSelectionParams) (*CoinSelection, error)
+}
+
+type CoinSelectorFunc func(unspents []BtcUnspent, target int64, params CoinSelectionParams) (*CoinSelection, error)
+
+func (f CoinSelectorFunc) Select(unspents []BtcUnspent, target int64, params CoinSelectionParams) (*CoinSelection, error) {
+	return f(unspents, target, params)
+}
+
+var (
+	// BranchAndBoundSelector searches for a changeless selection with the least waste.
+	BranchAndBoundSelector CoinSelector = CoinSelectorFunc(selectBranchAndBound)
+	// LargestFirstSelector spends the largest coins first, minimizing the input count.
+	LargestFirstSelector CoinSelector = CoinSelectorFunc(selectLargestFirst)
+	// SmallestFirstSelector spends the smallest coins first, consolidating dust.
+	SmallestFirstSelector CoinSelector = CoinSelectorFunc(selectSmallestFirst)
+	// KnapsackSelector is a randomized search for the smallest excess over target + change.
+	KnapsackSelector CoinSelector = NewKnapsackSelector(nil)
+	// PrivacySelector avoids mixing coins of different address types.
+	PrivacySelector CoinSelector = &PrivacyCoinSelector{}
+	// DefaultCoinSelector tries branch and bound, then knapsack.
+	DefaultCoinSelector CoinSelector = FallbackSelector{BranchAndBoundSelector, KnapsackSelector}
+)
+
+// FallbackSelector returns the first successful selection.
+type FallbackSelector []CoinSelector
+
+func (s FallbackSelector) Select(unspents []BtcUnspent, target int64, params CoinSelectionParams) (*CoinSelection, error) {
+	err := ErrInsufficientFunds
+	for _, selector := range s {
+		var sel *CoinSelection
+		sel, err = selector.Select(unspents, target, params)
+		if err == nil {
+			return sel, nil
+		}
+	}
+	return nil, err
+}
+
+type coinCandidate struct {
+	unspent   BtcUnspent
+	effective int64
+	fee       int64
+	longFee   int64
+}
+
+func (p *CoinSelectionParams) longTermFeePerKb() int64 {
+	if p.LongTermFeePerKb > 0 {
+		return p.LongTermFeePerKb
+	}
+	return p.FeePerKb
+}
+
+func (p *CoinSelectionParams) changeScriptSize() int {
+	if p.ChangeScriptSize > 0 {
+		return p.ChangeScriptSize
+	}
+	return txsizes.P2WPKHPkScriptSize
+}
+
+// changeFee is the fee of adding a change output.
+func (p *CoinSelectionParams) changeFee() int64 {
+	size := p.changeScriptSize()
+	return feeForWeight(p.FeePerKb, (8+wire.VarIntSerializeSize(uint64(size))+size)*blockchain.WitnessScaleFactor)
+}
+
+// costOfChange is the fee of creating the change output now and spending it later.
+func (p *CoinSelectionParams) costOfChange() int64 {
+	weight, _ := selectionInputWeight(InputDescriptor{Type: InputP2WPKH})
+	return p.changeFee() + feeForWeight(p.longTermFeePerKb(), weight)
+}
+
+func feeForVSize(feePerKb int64, vsize int) int64 {
+	return int64(txrules.FeeForSerializeSize(btcutil.Amount(feePerKb), vsize))
+}
+
+// InputVirtualSize returns the estimated virtual size of spending an output with pkScript.
+// Outputs of unknown scripts are estimated as P2PKH, see InputDescriptor for
+// other spends.
+func InputVirtualSize(pkScript []byte) int {
+	vsize, _ := scriptDescriptor(pkScript).VirtualSize()
+	return vsize
+}
+
+func makeCandidates(unspents []BtcUnspent, params CoinSelectionParams) []coinCandidate {
+	candidates := make([]coinCandidate, 0, len(unspents))
+	for _, u := range unspents {
+		if !u.IsMature() {
+			continue
+		}
+		// The fees are those of the estimation of the whole transaction, see
+		// selectionBaseWeight.
+		weight, err := selectionInputWeight(u.spendDescriptor())
+		if err != nil {
+			continue
+		}
+		c := coinCandidate{unspent: u,
+			fee:     feeForWeight(params.FeePerKb, weight),
+			longFee: feeForWeight(params.longTermFeePerKb(), weight)}
+		c.effective = u.Amount - c.fee
+		if c.effective <= 0 {
+			// uneconomical at this fee rate
+			continue
+		}
+		candidates = append(candidates, c)
+	}
+	return candidates
+}
+
+func makeSelection(selected []coinCandidate, target int64, params CoinSelectionParams, changeless bool) *CoinSelection {
+	sel := &CoinSelection{Unspents: make([]BtcUnspent, len(selected)), Changeless: changeless}
+	var effective int64
+	for i, c := range selected {
+		sel.Unspents[i] = c.unspent
+		sel.Waste += c.fee - c.longFee
+		effective += c.effective
+	}
+	if changeless {
+		sel.Waste += effective - target
+	} else {
+		sel.Waste += params.costOfChange()
+	}
+	return sel
+}
+
+func selectBranchAndBound(unspents []BtcUnspent, target int64, params CoinSelectionParams) (*CoinSelection, error) {
+	candidates := makeCandidates(unspents, params)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].effective > candidates[j].effective
+	})
+
+	upper := target + params.costOfChange()
+	var available int64
+	for _, c := range candidates {
+		available += c.effective
+	}
+	if available < target {
+		return nil, ErrInsufficientFunds
+	}
+
+	// Depth first search over include/exclude decisions, as in Bitcoin Core.
+	var current []int
+	var currentValue, currentWaste int64
+	var best []int
+	bestWaste := int64(-1)
+	feeDiffPositive := params.FeePerKb > params.longTermFeePerKb()
+
+	for tries, i := 0, 0; tries < bnbMaxTries; tries, i = tries+1, i+1 {
+		backtrack := false
+		if currentValue+available < target || currentValue > upper ||
+			(bestWaste >= 0 && currentWaste > bestWaste && feeDiffPositive) {
+			backtrack = true
+		} else if currentValue >= target {
+			waste := currentWaste + currentValue - target
+			if bestWaste < 0 || waste <= bestWaste {
+				best = append(best[:0], current...)
+				bestWaste = waste
+			}
+			backtrack = true
+		}
+
+		if backtrack {
+			if len(current) == 0 {
+				break
+			}
+			// Walk back to the last included coin and exclude it instead.
+			last := current[len(current)-1]
+			for i--; i > last; i-- {
+				available += candidates[i].effective
+			}
+			current = current[:len(current)-1]
+			currentValue -= candidates[last].effective
+			currentWaste -= candidates[last].fee - candidates[last].longFee
+			continue
+		}
+
+		c := candidates[i]
+		available -= c.effective
+		// Skip a coin equal to an excluded predecessor, it gives the same result.
+		if len(current) == 0 || current[len(current)-1] == i-1 ||
+			c.effective != candidates[i-1].effective || c.fee != candidates[i-1].fee {
+			current = append(current, i)
+			currentValue += c.effective
+			currentWaste += c.fee - c.longFee
+		}
+	}
+
+	if bestWaste < 0 {
+		return nil, ErrNoChangelessSolution
+	}
+	selected := make([]coinCandidate, len(best))
+	for j, idx := range best {
+		selected[j] = candidates[idx]
+	}
+	return makeSelection(selected, target, params, true), nil
+}
+
+func selectLargestFirst(unspents []BtcUnspent, target int64, params CoinSelectionParams) (*CoinSelection, error) {
+	candidates := makeCandidates(unspents, params)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].effective > candidates[j].effective
+	})
+	return accumulate(candidates, target, params)
+}
+
+func selectSmallestFirst(unspents []BtcUnspent, target int64, params CoinSelectionParams) (*CoinSelection, error) {
+	candidates := makeCandidates(unspents, params)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].effective < candidates[j].effective
+	})
+	return accumulate(candidates, target, params)
+}
+
+// accumulate takes candidates in order until target plus a change output is funded.
+func accumulate(candidates []coinCandidate, target int64, params CoinSelectionParams) (*CoinSelection, error) {
+	need := target + params.changeFee()
+	var total int64
+	for i, c := range candidates {
+		total += c.effective
+		if total >= target && total <= target+params.costOfChange() {
+			return makeSelection(candidates[:i+1], target, params, true), nil
+		}
+		if total >= need {
+			return makeSelection(candidates[:i+1], target, params, false), nil
+		}
+	}
+	return nil, ErrInsufficientFunds
+}
+
+type knapsackSelector struct {
+	rnd *rand.Rand
+}
+
+// NewKnapsackSelector creates a knapsack selector; rnd may be nil or a seeded
+// source for reproducible results.
+func NewKnapsackSelector(rnd *rand.Rand) CoinSelector {
+	return &knapsackSelector{rnd: rnd}
+}
+
+func (s *knapsackSelector) Select(unspents []BtcUnspent, target int64, params CoinSelectionParams) (*CoinSelection, error) {
+	candidates := makeCandidates(unspents, params)
+	need := target + params.changeFee() + minChange(params)
+
+	// An exact match or a single coin bigger than needed wins immediately.
+	var smaller []coinCandidate
+	var lowestLarger *coinCandidate
+	var smallerTotal int64
+	for i := range candidates {
+		c := &candidates[i]
+		if c.effective >= target && c.effective <= target+params.costOfChange() {
+			return makeSelection([]coinCandidate{*c}, target, params, true), nil
+		}
+		if c.effective < need {
+			smaller = append(smaller, *c)
+			smallerTotal += c.effective
+		} else if lowestLarger == nil || c.effective < lowestLarger.effective {
+			lowestLarger = c
+		}
+	}
+
+	if smallerTotal < need {
+		if lowestLarger == nil {
+			return nil, ErrInsufficientFunds
+		}
+		return makeSelection([]coinCandidate{*lowestLarger}, target, params, false), nil
+	}
+
+	sort.SliceStable(smaller, func(i, j int) bool {
+		return smaller[i].effective > smaller[j].effective
+	})
+	best := make([]bool, len(smaller))
+	for i := range best {
+		best[i] = true
+	}
+	bestValue := smallerTotal
+
+	rnd := s.rnd
+	if rnd == nil {
+		rnd = rand.New(rand.NewSource(rand.Int63()))
+	}
+	included := make([]bool, len(smaller))
+	for try := 0; try < knapsackMaxTries && bestValue != need; try++ {
+		for i := range included {
+			included[i] = false
+		}
+		var total int64
+		reached := false
+		for pass := 0; pass < 2 && !reached; pass++ {
+			for i := range smaller {
+				// First pass picks randomly, second pass fills the gaps.
+				if included[i] || (pass == 0 && rnd.Intn(2) == 0) {
+					continue
+				}
+				total += smaller[i].effective
+				included[i] = true
+				if total >= need {
+					reached = true
+					if total < bestValue {
+						bestValue = total
+						copy(best, included)
+					}
+					total -= smaller[i].effective
+					included[i] = false
+				}
+			}
+		}
+	}
+
+	if lowestLarger != nil && lowestLarger.effective <= bestValue {
+		return makeSelection([]coinCandidate{*lowestLarger}, target, params, false), nil
+	}
+	var selected []coinCandidate
+	for i, ok := range best {
+		if ok {
+			selected = append(selected, smaller[i])
+		}
+	}
+	return makeSelection(selected, target, params, false), nil
+}
+
+// minChange is the smallest change worth creating, a conservative bound of
+// the dust threshold of txrules.IsDustOutput.
+func minChange(params CoinSelectionParams) int64 {
+	size := 8 + 1 + params.changeScriptSize() + txsizes.RedeemP2PKHInputSize
+	return 3 * int64(size) * int64(txrules.DefaultRelayFeePerKb) / 1000
+}
+
+// PrivacyCoinSelector only selects coins of one address type, so the
+// transaction does not link e.g. legacy and native segwit addresses.
+type PrivacyCoinSelector struct {
+	// Inner selects within each address type group, DefaultCoinSelector if nil.
+	Inner CoinSelector
+	// AllowMixing falls back to all coins when no single group is sufficient.
+	AllowMixing bool
+}
+
+func (s *PrivacyCoinSelector) Select(unspents []BtcUnspent, target int64, params CoinSelectionParams) (*CoinSelection, error) {
+	inner := s.Inner
+	if inner == nil {
+		inner = DefaultCoinSelector
+	}
+
+	groups := make(map[txscript.ScriptClass][]BtcUnspent)
+	var classes []txscript.ScriptClass
+	for _, u := range unspents {
+		script, err := hex.DecodeString(u.ScriptPubKey)
+		if err != nil {
+			continue
+		}
+		class := txscript.GetScriptClass(script)
+		if _, ok := groups[class]; !ok {
+			classes = append(classes, class)
+		}
+		groups[class] = append(groups[class], u)
+	}
+
+	var best *CoinSelection
+	for _, class := range classes {
+		sel, err := inner.Select(groups[class], target, params)
+		if err != nil {
+			continue
+		}
+		if best == nil || sel.Waste < best.Waste {
+			best = sel
+		}
+	}
+	if best != nil {
+		return best, nil
+	}
+	if s.AllowMixing {
+		return inner.Select(unspents, target, params)
+	}
+	return nil, ErrInsufficientFunds
+}
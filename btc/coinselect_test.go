@@ -0,0 +1,201 @@
+package btc
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/stretchr/testify/require"
+	"math/rand"
+	"testing"
+)
+
+func newTestUnspents(t *testing.T, addr btcutil.Address, amounts ...int64) []BtcUnspent {
+	script, err := txscript.PayToAddrScript(addr)
+	require.NoError(t, err)
+	unspents := make([]BtcUnspent, len(amounts))
+	for i, amount := range amounts {
+		unspents[i] = BtcUnspent{TxID: fmt.Sprintf("%064x", len(addr.String())*1000+i), Vout: uint32(i),
+			ScriptPubKey: hexString(script), Amount: amount}
+	}
+	return unspents
+}
+
+func sumUnspents(unspents []BtcUnspent) int64 {
+	var total int64
+	for _, u := range unspents {
+		total += u.Amount
+	}
+	return total
+}
+
+func TestCoinSelect_Strategies(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	addr, err := btcutil.NewAddressWitnessPubKeyHash(bytes.Repeat([]byte{1}, 20), params)
+	require.NoError(t, err)
+	unspents := newTestUnspents(t, addr, 100000, 200000, 300000, 500000)
+	csp := CoinSelectionParams{FeePerKb: 1000}
+
+	// A P2WPKH input costs 69 vbytes, 200000 + 300000 match exactly.
+	target := int64(200000-69) + (300000 - 69)
+	sel, err := BranchAndBoundSelector.Select(unspents, target, csp)
+	require.NoError(t, err)
+	require.True(t, sel.Changeless)
+	require.Equal(t, int64(500000), sumUnspents(sel.Unspents))
+	require.Len(t, sel.Unspents, 2)
+	require.Equal(t, int64(0), sel.Waste)
+
+	_, err = BranchAndBoundSelector.Select(unspents, 1050000, csp)
+	require.ErrorIs(t, err, ErrNoChangelessSolution)
+	_, err = BranchAndBoundSelector.Select(unspents, 2000000, csp)
+	require.ErrorIs(t, err, ErrInsufficientFunds)
+
+	sel, err = LargestFirstSelector.Select(unspents, 350000, csp)
+	require.NoError(t, err)
+	require.Len(t, sel.Unspents, 1)
+	require.Equal(t, int64(500000), sumUnspents(sel.Unspents))
+	require.False(t, sel.Changeless)
+
+	sel, err = SmallestFirstSelector.Select(unspents, 350000, csp)
+	require.NoError(t, err)
+	require.Len(t, sel.Unspents, 3)
+	require.Equal(t, int64(600000), sumUnspents(sel.Unspents))
+
+	sel, err = NewKnapsackSelector(rand.New(rand.NewSource(1))).Select(unspents, 350000, csp)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, sumUnspents(sel.Unspents), int64(350000))
+
+	_, err = DefaultCoinSelector.Select(unspents, 2000000, csp)
+	require.ErrorIs(t, err, ErrInsufficientFunds)
+
+	// Inputs worth less than their fee are never selected.
+	sel, err = SmallestFirstSelector.Select(newTestUnspents(t, addr, 50, 100000), 50000, csp)
+	require.NoError(t, err)
+	require.Len(t, sel.Unspents, 1)
+}
+
+func TestCoinSelect_Privacy(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	segwit, err := btcutil.NewAddressWitnessPubKeyHash(bytes.Repeat([]byte{1}, 20), params)
+	require.NoError(t, err)
+	legacy, err := btcutil.NewAddressPubKeyHash(bytes.Repeat([]byte{2}, 20), params)
+	require.NoError(t, err)
+
+	legacyUnspents := newTestUnspents(t, legacy, 150000, 150000)
+	segwitUnspents := newTestUnspents(t, segwit, 100000, 120000)
+	unspents := append(legacyUnspents, segwitUnspents...)
+	csp := CoinSelectionParams{FeePerKb: 2000, LongTermFeePerKb: 1000}
+	requireScript := func(sel *CoinSelection, script string) {
+		for _, u := range sel.Unspents {
+			require.Equal(t, script, u.ScriptPubKey)
+		}
+	}
+
+	// Both groups can pay, the segwit one wastes less.
+	sel, err := PrivacySelector.Select(unspents, 210000, csp)
+	require.NoError(t, err)
+	requireScript(sel, segwitUnspents[0].ScriptPubKey)
+
+	sel, err = PrivacySelector.Select(unspents, 250000, csp)
+	require.NoError(t, err)
+	requireScript(sel, legacyUnspents[0].ScriptPubKey)
+
+	_, err = PrivacySelector.Select(unspents, 400000, csp)
+	require.ErrorIs(t, err, ErrInsufficientFunds)
+	sel, err = (&PrivacyCoinSelector{AllowMixing: true}).Select(unspents, 400000, csp)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(sel.Unspents), 3)
+}
+
+func TestCoinSelect_NewBtcTransaction(t *testing.T) {
+	wallets := newTestWallets(t)
+	chainParams := wallets[0].ChainParams()
+	unspents := newTestUnspents(t, wallets[2].DeriveNativeAddress(), 100000, 200000, 300000, 500000)
+	dest, err := btcutil.NewAddressWitnessPubKeyHash(bytes.Repeat([]byte{1}, 20), chainParams)
+	require.NoError(t, err)
+	outputs := []BtcOutput{{Address: dest, Amount: 499750}}
+
+	tx, err := NewBtcTransactionWithOptions(unspents, outputs, wallets[2].DeriveNativeAddress(), 1000, chainParams,
+		&BtcTxOptions{CoinSelector: BranchAndBoundSelector})
+	require.NoError(t, err)
+	require.False(t, tx.HasChange())
+	require.Len(t, tx.Tx.TxIn, 2)
+	require.True(t, tx.CoinSelection().Changeless)
+	require.Equal(t, int64(250), tx.GetFee())
+	require.NoError(t, tx.Sign(wallets[2]))
+
+	tx, err = NewBtcTransactionWithOptions(unspents, outputs, wallets[2].DeriveNativeAddress(), 1000, chainParams,
+		&BtcTxOptions{CoinSelector: SmallestFirstSelector})
+	require.NoError(t, err)
+	require.True(t, tx.HasChange())
+	require.Len(t, tx.Tx.TxIn, 3)
+	require.NoError(t, tx.Sign(wallets[2]))
+
+	// Without a selector the unspents are spent in order.
+	tx, err = NewBtcTransaction(unspents, outputs, wallets[2].DeriveNativeAddress(), 1000, chainParams)
+	require.NoError(t, err)
+	require.Len(t, tx.Tx.TxIn, 3)
+	require.Nil(t, tx.CoinSelection())
+}
+
+func TestCoinSelect_ChangelessExtraInput(t *testing.T) {
+	wallets := newTestWallets(t)
+	chainParams := wallets[0].ChainParams()
+	// The estimate of branch and bound for these P2PKH coins was a bit lower
+	// than the one of the transaction, which spent a fifth input to the fee.
+	unspents := newTestUnspents(t, wallets[0].DeriveNativeAddress(),
+		22554, 22202, 25453, 60954, 31062, 13908, 47348, 35917, 53872)
+	outputs := []BtcOutput{{Address: wallets[2].DeriveNativeAddress(), Amount: 100000}}
+
+	// With the fees of the transaction estimate no selection is changeless,
+	// the default selector falls back to a change output.
+	_, err := NewBtcTransactionWithOptions(unspents, outputs, wallets[0].DeriveNativeAddress(), 1999, chainParams,
+		&BtcTxOptions{CoinSelector: BranchAndBoundSelector})
+	require.ErrorIs(t, err, ErrNoChangelessSolution)
+
+	tx, err := NewBtcTransactionWithOptions(unspents, outputs, wallets[0].DeriveNativeAddress(), 1999, chainParams,
+		&BtcTxOptions{CoinSelector: DefaultCoinSelector})
+	require.NoError(t, err)
+	require.True(t, tx.HasChange())
+	require.Len(t, tx.Tx.TxIn, 3)
+	require.Equal(t, int64(1043), tx.GetFee())
+	require.Equal(t, feeForVSize(1999, tx.estimateVSize()), tx.GetFee())
+	requireSpends(t, tx, tx.CoinSelection().Unspents)
+	require.NoError(t, tx.Sign(wallets[0]))
+	require.NoError(t, tx.CheckPolicy(&DefaultPolicy))
+
+	// A changeless selection with too much excess gets a change output, from
+	// the selected coins only.
+	selected := unspents[:5]
+	tx, err = NewBtcTransactionWithOptions(unspents, outputs, wallets[0].DeriveNativeAddress(), 1999, chainParams,
+		&BtcTxOptions{CoinSelector: CoinSelectorFunc(func([]BtcUnspent, int64, CoinSelectionParams) (*CoinSelection, error) {
+			return &CoinSelection{Unspents: selected, Changeless: true}, nil
+		})})
+	require.NoError(t, err)
+	require.True(t, tx.HasChange())
+	requireSpends(t, tx, selected)
+	require.NoError(t, tx.Sign(wallets[0]))
+
+	// The other coins are not spent if the selected ones are short.
+	_, err = NewBtcTransactionWithOptions(unspents, outputs, wallets[0].DeriveNativeAddress(), 1999, chainParams,
+		&BtcTxOptions{CoinSelector: CoinSelectorFunc(func([]BtcUnspent, int64, CoinSelectionParams) (*CoinSelection, error) {
+			return &CoinSelection{Unspents: unspents[:3]}, nil
+		})})
+	require.ErrorIs(t, err, ErrInsufficientFunds)
+	require.NoError(t, tx.CheckPolicy(&DefaultPolicy))
+}
+
+// requireSpends checks that tx spends exactly unspents, in any order.
+func requireSpends(t *testing.T, tx *BtcTransaction, unspents []BtcUnspent) {
+	require.Len(t, tx.Tx.TxIn, len(unspents))
+	expected := make([]string, len(unspents))
+	for i, u := range unspents {
+		expected[i] = fmt.Sprintf("%s:%d", u.TxID, u.Vout)
+	}
+	spent := make([]string, len(tx.Tx.TxIn))
+	for i, txIn := range tx.Tx.TxIn {
+		spent[i] = txIn.PreviousOutPoint.String()
+	}
+	require.ElementsMatch(t, expected, spent)
+}
@@ -0,0 +1,174 @@
+package btc
+
+import (
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"sort"
+)
+
+const (
+	DefaultConsolidationMinInputs = 10
+	DefaultConsolidationMaxInputs = 500
+)
+
+type ConsolidationConfig struct {
+	Destination btcutil.Address
+	// MaxFeePerKb is the fee rate threshold: consolidation is only planned
+	// when the fee rate is at or below it, so that small unspents are merged
+	// while fees are low instead of when they are spent.
+	MaxFeePerKb int64
+	// MaxAmount selects the unspents of at most this value, all if zero.
+	MaxAmount int64
+	// MinInputs is the minimum number of unspents worth a consolidation,
+	// DefaultConsolidationMinInputs if zero.
+	MinInputs int
+	// MaxInputs is the maximum number of inputs of a transaction,
+	// DefaultConsolidationMaxInputs if zero.
+	MaxInputs int
+	// ConfTarget is used by BtcClient.PlanConsolidation to estimate the fee
+	// rate, FeePrioritySlow if zero.
+	ConfTarget int64
+	// TxOptions are used to build every transaction, see NewSweepTransaction.
+	TxOptions *BtcTxOptions
+}
+
+// ConsolidationPlan is the result of PlanConsolidation, to be reviewed before
+// the transactions are signed.
+type ConsolidationPlan struct {
+	FeePerKb int64
+	// Ready is false if nothing should be consolidated now, Reason tells why.
+	Ready        bool
+	Reason       string
+	Transactions []*BtcTransaction
+	// Inputs is the number of unspents merged, Total their value.
+	Inputs int
+	Total  int64
+	Fee    int64
+	// Uneconomic are the selected unspents which are worth less than the fee
+	// of spending them at FeePerKb, they are left alone.
+	Uneconomic []BtcUnspent
+	// Remainder are the selected unspents left for a later consolidation:
+	// fewer than cfg.MinInputs after the last transaction, or a transaction
+	// whose output would be dust.
+	Remainder []BtcUnspent
+}
+
+// PlanConsolidation plans the transactions merging the small unspents to
+// cfg.Destination if feePerKb is at or below cfg.MaxFeePerKb. The smallest
+// unspents are merged first, in transactions of at most cfg.MaxInputs inputs
+// and at least cfg.MinInputs.
+func PlanConsolidation(unspents []BtcUnspent, feePerKb int64, cfg *ConsolidationConfig, chainCfg *chaincfg.Params) (*ConsolidationPlan, error) {
+	if cfg == nil || cfg.Destination == nil || cfg.MaxFeePerKb <= 0 || feePerKb <= 0 {
+		return nil, errors.New("wrong params")
+	}
+	minInputs := cfg.MinInputs
+	if minInputs <= 0 {
+		minInputs = DefaultConsolidationMinInputs
+	}
+	maxInputs := cfg.MaxInputs
+	if maxInputs <= 0 {
+		maxInputs = DefaultConsolidationMaxInputs
+	}
+	if maxInputs < 2 {
+		return nil, errors.New("wrong params")
+	}
+
+	plan := &ConsolidationPlan{FeePerKb: feePerKb}
+	if feePerKb > cfg.MaxFeePerKb {
+		plan.Reason = fmt.Sprintf("fee rate %d sat/kvB above threshold %d", feePerKb, cfg.MaxFeePerKb)
+		return plan, nil
+	}
+
+	var selected []BtcUnspent
+	for _, u := range unspents {
+		if !u.IsMature() || (cfg.MaxAmount > 0 && u.Amount > cfg.MaxAmount) {
+			continue
+		}
+		spend, err := u.InputDescriptor()
+		if err != nil {
+			continue
+		}
+		vsize, err := spend.VirtualSize()
+		if err != nil {
+			continue
+		}
+		if u.Amount <= feeForVSize(feePerKb, vsize) {
+			plan.Uneconomic = append(plan.Uneconomic, u)
+			continue
+		}
+		selected = append(selected, u)
+	}
+	if len(selected) < minInputs {
+		plan.Reason = fmt.Sprintf("%d unspents to consolidate, less than %d", len(selected), minInputs)
+		return plan, nil
+	}
+	sort.SliceStable(selected, func(i, j int) bool {
+		return selected[i].Amount < selected[j].Amount
+	})
+
+	for len(selected) > 0 {
+		n := len(selected)
+		if n > maxInputs {
+			n = maxInputs
+		}
+		if n < minInputs {
+			plan.Remainder = append(plan.Remainder, selected...)
+			break
+		}
+		tx, err := NewSweepTransaction(selected[:n], cfg.Destination, feePerKb, chainCfg, cfg.TxOptions)
+		if errors.Is(err, ErrDustOutput) || errors.Is(err, ErrInsufficientFunds) {
+			plan.Remainder = append(plan.Remainder, selected[:n]...)
+			selected = selected[n:]
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		plan.Transactions = append(plan.Transactions, tx)
+		plan.Inputs += n
+		plan.Total += int64(tx.TotalInput)
+		plan.Fee += tx.GetFee()
+		selected = selected[n:]
+	}
+	if len(plan.Transactions) == 0 {
+		plan.Reason = fmt.Sprintf("%d unspents left for later, no transaction worth building", len(plan.Remainder))
+		return plan, nil
+	}
+	plan.Ready = true
+	return plan, nil
+}
+
+// PlanConsolidation estimates the fee rate for cfg.ConfTarget and plans the
+// consolidation of the unspents of addresses with at least minConf
+// confirmations, see PlanConsolidation.
+func (this *BtcClient) PlanConsolidation(addresses []btcutil.Address, minConf int,
+	cfg *ConsolidationConfig, chainCfg *chaincfg.Params) (*ConsolidationPlan, error) {
+
+	if len(addresses) == 0 || cfg == nil || cfg.MaxFeePerKb <= 0 {
+		return nil, errors.New("wrong params")
+	}
+	confTarget := cfg.ConfTarget
+	if confTarget <= 0 {
+		confTarget = FeePrioritySlow.ConfTarget()
+	}
+	estimate, err := this.EstimateFee(confTarget, EstimateModeEconomical, nil)
+	if err != nil {
+		return nil, err
+	}
+	if estimate.FeePerKb > cfg.MaxFeePerKb {
+		return &ConsolidationPlan{FeePerKb: estimate.FeePerKb,
+			Reason: fmt.Sprintf("fee rate %d sat/kvB above threshold %d", estimate.FeePerKb, cfg.MaxFeePerKb)}, nil
+	}
+
+	utxos, err := this.RpcClient.ListUnspentMinMaxAddresses(minConf, 9999999, addresses)
+	if err != nil {
+		return nil, err
+	}
+	unspents, err := NewBtcUnspentsFromListUnspent(utxos)
+	if err != nil {
+		return nil, err
+	}
+	return PlanConsolidation(unspents, estimate.FeePerKb, cfg, chainCfg)
+}
@@ -0,0 +1,80 @@
+package btc
+
+import (
+	"bytes"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestPlanConsolidation(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	dest, err := btcutil.NewAddressWitnessPubKeyHash(bytes.Repeat([]byte{1}, 20), params)
+	require.NoError(t, err)
+	addr, err := btcutil.NewAddressWitnessPubKeyHash(bytes.Repeat([]byte{2}, 20), params)
+	require.NoError(t, err)
+	// A P2WPKH input costs 68 sat at 1 sat/vB.
+	unspents := newTestUnspents(t, addr, 50, 3000, 1000, 2000, 5000, 4000, 100000000)
+	cfg := &ConsolidationConfig{Destination: dest, MaxFeePerKb: 2000, MaxAmount: 10000, MinInputs: 3, MaxInputs: 3}
+
+	plan, err := PlanConsolidation(unspents, 5000, cfg, params)
+	require.NoError(t, err)
+	require.False(t, plan.Ready)
+	require.Contains(t, plan.Reason, "above threshold")
+	require.Empty(t, plan.Transactions)
+
+	plan, err = PlanConsolidation(unspents, 1000, cfg, params)
+	require.NoError(t, err)
+	require.True(t, plan.Ready)
+	require.Equal(t, []BtcUnspent{unspents[0]}, plan.Uneconomic)
+	// The two largest are fewer than MinInputs, they are left for later.
+	require.Len(t, plan.Transactions, 1)
+	require.Equal(t, 3, plan.Inputs)
+	require.Equal(t, int64(6000), plan.Total)
+	require.Equal(t, []BtcUnspent{unspents[5], unspents[4]}, plan.Remainder)
+	destScript, err := txscript.PayToAddrScript(dest)
+	require.NoError(t, err)
+	var fee int64
+	for _, tx := range plan.Transactions {
+		require.Len(t, tx.Tx.TxOut, 1)
+		require.Equal(t, destScript, tx.Tx.TxOut[0].PkScript)
+		fee += tx.GetFee()
+	}
+	require.Equal(t, fee, plan.Fee)
+	// The smallest are merged first.
+	require.Equal(t, int64(1000+2000+3000), int64(plan.Transactions[0].TotalInput))
+
+	// A transaction whose output would be dust doesn't fail the plan.
+	dusty := newTestUnspents(t, addr, 6000, 100, 5000, 110)
+	cfg.MinInputs, cfg.MaxInputs = 2, 2
+	plan, err = PlanConsolidation(dusty, 1000, cfg, params)
+	require.NoError(t, err)
+	require.True(t, plan.Ready)
+	require.Len(t, plan.Transactions, 1)
+	require.Equal(t, int64(11000), plan.Total)
+	require.Equal(t, []BtcUnspent{dusty[1], dusty[3]}, plan.Remainder)
+
+	// Not ready when every transaction would be dust.
+	plan, err = PlanConsolidation([]BtcUnspent{dusty[1], dusty[3]}, 1000, cfg, params)
+	require.NoError(t, err)
+	require.False(t, plan.Ready)
+	require.Empty(t, plan.Transactions)
+	require.Len(t, plan.Remainder, 2)
+	require.Contains(t, plan.Reason, "no transaction")
+	cfg.MinInputs, cfg.MaxInputs = 3, 3
+
+	cfg.MinInputs = 6
+	plan, err = PlanConsolidation(unspents, 1000, cfg, params)
+	require.NoError(t, err)
+	require.False(t, plan.Ready)
+
+	cli := newFakeBtcClient(t, map[string]interface{}{
+		"estimatesmartfee": map[string]interface{}{"feerate": 0.00003, "blocks": 24},
+	})
+	plan, err = cli.PlanConsolidation([]btcutil.Address{addr}, 1, cfg, params)
+	require.NoError(t, err)
+	require.False(t, plan.Ready)
+	require.Equal(t, int64(3000), plan.FeePerKb)
+}
@@ -0,0 +1,144 @@
+package btc
+
+import (
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcwallet/wallet/txauthor"
+	"github.com/btcsuite/btcwallet/wallet/txrules"
+	"github.com/lizc2003/hdwallet/wallet"
+)
+
+// Bitcoin Core default mempool package limits, sizes in vbytes.
+const (
+	DefaultAncestorLimit       = 25
+	DefaultAncestorSizeLimit   = 101000
+	DefaultDescendantLimit     = 25
+	DefaultDescendantSizeLimit = 101000
+)
+
+var (
+	ErrCpfpNotNeeded      = errors.New("package fee rate already reached")
+	ErrCpfpOutputTooSmall = errors.New("output too small to pay the child fee")
+	ErrAncestorLimit      = errors.New("mempool package limits exceeded")
+)
+
+type CpfpParams struct {
+	Parent *wire.MsgTx
+	// Vout is the parent output owned by the wallet.
+	Vout uint32
+	// FeePerKb is the target fee rate of parent and child together.
+	FeePerKb int64
+	// ParentFee and ParentVSize are the fee and virtual size of the parent
+	// together with its unconfirmed ancestors. ParentVSize defaults to the
+	// size of Parent.
+	ParentFee   int64
+	ParentVSize int64
+	// Destination receives the output minus the child fee, defaults to the wallet address.
+	Destination btcutil.Address
+}
+
+// NewCpfpTransaction builds and signs a child spending an output of a stuck
+// parent, paying enough fee to bring the package to params.FeePerKb.
+func NewCpfpTransaction(params *CpfpParams, w *wallet.BtcWallet) (*BtcTransaction, error) {
+	if params == nil || params.Parent == nil || params.FeePerKb <= 0 || params.ParentFee < 0 {
+		return nil, errors.New("wrong params")
+	}
+	if int(params.Vout) >= len(params.Parent.TxOut) {
+		return nil, errors.New("parent output not found")
+	}
+	chainCfg := w.ChainParams()
+	destination := params.Destination
+	if destination == nil {
+		destination = w.DeriveNativeAddress()
+	}
+	if !destination.IsForNet(chainCfg) {
+		return nil, errors.New("out address is not the corresponding network address")
+	}
+	destScript, err := txscript.PayToAddrScript(destination)
+	if err != nil {
+		return nil, err
+	}
+
+	parentVSize := params.ParentVSize
+	if parentVSize <= 0 {
+		parentVSize = mempool.GetTxVirtualSize(btcutil.NewTx(params.Parent))
+	}
+	if params.ParentFee >= feeForVSize(params.FeePerKb, int(parentVSize)) {
+		return nil, ErrCpfpNotNeeded
+	}
+
+	prevOut := params.Parent.TxOut[params.Vout]
+	parentHash := params.Parent.TxHash()
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&parentHash, params.Vout), nil, nil))
+	tx.AddTxOut(wire.NewTxOut(0, destScript))
+
+	t := &BtcTransaction{AuthoredTx: txauthor.AuthoredTx{
+		Tx:              tx,
+		PrevScripts:     [][]byte{prevOut.PkScript},
+		PrevInputValues: []btcutil.Amount{btcutil.Amount(prevOut.Value)},
+		TotalInput:      btcutil.Amount(prevOut.Value),
+		ChangeIndex:     -1,
+	}, chainParams: chainCfg}
+
+	childVSize := t.estimateVSize()
+	fee := feeForVSize(params.FeePerKb, int(parentVSize)+childVSize) - params.ParentFee
+	if minFee := feeForVSize(int64(txrules.DefaultRelayFeePerKb), childVSize); fee < minFee {
+		fee = minFee
+	}
+	tx.TxOut[0].Value = prevOut.Value - fee
+	if tx.TxOut[0].Value <= 0 || txrules.IsDustOutput(tx.TxOut[0], txrules.DefaultRelayFeePerKb) {
+		return nil, fmt.Errorf("%w: %d, fee %d", ErrCpfpOutputTooSmall, prevOut.Value, fee)
+	}
+	t.feePerKb = fee * 1000 / int64(childVSize)
+
+	if err = t.Sign(w); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// NewCpfpTransaction looks up the parent and its unconfirmed ancestors in the
+// mempool, checks that a child stays within the default ancestor and
+// descendant limits, and builds the child.
+func (this *BtcClient) NewCpfpTransaction(parent *wire.MsgTx, vout uint32, feePerKb int64,
+	w *wallet.BtcWallet, destination btcutil.Address) (*BtcTransaction, error) {
+
+	entry, err := this.RpcClient.GetMempoolEntry(parent.TxHash().String())
+	if err != nil {
+		return nil, err
+	}
+	if entry.AncestorCount+1 > DefaultAncestorLimit || entry.DescendantCount+1 > DefaultDescendantLimit {
+		return nil, fmt.Errorf("%w: %d ancestors, %d descendants", ErrAncestorLimit,
+			entry.AncestorCount, entry.DescendantCount)
+	}
+
+	ancestorFees := btcToSatoshi(entry.Fees.Ancestor)
+	if entry.Fees.Ancestor == 0 {
+		// before bitcoin core 0.17, in satoshi
+		ancestorFees = int64(entry.AncestorFees)
+	}
+	child, err := NewCpfpTransaction(&CpfpParams{
+		Parent:      parent,
+		Vout:        vout,
+		FeePerKb:    feePerKb,
+		ParentFee:   ancestorFees,
+		ParentVSize: entry.AncestorSize,
+		Destination: destination,
+	}, w)
+	if err != nil {
+		return nil, err
+	}
+
+	childVSize := mempool.GetTxVirtualSize(btcutil.NewTx(child.Tx))
+	if entry.AncestorSize+childVSize > DefaultAncestorSizeLimit ||
+		entry.DescendantSize+childVSize > DefaultDescendantSizeLimit {
+		return nil, fmt.Errorf("%w: ancestor size %d, descendant size %d", ErrAncestorLimit,
+			entry.AncestorSize, entry.DescendantSize)
+	}
+	return child, nil
+}
@@ -0,0 +1,88 @@
+package btc
+
+import (
+	"bytes"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestCpfp(t *testing.T) {
+	wallets := newTestWallets(t)
+	w := wallets[2]
+	chainParams := w.ChainParams()
+	unspents := newTestUnspents(t, wallets[2].DeriveNativeAddress(), 100000)
+	sender, err := btcutil.NewAddressWitnessPubKeyHash(bytes.Repeat([]byte{1}, 20), chainParams)
+	require.NoError(t, err)
+
+	// A deposit to us with a low fee, change goes back to the sender.
+	parent, err := NewBtcTransaction(unspents, []BtcOutput{{Address: w.DeriveNativeAddress(), Amount: 60000}},
+		sender, 1000, chainParams)
+	require.NoError(t, err)
+	require.NoError(t, parent.Sign(w))
+	vout := uint32(0)
+	if parent.ChangeIndex == 0 {
+		vout = 1
+	}
+
+	child, err := NewCpfpTransaction(&CpfpParams{Parent: parent.Tx, Vout: vout,
+		FeePerKb: 10000, ParentFee: parent.GetFee()}, w)
+	require.NoError(t, err)
+	require.Len(t, child.Tx.TxIn, 1)
+	require.Equal(t, parent.Tx.TxHash(), child.Tx.TxIn[0].PreviousOutPoint.Hash)
+
+	parentVSize := mempool.GetTxVirtualSize(btcutil.NewTx(parent.Tx))
+	childVSize := mempool.GetTxVirtualSize(btcutil.NewTx(child.Tx))
+	packageFee := parent.GetFee() + child.GetFee()
+	require.GreaterOrEqual(t, packageFee*1000/(parentVSize+childVSize), int64(10000))
+	require.Less(t, packageFee*1000/(parentVSize+childVSize), int64(10100))
+
+	_, err = NewCpfpTransaction(&CpfpParams{Parent: parent.Tx, Vout: vout,
+		FeePerKb: 1000, ParentFee: parent.GetFee()}, w)
+	require.ErrorIs(t, err, ErrCpfpNotNeeded)
+
+	_, err = NewCpfpTransaction(&CpfpParams{Parent: parent.Tx, Vout: vout,
+		FeePerKb: 1000000, ParentFee: parent.GetFee()}, w)
+	require.ErrorIs(t, err, ErrCpfpOutputTooSmall)
+
+	// The change output is not ours.
+	_, err = NewCpfpTransaction(&CpfpParams{Parent: parent.Tx, Vout: 1 - vout,
+		FeePerKb: 10000, ParentFee: parent.GetFee()}, w)
+	require.Error(t, err)
+}
+
+func TestBtcClient_NewCpfpTransaction(t *testing.T) {
+	wallets := newTestWallets(t)
+	w := wallets[2]
+	chainParams := w.ChainParams()
+	sender, err := btcutil.NewAddressWitnessPubKeyHash(bytes.Repeat([]byte{1}, 20), chainParams)
+	require.NoError(t, err)
+	parent, err := NewBtcTransaction(newTestUnspents(t, w.DeriveNativeAddress(), 100000),
+		[]BtcOutput{{Address: w.DeriveNativeAddress(), Amount: 60000}}, sender, 1000, chainParams)
+	require.NoError(t, err)
+	require.NoError(t, parent.Sign(w))
+	vout := uint32(0)
+	if parent.ChangeIndex == 0 {
+		vout = 1
+	}
+	parentVSize := mempool.GetTxVirtualSize(btcutil.NewTx(parent.Tx))
+
+	// Recent nodes report the fees in BTC, older ones only ancestorfees in satoshi.
+	expected, err := NewCpfpTransaction(&CpfpParams{Parent: parent.Tx, Vout: vout,
+		FeePerKb: 10000, ParentFee: parent.GetFee()}, w)
+	require.NoError(t, err)
+	for _, entry := range []map[string]interface{}{
+		{"fees": map[string]interface{}{"ancestor": SatoshiToBtc(parent.GetFee())}},
+		{"ancestorfees": parent.GetFee()},
+	} {
+		entry["ancestorcount"] = 1
+		entry["ancestorsize"] = parentVSize
+		entry["descendantcount"] = 1
+		entry["descendantsize"] = parentVSize
+		cli := newFakeBtcClient(t, map[string]interface{}{"getmempoolentry": entry})
+		child, err := cli.NewCpfpTransaction(parent.Tx, vout, 10000, w, nil)
+		require.NoError(t, err)
+		require.Equal(t, expected.GetFee(), child.GetFee())
+	}
+}
@@ -0,0 +1,252 @@
+package btc
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lizc2003/hdwallet/wallet"
+)
+
+// Kinds of nLockTime.
+const (
+	LockTimeNone   = "none"
+	LockTimeHeight = "height"
+	LockTimeTime   = "time"
+)
+
+// AddressOwner tells which addresses belong to a wallet. It is implemented
+// by wallet.BtcKeyring, see NewHDWalletKeyring.
+type AddressOwner interface {
+	Wallet(address string) (*wallet.BtcWallet, bool)
+}
+
+type DecodedInput struct {
+	TxID     string `json:"txid"`
+	Vout     uint32 `json:"vout"`
+	Sequence uint32 `json:"sequence"`
+	Coinbase bool   `json:"coinbase,omitempty"`
+	// Address, Value and ScriptType are those of the prevout, if known.
+	Address    string `json:"address,omitempty"`
+	Value      int64  `json:"value"`
+	ScriptType string `json:"scriptType,omitempty"`
+	// RelativeLockBlocks or RelativeLockSeconds is set if the sequence
+	// enables a BIP68 relative lock.
+	RelativeLockBlocks  uint16            `json:"relativeLockBlocks,omitempty"`
+	RelativeLockSeconds uint32            `json:"relativeLockSeconds,omitempty"`
+	Mine                bool              `json:"mine,omitempty"`
+	KeyOrigin           *wallet.KeyOrigin `json:"keyOrigin,omitempty"`
+}
+
+type DecodedOutput struct {
+	N            uint32            `json:"n"`
+	Address      string            `json:"address,omitempty"`
+	Value        int64             `json:"value"`
+	ScriptType   string            `json:"scriptType"`
+	ScriptPubKey string            `json:"scriptPubKey"`
+	Mine         bool              `json:"mine,omitempty"`
+	KeyOrigin    *wallet.KeyOrigin `json:"keyOrigin,omitempty"`
+}
+
+type DecodedTx struct {
+	TxID    string `json:"txid"`
+	WTxID   string `json:"wtxid"`
+	Version int32  `json:"version"`
+	Size    int64  `json:"size"`
+	VSize   int64  `json:"vsize"`
+	Weight  int64  `json:"weight"`
+	// LockTimeType is LockTimeNone, LockTimeHeight or LockTimeTime.
+	// LockTimeEnabled is false if all inputs are final, then nLockTime is ignored.
+	LockTime        uint32 `json:"locktime"`
+	LockTimeType    string `json:"locktimeType"`
+	LockTimeEnabled bool   `json:"locktimeEnabled"`
+	Rbf             bool   `json:"rbf"`
+
+	Inputs  []DecodedInput  `json:"inputs"`
+	Outputs []DecodedOutput `json:"outputs"`
+
+	// Fee and FeePerKb are only set if PrevoutsKnown.
+	PrevoutsKnown bool  `json:"prevoutsKnown"`
+	Fee           int64 `json:"fee"`
+	FeePerKb      int64 `json:"feePerKb"`
+	// Sent and Received are the values of the inputs and outputs owned by the wallet.
+	Sent     int64 `json:"sent"`
+	Received int64 `json:"received"`
+}
+
+// DecodeTx decodes tx. prevouts are the outputs spent by tx in the order of
+// its inputs, they may be nil if unknown. owner, which may be nil, marks the
+// inputs and outputs of a wallet. The sizes are those of tx as is, so they
+// are only final once it is signed.
+func DecodeTx(tx *wire.MsgTx, prevouts []BtcUnspent, chainCfg *chaincfg.Params, owner AddressOwner) (*DecodedTx, error) {
+	coinbase := blockchain.IsCoinBaseTx(tx)
+	if prevouts != nil && !coinbase {
+		if len(prevouts) != len(tx.TxIn) {
+			return nil, errors.New("prevouts don't match the inputs")
+		}
+		for i, txIn := range tx.TxIn {
+			outPoint, err := prevouts[i].OutPoint()
+			if err != nil || outPoint != txIn.PreviousOutPoint {
+				return nil, fmt.Errorf("prevout %d doesn't match input %v", i, txIn.PreviousOutPoint)
+			}
+		}
+	}
+
+	btx := btcutil.NewTx(tx)
+	weight := blockchain.GetTransactionWeight(btx)
+	d := &DecodedTx{
+		TxID:         tx.TxHash().String(),
+		WTxID:        tx.WitnessHash().String(),
+		Version:      tx.Version,
+		Size:         int64(tx.SerializeSize()),
+		VSize:        (weight + blockchain.WitnessScaleFactor - 1) / blockchain.WitnessScaleFactor,
+		Weight:       weight,
+		LockTime:     tx.LockTime,
+		LockTimeType: lockTimeType(tx.LockTime),
+		Rbf:          SignalsRbf(tx),
+		Inputs:       make([]DecodedInput, len(tx.TxIn)),
+		Outputs:      make([]DecodedOutput, len(tx.TxOut)),
+	}
+
+	var totalIn, totalOut int64
+	for i, txIn := range tx.TxIn {
+		in := &d.Inputs[i]
+		in.TxID = txIn.PreviousOutPoint.Hash.String()
+		in.Vout = txIn.PreviousOutPoint.Index
+		in.Sequence = txIn.Sequence
+		in.Coinbase = coinbase
+		if txIn.Sequence != wire.MaxTxInSequenceNum {
+			d.LockTimeEnabled = tx.LockTime != 0
+		}
+		if tx.Version >= 2 && !coinbase && IsRelativeLockTime(txIn.Sequence) {
+			value := txIn.Sequence & wire.SequenceLockTimeMask
+			if txIn.Sequence&wire.SequenceLockTimeIsSeconds != 0 {
+				in.RelativeLockSeconds = value << wire.SequenceLockTimeGranularity
+			} else {
+				in.RelativeLockBlocks = uint16(value)
+			}
+		}
+
+		if prevouts == nil || coinbase {
+			continue
+		}
+		prevout := &prevouts[i]
+		in.Value = prevout.Amount
+		totalIn += prevout.Amount
+		pkScript, err := hex.DecodeString(prevout.ScriptPubKey)
+		if err != nil {
+			return nil, fmt.Errorf("prevout %d: %w", i, err)
+		}
+		in.Address, in.ScriptType = addressOfScript(pkScript, chainCfg)
+		if in.Mine, in.KeyOrigin = isMine(owner, in.Address); in.Mine {
+			d.Sent += in.Value
+		}
+	}
+
+	for i, txOut := range tx.TxOut {
+		out := &d.Outputs[i]
+		out.N = uint32(i)
+		out.Value = txOut.Value
+		out.ScriptPubKey = hex.EncodeToString(txOut.PkScript)
+		out.Address, out.ScriptType = addressOfScript(txOut.PkScript, chainCfg)
+		if out.Mine, out.KeyOrigin = isMine(owner, out.Address); out.Mine {
+			d.Received += out.Value
+		}
+		totalOut += txOut.Value
+	}
+
+	if prevouts != nil && !coinbase {
+		d.PrevoutsKnown = true
+		d.Fee = totalIn - totalOut
+		if d.VSize > 0 {
+			d.FeePerKb = d.Fee * 1000 / d.VSize
+		}
+	}
+	return d, nil
+}
+
+// DecodeTxHex decodes a raw transaction in hex, see DecodeTx.
+func DecodeTxHex(rawHex string, prevouts []BtcUnspent, chainCfg *chaincfg.Params, owner AddressOwner) (*DecodedTx, error) {
+	tx, err := DeserializeMsgTx(rawHex)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeTx(tx, prevouts, chainCfg, owner)
+}
+
+// DecodeTxWithBackend decodes tx with the prevouts fetched from backend.
+func DecodeTxWithBackend(tx *wire.MsgTx, backend UtxoBackend, chainCfg *chaincfg.Params, owner AddressOwner) (*DecodedTx, error) {
+	var prevouts []BtcUnspent
+	if !blockchain.IsCoinBaseTx(tx) {
+		var err error
+		if prevouts, err = FetchPrevouts(backend, tx, chainCfg); err != nil {
+			return nil, err
+		}
+	}
+	return DecodeTx(tx, prevouts, chainCfg, owner)
+}
+
+// DecodeDetails decodes the transaction with its prevouts, see DecodeTx.
+func (t *BtcTransaction) DecodeDetails(owner AddressOwner) (*DecodedTx, error) {
+	prevouts := make([]BtcUnspent, len(t.Tx.TxIn))
+	for i, txIn := range t.Tx.TxIn {
+		prevouts[i] = newBtcUnspentFromTxOut(txIn.PreviousOutPoint,
+			wire.NewTxOut(int64(t.PrevInputValues[i]), t.PrevScripts[i]), t.chainParams)
+	}
+	return DecodeTx(t.Tx, prevouts, t.chainParams, owner)
+}
+
+// NewHDWalletKeyring returns a keyring of the first count receive and change
+// addresses of the BIP44, BIP49 and BIP84 accounts, to be used as AddressOwner.
+func NewHDWalletKeyring(hdw *wallet.HDWallet, accountIndex, count int) (*wallet.BtcKeyring, error) {
+	keyring, err := hdw.NewBtcKeyring()
+	if err != nil {
+		return nil, err
+	}
+	for _, segWitType := range []wallet.SegWitType{wallet.SegWitNone, wallet.SegWitScript, wallet.SegWitNative} {
+		for changeType := 0; changeType <= 1; changeType++ {
+			if _, err = keyring.AddWindow(segWitType, accountIndex, changeType, 0, count); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return keyring, nil
+}
+
+func lockTimeType(lockTime uint32) string {
+	switch {
+	case lockTime == 0:
+		return LockTimeNone
+	case lockTime < LockTimeThreshold:
+		return LockTimeHeight
+	default:
+		return LockTimeTime
+	}
+}
+
+func addressOfScript(pkScript []byte, chainCfg *chaincfg.Params) (string, string) {
+	class, addrs, _, _ := txscript.ExtractPkScriptAddrs(pkScript, chainCfg)
+	scriptType := class.String()
+	if IsNullData(pkScript) {
+		scriptType = txscript.NullDataTy.String()
+	}
+	if len(addrs) != 1 || class == txscript.MultiSigTy {
+		return "", scriptType
+	}
+	return addrs[0].EncodeAddress(), scriptType
+}
+
+func isMine(owner AddressOwner, address string) (bool, *wallet.KeyOrigin) {
+	if owner == nil || address == "" {
+		return false, nil
+	}
+	w, ok := owner.Wallet(address)
+	if !ok {
+		return false, nil
+	}
+	return true, w.KeyOrigin()
+}
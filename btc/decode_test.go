@@ -0,0 +1,111 @@
+package btc
+
+import (
+	"bytes"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lizc2003/hdwallet/wallet"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestDecodeTx(t *testing.T) {
+	wallets := newTestWallets(t)
+	chainParams := wallets[0].ChainParams()
+	hdw, err := wallet.NewHDWallet(testMnemonic, "", wallet.BtcChainRegtest, wallet.ChainMainNet)
+	require.NoError(t, err)
+	keyring, err := NewHDWalletKeyring(hdw, 0, 2)
+	require.NoError(t, err)
+	require.Equal(t, 12, keyring.Len())
+
+	parent, unspents := newFundingTx(t, wallets, 100000)
+	other, err := btcutil.NewAddressWitnessPubKeyHash(bytes.Repeat([]byte{1}, 20), chainParams)
+	require.NoError(t, err)
+	tx, err := NewBtcTransactionWithOptions(unspents, []BtcOutput{{Address: other, Amount: 250000}},
+		wallets[2].DeriveNativeAddress(), 2000, chainParams, &BtcTxOptions{Rbf: true, LockTime: 500})
+	require.NoError(t, err)
+	require.NoError(t, tx.SetSequence(1, CsvBlocks(10)))
+	require.NoError(t, tx.SignWithSecretsSource(keyring))
+	rawHex, err := tx.Serialize()
+	require.NoError(t, err)
+
+	d, err := DecodeTxHex(rawHex, unspents, chainParams, keyring)
+	require.NoError(t, err)
+	require.Equal(t, tx.GetTxid(), d.TxID)
+	require.Equal(t, int32(2), d.Version)
+	require.Equal(t, mempool.GetTxVirtualSize(btcutil.NewTx(tx.Tx)), d.VSize)
+	require.Equal(t, d.VSize*4, (d.Weight+3)/4*4)
+	require.True(t, d.Rbf)
+	require.Equal(t, uint32(500), d.LockTime)
+	require.Equal(t, LockTimeHeight, d.LockTimeType)
+	require.True(t, d.LockTimeEnabled)
+	require.True(t, d.PrevoutsKnown)
+	require.Equal(t, tx.GetFee(), d.Fee)
+	require.Equal(t, tx.GetFee()*1000/d.VSize, d.FeePerKb)
+	require.Equal(t, int64(300000), d.Sent)
+	require.Equal(t, 300000-250000-tx.GetFee(), d.Received)
+
+	require.Len(t, d.Inputs, 3)
+	for i, in := range d.Inputs {
+		require.Equal(t, parent.TxHash().String(), in.TxID)
+		require.Equal(t, wallets[i].DeriveAddress(), in.Address)
+		require.Equal(t, int64(100000), in.Value)
+		require.True(t, in.Mine)
+		require.Equal(t, wallets[i].KeyOrigin().Path, in.KeyOrigin.Path)
+	}
+	require.Equal(t, []string{"pubkeyhash", "scripthash", "witness_v0_keyhash"},
+		[]string{d.Inputs[0].ScriptType, d.Inputs[1].ScriptType, d.Inputs[2].ScriptType})
+	require.Equal(t, uint16(10), d.Inputs[1].RelativeLockBlocks)
+	require.Equal(t, uint16(0), d.Inputs[0].RelativeLockBlocks)
+
+	require.Len(t, d.Outputs, 2)
+	for _, out := range d.Outputs {
+		if out.Address == other.String() {
+			require.False(t, out.Mine)
+			require.Equal(t, int64(250000), out.Value)
+		} else {
+			require.True(t, out.Mine)
+			require.Equal(t, "witness_v0_keyhash", out.ScriptType)
+		}
+	}
+
+	// The same from the transaction and from a backend.
+	details, err := tx.DecodeDetails(keyring)
+	require.NoError(t, err)
+	require.Equal(t, d, details)
+	parentHex, err := SerializeMsgTx(parent)
+	require.NoError(t, err)
+	esplora := newFakeEsploraClient(t, map[string]string{
+		"GET /api/tx/" + parent.TxHash().String() + "/hex": parentHex,
+	})
+	fetched, err := DecodeTxWithBackend(tx.Tx, esplora, chainParams, keyring)
+	require.NoError(t, err)
+	require.Equal(t, d, fetched)
+
+	// Without prevouts nor owner
+	d, err = DecodeTx(tx.Tx, nil, chainParams, nil)
+	require.NoError(t, err)
+	require.False(t, d.PrevoutsKnown)
+	require.Zero(t, d.Fee)
+	require.Zero(t, d.Sent)
+	require.Empty(t, d.Inputs[0].Address)
+	require.False(t, d.Outputs[0].Mine)
+
+	_, err = DecodeTx(tx.Tx, unspents[:2], chainParams, nil)
+	require.Error(t, err)
+	_, err = DecodeTx(tx.Tx, []BtcUnspent{unspents[1], unspents[0], unspents[2]}, chainParams, nil)
+	require.Error(t, err)
+
+	// A final input ignores nLockTime, a timestamp lock time.
+	final := tx.Tx.Copy()
+	final.LockTime = LockTimeThreshold + 1
+	for _, txIn := range final.TxIn {
+		txIn.Sequence = wire.MaxTxInSequenceNum
+	}
+	d, err = DecodeTx(final, nil, chainParams, nil)
+	require.NoError(t, err)
+	require.Equal(t, LockTimeTime, d.LockTimeType)
+	require.False(t, d.LockTimeEnabled)
+	require.False(t, d.Rbf)
+}
@@ -0,0 +1,465 @@
+package btc
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lizc2003/hdwallet/wallet"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	ElectrumProtocolVersion = "1.4"
+	electrumClientName      = "hdwallet"
+	electrumDefaultTimeout  = 30 * time.Second
+	electrumNotificationCap = 100
+)
+
+var (
+	ErrElectrumClosed = errors.New("electrum connection closed")
+	// ErrElectrumNotificationsFull closes the connection when Notifications
+	// is not read fast enough, instead of losing a notification.
+	ErrElectrumNotificationsFull = errors.New("electrum notifications not read")
+)
+
+// ElectrumError is an error returned by the Electrum server.
+type ElectrumError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ElectrumError) Error() string {
+	return fmt.Sprintf("electrum error %d: %s", e.Code, e.Message)
+}
+
+// ElectrumNotification is sent when the status of a subscribed script hash
+// changes, i.e. a transaction paying to or spending from it was seen or confirmed.
+type ElectrumNotification struct {
+	ScriptHash string
+	// Status is the hash of the history of the script hash, empty if it has none.
+	Status string
+}
+
+type ElectrumHistoryItem struct {
+	TxHash string `json:"tx_hash"`
+	// Height is 0 for a mempool transaction, -1 if it has unconfirmed inputs.
+	Height int64 `json:"height"`
+	// Fee is only set for mempool transactions.
+	Fee int64 `json:"fee,omitempty"`
+}
+
+type electrumUnspent struct {
+	TxHash string `json:"tx_hash"`
+	TxPos  uint32 `json:"tx_pos"`
+	Height int64  `json:"height"`
+	Value  int64  `json:"value"`
+}
+
+type electrumMessage struct {
+	ID     *uint64           `json:"id"`
+	Result json.RawMessage   `json:"result"`
+	Error  *ElectrumError    `json:"error"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// ElectrumClient talks to an ElectrumX or Fulcrum server with the Electrum
+// protocol: newline delimited JSON-RPC over TCP or TLS. It is safe for
+// concurrent use.
+type ElectrumClient struct {
+	chainParams *chaincfg.Params
+	conn        net.Conn
+	// Timeout of a request, 30 seconds by default.
+	Timeout time.Duration
+
+	writeMu       sync.Mutex
+	mu            sync.Mutex
+	nextID        uint64
+	pending       map[uint64]chan *electrumMessage
+	tipHeight     int64
+	notifications chan ElectrumNotification
+	closed        chan struct{}
+	err           error
+}
+
+// NewElectrumClient connects to URL, e.g. tcp://localhost:50001 or
+// ssl://electrum.example.com:50002, and negotiates the protocol version.
+// tlsConfig is used for ssl:// (or tls://) URLs, a default one if nil.
+func NewElectrumClient(URL string, chainId int, tlsConfig *tls.Config) (*ElectrumClient, error) {
+	chainParams, err := wallet.GetBtcChainParams(chainId)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(URL)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: electrumDefaultTimeout}
+	var conn net.Conn
+	switch u.Scheme {
+	case "tcp":
+		conn, err = dialer.Dial("tcp", u.Host)
+	case "ssl", "tls":
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{ServerName: u.Hostname()}
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", u.Host, tlsConfig)
+	default:
+		return nil, fmt.Errorf("unsupported scheme: %s", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &ElectrumClient{chainParams: chainParams, conn: conn, Timeout: electrumDefaultTimeout,
+		pending:       make(map[uint64]chan *electrumMessage),
+		notifications: make(chan ElectrumNotification, electrumNotificationCap),
+		closed:        make(chan struct{})}
+	go c.readLoop()
+
+	if _, err = c.ServerVersion(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *ElectrumClient) Close() error {
+	c.shutdown(ErrElectrumClosed)
+	return nil
+}
+
+// Notifications returns the channel of script hash status changes. It is
+// closed with the connection, with ErrElectrumNotificationsFull if it is full,
+// so that the caller can reconnect and check the statuses again.
+func (c *ElectrumClient) Notifications() <-chan ElectrumNotification {
+	return c.notifications
+}
+
+// ServerVersion returns the server software and the negotiated protocol version.
+func (c *ElectrumClient) ServerVersion() ([]string, error) {
+	var version []string
+	err := c.call("server.version", []interface{}{electrumClientName, ElectrumProtocolVersion}, &version)
+	return version, err
+}
+
+func (c *ElectrumClient) Ping() error {
+	return c.call("server.ping", nil, nil)
+}
+
+// ElectrumScriptHash returns the script hash identifying pkScript in the
+// Electrum protocol: its sha256, byte reversed, in hex.
+func ElectrumScriptHash(pkScript []byte) string {
+	hash := sha256.Sum256(pkScript)
+	for i, j := 0, len(hash)-1; i < j; i, j = i+1, j-1 {
+		hash[i], hash[j] = hash[j], hash[i]
+	}
+	return hex.EncodeToString(hash[:])
+}
+
+// AddressScriptHash returns the Electrum script hash of address.
+func (c *ElectrumClient) AddressScriptHash(address string) (string, error) {
+	pkScript, err := c.addressScript(address)
+	if err != nil {
+		return "", err
+	}
+	return ElectrumScriptHash(pkScript), nil
+}
+
+// Subscribe subscribes to the status of scriptHash, returning the current
+// one. Changes are sent to Notifications.
+func (c *ElectrumClient) Subscribe(scriptHash string) (string, error) {
+	var status *string
+	if err := c.call("blockchain.scripthash.subscribe", []interface{}{scriptHash}, &status); err != nil {
+		return "", err
+	}
+	if status == nil {
+		return "", nil
+	}
+	return *status, nil
+}
+
+// SubscribeAddress subscribes to the status of address, see Subscribe.
+func (c *ElectrumClient) SubscribeAddress(address string) (string, error) {
+	scriptHash, err := c.AddressScriptHash(address)
+	if err != nil {
+		return "", err
+	}
+	return c.Subscribe(scriptHash)
+}
+
+// GetHistory returns the confirmed and mempool transactions of address.
+func (c *ElectrumClient) GetHistory(address string) ([]ElectrumHistoryItem, error) {
+	scriptHash, err := c.AddressScriptHash(address)
+	if err != nil {
+		return nil, err
+	}
+	var history []ElectrumHistoryItem
+	err = c.call("blockchain.scripthash.get_history", []interface{}{scriptHash}, &history)
+	return history, err
+}
+
+// GetBalance returns the confirmed and unconfirmed balance of address in satoshi.
+func (c *ElectrumClient) GetBalance(address string) (int64, int64, error) {
+	scriptHash, err := c.AddressScriptHash(address)
+	if err != nil {
+		return 0, 0, err
+	}
+	var balance struct {
+		Confirmed   int64 `json:"confirmed"`
+		Unconfirmed int64 `json:"unconfirmed"`
+	}
+	err = c.call("blockchain.scripthash.get_balance", []interface{}{scriptHash}, &balance)
+	return balance.Confirmed, balance.Unconfirmed, err
+}
+
+// ListUnspent returns the unspents of address, including mempool ones.
+func (c *ElectrumClient) ListUnspent(address string) ([]BtcUnspent, error) {
+	pkScript, err := c.addressScript(address)
+	if err != nil {
+		return nil, err
+	}
+	var utxos []electrumUnspent
+	err = c.call("blockchain.scripthash.listunspent", []interface{}{ElectrumScriptHash(pkScript)}, &utxos)
+	if err != nil {
+		return nil, err
+	}
+	tipHeight, err := c.TipHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	scriptType := txscript.GetScriptClass(pkScript).String()
+	unspents := make([]BtcUnspent, 0, len(utxos))
+	for _, utxo := range utxos {
+		u := BtcUnspent{TxID: utxo.TxHash, Vout: utxo.TxPos, ScriptPubKey: hex.EncodeToString(pkScript),
+			Amount: utxo.Value, Address: address, ScriptType: scriptType}
+		if utxo.Height > 0 && tipHeight >= utxo.Height {
+			u.Confirmations = tipHeight - utxo.Height + 1
+		}
+		unspents = append(unspents, u)
+	}
+	return unspents, nil
+}
+
+// TipHeight returns the height of the best block. The first call subscribes
+// to headers, so later calls are answered from the notifications.
+func (c *ElectrumClient) TipHeight() (int64, error) {
+	c.mu.Lock()
+	tipHeight := c.tipHeight
+	c.mu.Unlock()
+	if tipHeight > 0 {
+		return tipHeight, nil
+	}
+
+	var header struct {
+		Height int64 `json:"height"`
+	}
+	if err := c.call("blockchain.headers.subscribe", nil, &header); err != nil {
+		return 0, err
+	}
+	c.setTipHeight(header.Height)
+	return header.Height, nil
+}
+
+// GetRawTransaction returns the transaction of txid.
+func (c *ElectrumClient) GetRawTransaction(txid string) (*wire.MsgTx, error) {
+	var rawHex string
+	if err := c.call("blockchain.transaction.get", []interface{}{txid}, &rawHex); err != nil {
+		return nil, err
+	}
+	return DeserializeMsgTx(rawHex)
+}
+
+// SendRawTransaction broadcasts a signed transaction and returns its txid.
+func (c *ElectrumClient) SendRawTransaction(signedHex string) (string, error) {
+	var txid string
+	err := c.call("blockchain.transaction.broadcast", []interface{}{signedHex}, &txid)
+	if err == nil && txid == "" {
+		err = errors.New("unknown response")
+	}
+	return txid, err
+}
+
+// EstimateFeePerKb estimates the fee rate in sat/kvB for confirmation within blocks.
+func (c *ElectrumClient) EstimateFeePerKb(blocks int) (int64, error) {
+	var feeRate float64
+	if err := c.call("blockchain.estimatefee", []interface{}{blocks}, &feeRate); err != nil {
+		return 0, err
+	}
+	if feeRate <= 0 {
+		// -1 if the server has no estimate
+		return 0, ErrFeeNotAvailable
+	}
+	return btcToSatoshi(feeRate), nil
+}
+
+func (c *ElectrumClient) addressScript(address string) ([]byte, error) {
+	addr, err := btcutil.DecodeAddress(address, c.chainParams)
+	if err != nil {
+		return nil, err
+	}
+	if !addr.IsForNet(c.chainParams) {
+		return nil, errors.New("address is not the corresponding network address")
+	}
+	return txscript.PayToAddrScript(addr)
+}
+
+func (c *ElectrumClient) call(method string, params []interface{}, result interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	respCh := make(chan *electrumMessage, 1)
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.nextID++
+	id := c.nextID
+	req, err := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": id, "method": method, "params": params})
+	if err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	c.pending[id] = respCh
+	c.mu.Unlock()
+
+	c.writeMu.Lock()
+	err = c.conn.SetWriteDeadline(time.Now().Add(c.Timeout))
+	if err == nil {
+		_, err = c.conn.Write(append(req, '\n'))
+	}
+	c.writeMu.Unlock()
+	if err != nil {
+		c.shutdown(err)
+		return err
+	}
+
+	timer := time.NewTimer(c.Timeout)
+	defer timer.Stop()
+	var resp *electrumMessage
+	select {
+	case resp = <-respCh:
+	case <-c.closed:
+		return c.closeErr()
+	case <-timer.C:
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return fmt.Errorf("electrum request %s timed out", method)
+	}
+
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(resp.Result, result)
+}
+
+func (c *ElectrumClient) readLoop() {
+	reader := bufio.NewReader(c.conn)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			c.shutdown(err)
+			return
+		}
+		var msg electrumMessage
+		if err = json.Unmarshal(line, &msg); err != nil {
+			c.shutdown(fmt.Errorf("invalid electrum message: %w", err))
+			return
+		}
+
+		if msg.ID != nil {
+			c.mu.Lock()
+			respCh, ok := c.pending[*msg.ID]
+			delete(c.pending, *msg.ID)
+			c.mu.Unlock()
+			if ok {
+				respCh <- &msg
+			}
+			continue
+		}
+		c.handleNotification(&msg)
+	}
+}
+
+func (c *ElectrumClient) handleNotification(msg *electrumMessage) {
+	switch msg.Method {
+	case "blockchain.scripthash.subscribe":
+		if len(msg.Params) != 2 {
+			return
+		}
+		var n ElectrumNotification
+		var status *string
+		if json.Unmarshal(msg.Params[0], &n.ScriptHash) != nil || json.Unmarshal(msg.Params[1], &status) != nil {
+			return
+		}
+		if status != nil {
+			n.Status = *status
+		}
+		full := false
+		c.mu.Lock()
+		if c.err == nil {
+			select {
+			case c.notifications <- n:
+			default:
+				full = true
+			}
+		}
+		c.mu.Unlock()
+		if full {
+			c.shutdown(ErrElectrumNotificationsFull)
+		}
+
+	case "blockchain.headers.subscribe":
+		for _, param := range msg.Params {
+			var header struct {
+				Height int64 `json:"height"`
+			}
+			if json.Unmarshal(param, &header) == nil && header.Height > 0 {
+				c.setTipHeight(header.Height)
+			}
+		}
+	}
+}
+
+func (c *ElectrumClient) setTipHeight(height int64) {
+	c.mu.Lock()
+	c.tipHeight = height
+	c.mu.Unlock()
+}
+
+func (c *ElectrumClient) shutdown(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	c.conn.Close()
+	close(c.closed)
+	close(c.notifications)
+}
+
+func (c *ElectrumClient) closeErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
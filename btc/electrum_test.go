@@ -0,0 +1,208 @@
+package btc
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/lizc2003/hdwallet/wallet"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeElectrumServer answers requests with results[method]. A subscription
+// to a script hash is followed by a notification of status "changed".
+type fakeElectrumServer struct {
+	listener net.Listener
+	results  map[string]interface{}
+	requests chan []interface{}
+}
+
+func newFakeElectrumServer(t *testing.T, tlsConfig *tls.Config, results map[string]interface{}) *fakeElectrumServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	s := &fakeElectrumServer{listener: listener, results: results, requests: make(chan []interface{}, 100)}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeElectrumServer) serve(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	enc := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var req struct {
+			ID     uint64        `json:"id"`
+			Method string        `json:"method"`
+			Params []interface{} `json:"params"`
+		}
+		if json.Unmarshal(scanner.Bytes(), &req) != nil {
+			return
+		}
+		s.requests <- append([]interface{}{req.Method}, req.Params...)
+		resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+		if result, ok := s.results[req.Method]; ok {
+			resp["result"] = result
+		} else {
+			resp["error"] = map[string]interface{}{"code": -32601, "message": "unknown method"}
+		}
+		if enc.Encode(resp) != nil {
+			return
+		}
+		if req.Method == "blockchain.scripthash.subscribe" {
+			enc.Encode(map[string]interface{}{"jsonrpc": "2.0", "method": req.Method,
+				"params": []interface{}{req.Params[0], "changed"}})
+		}
+	}
+}
+
+func (s *fakeElectrumServer) url(scheme string) string {
+	return fmt.Sprintf("%s://%s", scheme, s.listener.Addr())
+}
+
+func TestElectrumClient(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	addr, err := btcutil.NewAddressWitnessPubKeyHash(bytes.Repeat([]byte{1}, 20), params)
+	require.NoError(t, err)
+	pkScript, err := txscript.PayToAddrScript(addr)
+	require.NoError(t, err)
+	scriptHash := ElectrumScriptHash(pkScript)
+
+	srv := newFakeElectrumServer(t, nil, map[string]interface{}{
+		"server.version":               []string{"Fulcrum 1.9.0", "1.4"},
+		"blockchain.headers.subscribe": map[string]interface{}{"height": 200, "hex": "00"},
+		"blockchain.scripthash.listunspent": []map[string]interface{}{
+			{"tx_hash": fmt.Sprintf("%064x", 1), "tx_pos": 0, "height": 191, "value": 60000},
+			{"tx_hash": fmt.Sprintf("%064x", 2), "tx_pos": 1, "height": 0, "value": 50000},
+		},
+		"blockchain.scripthash.get_history": []map[string]interface{}{
+			{"tx_hash": fmt.Sprintf("%064x", 1), "height": 191},
+			{"tx_hash": fmt.Sprintf("%064x", 2), "height": 0, "fee": 200},
+		},
+		"blockchain.scripthash.get_balance": map[string]interface{}{"confirmed": 60000, "unconfirmed": 50000},
+		"blockchain.scripthash.subscribe":   nil,
+		"blockchain.transaction.broadcast":  fmt.Sprintf("%064x", 3),
+		"blockchain.estimatefee":            0.00015,
+	})
+	cli, err := NewElectrumClient(srv.url("tcp"), wallet.BtcChainRegtest, nil)
+	require.NoError(t, err)
+	defer cli.Close()
+	require.Equal(t, []interface{}{"server.version", electrumClientName, ElectrumProtocolVersion}, <-srv.requests)
+
+	unspents, err := cli.ListUnspent(addr.String())
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{"blockchain.scripthash.listunspent", scriptHash}, <-srv.requests)
+	require.Len(t, unspents, 2)
+	require.Equal(t, BtcUnspent{TxID: fmt.Sprintf("%064x", 1), Vout: 0, ScriptPubKey: hexString(pkScript),
+		Amount: 60000, Address: addr.String(), ScriptType: "witness_v0_keyhash", Confirmations: 10}, unspents[0])
+	require.Equal(t, int64(0), unspents[1].Confirmations)
+
+	// The unspents can be spent.
+	tx, err := NewBtcTransaction(unspents, []BtcOutput{{Address: addr, Amount: 100000}}, addr, 1000, params)
+	require.NoError(t, err)
+	require.Len(t, tx.Tx.TxIn, 2)
+
+	history, err := cli.GetHistory(addr.String())
+	require.NoError(t, err)
+	require.Equal(t, []ElectrumHistoryItem{{TxHash: fmt.Sprintf("%064x", 1), Height: 191},
+		{TxHash: fmt.Sprintf("%064x", 2), Height: 0, Fee: 200}}, history)
+
+	confirmed, unconfirmed, err := cli.GetBalance(addr.String())
+	require.NoError(t, err)
+	require.Equal(t, int64(60000), confirmed)
+	require.Equal(t, int64(50000), unconfirmed)
+
+	status, err := cli.SubscribeAddress(addr.String())
+	require.NoError(t, err)
+	require.Equal(t, "", status)
+	select {
+	case n := <-cli.Notifications():
+		require.Equal(t, ElectrumNotification{ScriptHash: scriptHash, Status: "changed"}, n)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification")
+	}
+
+	txid, err := cli.SendRawTransaction("0100")
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprintf("%064x", 3), txid)
+
+	feePerKb, err := cli.EstimateFeePerKb(6)
+	require.NoError(t, err)
+	require.Equal(t, int64(15000), feePerKb)
+
+	err = cli.Ping()
+	var electrumErr *ElectrumError
+	require.ErrorAs(t, err, &electrumErr)
+	require.Equal(t, -32601, electrumErr.Code)
+
+	_, err = cli.ListUnspent("invalid")
+	require.Error(t, err)
+
+	require.NoError(t, cli.Close())
+	_, err = cli.GetHistory(addr.String())
+	require.ErrorIs(t, err, ErrElectrumClosed)
+}
+
+func TestElectrumClient_NotificationsFull(t *testing.T) {
+	srv := newFakeElectrumServer(t, nil, map[string]interface{}{
+		"server.version":                  []string{"Fulcrum 1.9.0", "1.4"},
+		"blockchain.scripthash.subscribe": nil,
+	})
+	cli, err := NewElectrumClient(srv.url("tcp"), wallet.BtcChainRegtest, nil)
+	require.NoError(t, err)
+	defer cli.Close()
+	<-srv.requests
+
+	// The notification after a full channel closes the connection, it is not
+	// lost without a sign.
+	for i := 0; i <= electrumNotificationCap; i++ {
+		_, err = cli.Subscribe(fmt.Sprintf("%064x", i))
+		require.NoError(t, err)
+		<-srv.requests
+	}
+	n := 0
+	for range cli.Notifications() {
+		n++
+	}
+	require.Equal(t, electrumNotificationCap, n)
+	require.ErrorIs(t, cli.Ping(), ErrElectrumNotificationsFull)
+}
+
+func TestElectrumClient_TLS(t *testing.T) {
+	httpSrv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer httpSrv.Close()
+	srv := newFakeElectrumServer(t, httpSrv.TLS, map[string]interface{}{
+		"server.version":         []string{"ElectrumX 1.16.0", "1.4"},
+		"blockchain.estimatefee": -1,
+	})
+
+	_, err := NewElectrumClient(srv.url("ssl"), wallet.BtcChainRegtest, nil)
+	require.Error(t, err, "self signed certificate")
+
+	tlsConfig := httpSrv.Client().Transport.(*http.Transport).TLSClientConfig
+	cli, err := NewElectrumClient(srv.url("ssl"), wallet.BtcChainRegtest, tlsConfig)
+	require.NoError(t, err)
+	defer cli.Close()
+	_, err = cli.EstimateFeePerKb(2)
+	require.ErrorIs(t, err, ErrFeeNotAvailable)
+}
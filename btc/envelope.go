@@ -0,0 +1,212 @@
+package btc
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcwallet/wallet/txauthor"
+	"github.com/lizc2003/hdwallet/wallet"
+)
+
+// BtcUnsignedTx is the unsigned transaction of a BTC envelope.
+type BtcUnsignedTx struct {
+	// Tx is the raw transaction in hex. Inputs signed beforehand, e.g. with
+	// SignVaultInput, keep their witness.
+	Tx string `json:"tx"`
+	// Prevouts are the outputs spent by Tx, in the order of its inputs.
+	Prevouts []BtcUnspent `json:"prevouts"`
+	// PrevTxs are the previous transactions in hex of the inputs other than
+	// taproot, against which Prevouts are checked. Only taproot signatures
+	// commit to the values of all prevouts.
+	PrevTxs []string `json:"prevTxs,omitempty"`
+	// ChangeIndex is the index of the change output, -1 if none.
+	ChangeIndex int   `json:"changeIndex"`
+	FeePerKb    int64 `json:"feePerKb"`
+}
+
+// NewEnvelope exports the unsigned transaction with its prevouts, to be
+// signed offline by SignEnvelope. prevTxs are the previous transactions,
+// e.g. from FetchPrevTxs, required for the inputs other than taproot.
+func (t *BtcTransaction) NewEnvelope(prevTxs []*wire.MsgTx) (*wallet.Envelope, error) {
+	rawHex, err := SerializeMsgTx(t.Tx)
+	if err != nil {
+		return nil, err
+	}
+	u := BtcUnsignedTx{Tx: rawHex, Prevouts: make([]BtcUnspent, len(t.Tx.TxIn)),
+		ChangeIndex: t.ChangeIndex, FeePerKb: t.feePerKb}
+	byHash := make(map[chainhash.Hash]*wire.MsgTx, len(prevTxs))
+	for _, prevTx := range prevTxs {
+		byHash[prevTx.TxHash()] = prevTx
+	}
+	if err = t.checkPrevTxs(byHash); err != nil {
+		return nil, err
+	}
+	added := make(map[chainhash.Hash]bool)
+	for i, txIn := range t.Tx.TxIn {
+		hash := txIn.PreviousOutPoint.Hash
+		if prevTx, ok := byHash[hash]; ok && !added[hash] && !txscript.IsPayToTaproot(t.PrevScripts[i]) {
+			prevHex, err := SerializeMsgTx(prevTx)
+			if err != nil {
+				return nil, err
+			}
+			u.PrevTxs = append(u.PrevTxs, prevHex)
+			added[hash] = true
+		}
+		u.Prevouts[i] = newBtcUnspentFromTxOut(txIn.PreviousOutPoint,
+			wire.NewTxOut(int64(t.PrevInputValues[i]), t.PrevScripts[i]), t.chainParams)
+		if len(t.inputs) == len(t.Tx.TxIn) {
+			spend := t.inputs[i]
+			u.Prevouts[i].Spend = &spend
+		}
+	}
+	return wallet.NewUnsignedEnvelope(wallet.SymbolBtc, int(t.chainParams.Net), &u)
+}
+
+// TransactionFromEnvelope rebuilds the unsigned transaction of an envelope,
+// whose prevouts are checked against its previous transactions.
+func TransactionFromEnvelope(env *wallet.Envelope) (*BtcTransaction, error) {
+	var u BtcUnsignedTx
+	if err := env.DecodeUnsigned(wallet.SymbolBtc, &u); err != nil {
+		return nil, err
+	}
+	chainCfg, err := wallet.GetBtcChainParams(env.ChainId)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := DeserializeMsgTx(u.Tx)
+	if err != nil {
+		return nil, err
+	}
+	t, err := newBtcTransactionFromPrevouts(tx, u.Prevouts, chainCfg)
+	if err != nil {
+		return nil, err
+	}
+	prevTxs := make(map[chainhash.Hash]*wire.MsgTx, len(u.PrevTxs))
+	for _, prevHex := range u.PrevTxs {
+		prevTx, err := DeserializeMsgTx(prevHex)
+		if err != nil {
+			return nil, err
+		}
+		prevTxs[prevTx.TxHash()] = prevTx
+	}
+	if err = t.checkPrevTxs(prevTxs); err != nil {
+		return nil, err
+	}
+	if u.ChangeIndex >= len(tx.TxOut) {
+		return nil, errors.New("change index out of range")
+	}
+	t.ChangeIndex = u.ChangeIndex
+	t.feePerKb = u.FeePerKb
+	return t, nil
+}
+
+// DecodeEnvelope decodes the unsigned transaction of an envelope, so that it
+// can be reviewed on the offline host before signing.
+func DecodeEnvelope(env *wallet.Envelope, owner AddressOwner) (*DecodedTx, error) {
+	t, err := TransactionFromEnvelope(env)
+	if err != nil {
+		return nil, err
+	}
+	return t.DecodeDetails(owner)
+}
+
+// SignEnvelope signs the transaction of an envelope without network access
+// and returns the signed envelope. The transaction is checked against policy,
+// DefaultPolicy if nil, so that an absurd fee is refused. The input values
+// are checked against the previous transactions, see TransactionFromEnvelope,
+// so that the online host can't hide a fee in them.
+func SignEnvelope(env *wallet.Envelope, secrets txauthor.SecretsSource, policy *Policy) (*wallet.Envelope, error) {
+	t, err := TransactionFromEnvelope(env)
+	if err != nil {
+		return nil, err
+	}
+	if secrets.ChainParams().Net != t.chainParams.Net {
+		return nil, fmt.Errorf("%w: %s", wallet.ErrEnvelopeSymbol, t.chainParams.Name)
+	}
+	if policy != nil {
+		t.policy = policy
+	}
+	if err = t.CheckPolicy(t.policy); err != nil {
+		return nil, err
+	}
+	if err = t.SignWithSecretsSource(secrets); err != nil {
+		return nil, err
+	}
+	if err = t.CheckPolicy(t.policy); err != nil {
+		return nil, err
+	}
+	signedHex, err := t.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	return env.NewSignedEnvelope(signedHex, t.GetTxid()), nil
+}
+
+// BroadcastEnvelope broadcasts the transaction of a signed envelope.
+func BroadcastEnvelope(backend UtxoBackend, env *wallet.Envelope) (string, error) {
+	signedHex, err := env.SignedTx(wallet.SymbolBtc)
+	if err != nil {
+		return "", err
+	}
+	tx, err := DeserializeMsgTx(signedHex)
+	if err != nil {
+		return "", err
+	}
+	if env.TxID != "" && tx.TxHash().String() != env.TxID {
+		return "", errors.New("envelope txid does not match its transaction")
+	}
+	return backend.Broadcast(tx)
+}
+
+// checkPrevTxs checks the prevouts of the inputs other than taproot against
+// prevTxs. Taproot signatures commit to the values of all prevouts.
+func (t *BtcTransaction) checkPrevTxs(prevTxs map[chainhash.Hash]*wire.MsgTx) error {
+	for i, txIn := range t.Tx.TxIn {
+		if txscript.IsPayToTaproot(t.PrevScripts[i]) {
+			continue
+		}
+		prevTx, ok := prevTxs[txIn.PreviousOutPoint.Hash]
+		if !ok {
+			return fmt.Errorf("previous transaction %s required for input %d", txIn.PreviousOutPoint.Hash, i)
+		}
+		err := checkPrevTx(prevTx, txIn.PreviousOutPoint, wire.NewTxOut(int64(t.PrevInputValues[i]), t.PrevScripts[i]))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newBtcTransactionFromPrevouts wraps tx, whose inputs spend prevouts.
+func newBtcTransactionFromPrevouts(tx *wire.MsgTx, prevouts []BtcUnspent, chainCfg *chaincfg.Params) (*BtcTransaction, error) {
+	if len(prevouts) != len(tx.TxIn) {
+		return nil, errors.New("prevouts do not match the transaction inputs")
+	}
+	authored := txauthor.AuthoredTx{Tx: tx,
+		PrevScripts:     make([][]byte, len(prevouts)),
+		PrevInputValues: make([]btcutil.Amount, len(prevouts)),
+		ChangeIndex:     -1,
+	}
+	inputs := make([]InputDescriptor, len(prevouts))
+	for i, txIn := range tx.TxIn {
+		outPoint, err := prevouts[i].OutPoint()
+		if err != nil {
+			return nil, err
+		}
+		if outPoint != txIn.PreviousOutPoint {
+			return nil, fmt.Errorf("prevout %d does not match the transaction input", i)
+		}
+		if authored.PrevScripts[i], err = hex.DecodeString(prevouts[i].ScriptPubKey); err != nil {
+			return nil, fmt.Errorf("prevout %d: %w", i, err)
+		}
+		authored.PrevInputValues[i] = btcutil.Amount(prevouts[i].Amount)
+		authored.TotalInput += authored.PrevInputValues[i]
+		inputs[i] = prevouts[i].spendDescriptor()
+	}
+	return &BtcTransaction{AuthoredTx: authored, chainParams: chainCfg, inputs: inputs}, nil
+}
@@ -0,0 +1,83 @@
+package btc
+
+import (
+	"bytes"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lizc2003/hdwallet/wallet"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestEnvelope(t *testing.T) {
+	wallets := newTestWallets(t)
+	chainParams := wallets[0].ChainParams()
+	prevTx, unspents := newFundingTx(t, wallets, 100000)
+	dest, err := btcutil.NewAddressWitnessPubKeyHash(bytes.Repeat([]byte{1}, 20), chainParams)
+	require.NoError(t, err)
+	tx, err := NewBtcTransaction(unspents, []BtcOutput{{Address: dest, Amount: 250000}},
+		wallets[2].DeriveNativeAddress(), 2000, chainParams)
+	require.NoError(t, err)
+
+	// online host
+	_, err = tx.NewEnvelope(nil)
+	require.Error(t, err)
+	env, err := tx.NewEnvelope([]*wire.MsgTx{prevTx})
+	require.NoError(t, err)
+	require.Equal(t, wallet.SymbolBtc, env.Symbol)
+	chunks, err := env.Chunks(0)
+	require.NoError(t, err)
+	require.Greater(t, len(chunks), 1)
+
+	// offline host
+	env, err = wallet.JoinEnvelopeChunks(chunks)
+	require.NoError(t, err)
+	decoded, err := DecodeEnvelope(env, nil)
+	require.NoError(t, err)
+	require.True(t, decoded.PrevoutsKnown)
+	require.Equal(t, tx.GetFee(), decoded.Fee)
+
+	_, err = SignEnvelope(env, multiWallet(wallets[:2]), nil)
+	require.Error(t, err)
+	signedEnv, err := SignEnvelope(env, multiWallet(wallets), nil)
+	require.NoError(t, err)
+	require.Empty(t, signedEnv.Unsigned)
+	_, err = SignEnvelope(signedEnv, multiWallet(wallets), nil)
+	require.ErrorIs(t, err, wallet.ErrEnvelopeSigned)
+	_, err = SignEnvelope(env, multiWallet(wallets), &Policy{MaxFee: tx.GetFee() - 1})
+	require.ErrorIs(t, err, ErrFeeTooHigh)
+
+	// A prevout value of the online host must be the one of its transaction.
+	var u BtcUnsignedTx
+	require.NoError(t, env.DecodeUnsigned(wallet.SymbolBtc, &u))
+	u.Prevouts[0].Amount += 10000
+	tampered, err := wallet.NewUnsignedEnvelope(wallet.SymbolBtc, env.ChainId, &u)
+	require.NoError(t, err)
+	_, err = SignEnvelope(tampered, multiWallet(wallets), nil)
+	require.Error(t, err)
+	u.Prevouts[0].Amount -= 10000
+	u.PrevTxs = nil
+	tampered, err = wallet.NewUnsignedEnvelope(wallet.SymbolBtc, env.ChainId, &u)
+	require.NoError(t, err)
+	_, err = SignEnvelope(tampered, multiWallet(wallets), nil)
+	require.Error(t, err)
+
+	// The envelope is for regtest.
+	other := *env
+	other.ChainId = int(chaincfg.MainNetParams.Net)
+	_, err = SignEnvelope(&other, multiWallet(wallets), nil)
+	require.ErrorIs(t, err, wallet.ErrEnvelopeSymbol)
+
+	// online host
+	data, err := signedEnv.Marshal()
+	require.NoError(t, err)
+	signedEnv, err = wallet.UnmarshalEnvelope(data)
+	require.NoError(t, err)
+	require.NoError(t, tx.SignWithSecretsSource(multiWallet(wallets)))
+	txid, err := BroadcastEnvelope(&memoryBackend{}, signedEnv)
+	require.NoError(t, err)
+	require.Equal(t, tx.GetTxid(), txid)
+	_, err = BroadcastEnvelope(&memoryBackend{}, env)
+	require.ErrorIs(t, err, wallet.ErrEnvelopeNotSigned)
+}
@@ -0,0 +1,153 @@
+package btc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lizc2003/hdwallet/wallet"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EsploraClient talks to the REST API of an Esplora server, e.g.
+// https://blockstream.info/api or https://mempool.space/api.
+type EsploraClient struct {
+	URL         string
+	HTTPClient  *http.Client
+	chainParams *chaincfg.Params
+}
+
+// EsploraError is a non 2xx response of an Esplora server.
+type EsploraError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *EsploraError) Error() string {
+	return fmt.Sprintf("esplora error %d: %s", e.StatusCode, e.Message)
+}
+
+func NewEsploraClient(URL string, chainId int) (*EsploraClient, error) {
+	chainParams, err := wallet.GetBtcChainParams(chainId)
+	if err != nil {
+		return nil, err
+	}
+	return &EsploraClient{URL: strings.TrimSuffix(URL, "/"),
+		HTTPClient:  &http.Client{Timeout: 30 * time.Second},
+		chainParams: chainParams}, nil
+}
+
+// ListUnspentByAddress returns the unspents of addresses, one request per address.
+func (c *EsploraClient) ListUnspentByAddress(addresses []string) ([]BtcUnspent, error) {
+	if _, err := decodeAddresses(addresses, c.chainParams); err != nil {
+		return nil, err
+	}
+	tipHeight, err := c.TipHeight()
+	if err != nil {
+		return nil, err
+	}
+	var unspents []BtcUnspent
+	for _, address := range addresses {
+		data, err := c.get("/address/" + address + "/utxo")
+		if err != nil {
+			return nil, err
+		}
+		u, err := NewBtcUnspentsFromEsplora(data, address, tipHeight, c.chainParams)
+		if err != nil {
+			return nil, err
+		}
+		unspents = append(unspents, u...)
+	}
+	return unspents, nil
+}
+
+func (c *EsploraClient) GetTransaction(txid string) (*wire.MsgTx, error) {
+	data, err := c.get("/tx/" + txid + "/hex")
+	if err != nil {
+		return nil, err
+	}
+	return DeserializeMsgTx(strings.TrimSpace(string(data)))
+}
+
+func (c *EsploraClient) Broadcast(tx *wire.MsgTx) (string, error) {
+	signedHex, err := SerializeMsgTx(tx)
+	if err != nil {
+		return "", err
+	}
+	data, err := c.do(http.MethodPost, "/tx", strings.NewReader(signedHex))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// EstimateFeeRate uses the estimate of the largest target up to confTarget
+// in /fee-estimates, which has targets 1-25, 144, 504 and 1008.
+func (c *EsploraClient) EstimateFeeRate(confTarget int64) (int64, error) {
+	data, err := c.get("/fee-estimates")
+	if err != nil {
+		return 0, err
+	}
+	var estimates map[string]float64
+	if err = json.Unmarshal(data, &estimates); err != nil {
+		return 0, err
+	}
+
+	targets := make([]int64, 0, len(estimates))
+	for k := range estimates {
+		if target, err := strconv.ParseInt(k, 10, 64); err == nil {
+			targets = append(targets, target)
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i] > targets[j] })
+	for _, target := range targets {
+		if target <= confTarget {
+			if satPerVByte := estimates[strconv.FormatInt(target, 10)]; satPerVByte > 0 {
+				return SatPerVByteToFeePerKb(satPerVByte), nil
+			}
+			break
+		}
+	}
+	return 0, ErrFeeNotAvailable
+}
+
+func (c *EsploraClient) TipHeight() (int64, error) {
+	data, err := c.get("/blocks/tip/height")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+func (c *EsploraClient) get(path string) ([]byte, error) {
+	return c.do(http.MethodGet, path, nil)
+}
+
+func (c *EsploraClient) do(method, path string, body io.Reader) ([]byte, error) {
+	req, err := http.NewRequest(method, c.URL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &EsploraError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+	}
+	if len(data) == 0 {
+		return nil, errors.New("empty response")
+	}
+	return data, nil
+}
@@ -0,0 +1,183 @@
+package btc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcjson"
+)
+
+const (
+	EstimateModeConservative btcjson.EstimateSmartFeeMode = "CONSERVATIVE"
+	EstimateModeEconomical   btcjson.EstimateSmartFeeMode = "ECONOMICAL"
+)
+
+// Sources of a FeeEstimate.
+const (
+	FeeSourceSmartFee = "estimatesmartfee"
+	FeeSourceMempool  = "mempool"
+	FeeSourceFallback = "fallback"
+)
+
+var ErrFeeNotAvailable = errors.New("fee not available")
+
+// FeePriority is a confirmation speed tier.
+type FeePriority int
+
+const (
+	FeePriorityFast FeePriority = iota
+	FeePriorityNormal
+	FeePrioritySlow
+)
+
+// ConfTarget returns the confirmation target in blocks of the priority.
+func (p FeePriority) ConfTarget() int64 {
+	switch p {
+	case FeePriorityFast:
+		return 2
+	case FeePrioritySlow:
+		return 24
+	default:
+		return 6
+	}
+}
+
+// EstimateMode returns CONSERVATIVE for fast payments, which reacts quicker
+// to rising fees, and ECONOMICAL otherwise.
+func (p FeePriority) EstimateMode() btcjson.EstimateSmartFeeMode {
+	if p == FeePriorityFast {
+		return EstimateModeConservative
+	}
+	return EstimateModeEconomical
+}
+
+func (p FeePriority) String() string {
+	switch p {
+	case FeePriorityFast:
+		return "fast"
+	case FeePriorityNormal:
+		return "normal"
+	case FeePrioritySlow:
+		return "slow"
+	default:
+		return fmt.Sprintf("FeePriority(%d)", int(p))
+	}
+}
+
+// FeeOptions bound the estimated fee rate. Zero values use the defaults.
+type FeeOptions struct {
+	// MinFeePerKb is the floor, DefaultMinRelayFeePerKb by default.
+	MinFeePerKb int64
+	// MaxFeePerKb is the ceiling, no ceiling by default.
+	MaxFeePerKb int64
+	// FallbackFeePerKb is used when the node has no estimate, e.g. on a
+	// fresh node or regtest. Without it the mempool minimum fee is used.
+	FallbackFeePerKb int64
+}
+
+type FeeEstimate struct {
+	FeePerKb int64
+	// ConfTarget is the number of blocks the estimate is valid for, as
+	// reported by the node. It may differ from the requested target.
+	ConfTarget int64
+	Source     string
+}
+
+// SatPerVByte returns the fee rate in sat/vB.
+func (e *FeeEstimate) SatPerVByte() float64 {
+	return float64(e.FeePerKb) / 1000
+}
+
+// SatPerVByteToFeePerKb converts a fee rate in sat/vB to sat/kvB.
+func SatPerVByteToFeePerKb(satPerVByte float64) int64 {
+	return int64(satPerVByte*1000 + 0.5)
+}
+
+type mempoolInfo struct {
+	MempoolMinFee float64 `json:"mempoolminfee"`
+	MinRelayTxFee float64 `json:"minrelaytxfee"`
+}
+
+// EstimateFeePerKb estimates the fee rate for confirmation within 6 blocks.
+func (this *BtcClient) EstimateFeePerKb() (int64, error) {
+	estimate, err := this.EstimateFee(FeePriorityNormal.ConfTarget(), "", nil)
+	if err != nil {
+		return 0, err
+	}
+	return estimate.FeePerKb, nil
+}
+
+// EstimateFeeByPriority estimates the fee rate of a priority tier, to be
+// passed to NewBtcTransaction.
+func (this *BtcClient) EstimateFeeByPriority(priority FeePriority, opts *FeeOptions) (*FeeEstimate, error) {
+	return this.EstimateFee(priority.ConfTarget(), priority.EstimateMode(), opts)
+}
+
+// EstimateFee estimates the fee rate for confirmation within confTarget
+// blocks with estimatesmartfee. An empty mode uses the node default. If the
+// node has no estimate, opts.FallbackFeePerKb or the minimum fee accepted by
+// the mempool (getmempoolinfo) is used. The result is clamped to the floor and
+// ceiling of opts.
+func (this *BtcClient) EstimateFee(confTarget int64, mode btcjson.EstimateSmartFeeMode, opts *FeeOptions) (*FeeEstimate, error) {
+	if opts == nil {
+		opts = &FeeOptions{}
+	}
+	if confTarget < 1 {
+		return nil, errors.New("wrong params")
+	}
+	var modeArg *btcjson.EstimateSmartFeeMode
+	if mode != "" {
+		modeArg = &mode
+	}
+
+	estimate := &FeeEstimate{ConfTarget: confTarget}
+	feeResult, err := this.RpcClient.EstimateSmartFee(confTarget, modeArg)
+	if err != nil {
+		return nil, err
+	}
+	if feeResult.FeeRate != nil && *feeResult.FeeRate > 0 {
+		estimate.FeePerKb = btcToSatoshi(*feeResult.FeeRate)
+		estimate.Source = FeeSourceSmartFee
+		if feeResult.Blocks > 0 {
+			estimate.ConfTarget = feeResult.Blocks
+		}
+	} else if opts.FallbackFeePerKb > 0 {
+		estimate.FeePerKb = opts.FallbackFeePerKb
+		estimate.Source = FeeSourceFallback
+	} else {
+		info, err := this.getMempoolInfo()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrFeeNotAvailable, err)
+		}
+		estimate.FeePerKb = btcToSatoshi(info.MempoolMinFee)
+		if minRelay := btcToSatoshi(info.MinRelayTxFee); minRelay > estimate.FeePerKb {
+			estimate.FeePerKb = minRelay
+		}
+		estimate.Source = FeeSourceMempool
+	}
+
+	minFee := opts.MinFeePerKb
+	if minFee <= 0 {
+		minFee = DefaultMinRelayFeePerKb
+	}
+	if estimate.FeePerKb < minFee {
+		estimate.FeePerKb = minFee
+	}
+	if opts.MaxFeePerKb > 0 && estimate.FeePerKb > opts.MaxFeePerKb {
+		estimate.FeePerKb = opts.MaxFeePerKb
+	}
+	return estimate, nil
+}
+
+// getMempoolInfo returns the mempool fees, which btcjson.GetMempoolInfoResult lacks.
+func (this *BtcClient) getMempoolInfo() (*mempoolInfo, error) {
+	resp, err := this.RpcClient.RawRequest("getmempoolinfo", nil)
+	if err != nil {
+		return nil, err
+	}
+	var info mempoolInfo
+	if err = json.Unmarshal(resp, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
@@ -0,0 +1,71 @@
+package btc
+
+import (
+	"encoding/json"
+	"github.com/lizc2003/hdwallet/wallet"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newFakeBtcClient returns a client of a JSON-RPC server answering with results[method].
+func newFakeBtcClient(t *testing.T, results map[string]interface{}) *BtcClient {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     interface{}       `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		resp := map[string]interface{}{"id": req.ID, "result": nil, "error": nil}
+		if result, ok := results[req.Method]; ok {
+			resp["result"] = result
+		} else {
+			resp["error"] = map[string]interface{}{"code": -32601, "message": "Method not found"}
+		}
+		require.NoError(t, json.NewEncoder(rw).Encode(resp))
+	}))
+	t.Cleanup(srv.Close)
+
+	cli, err := NewBtcClient(srv.URL, "user", "pass", wallet.BtcChainRegtest)
+	require.NoError(t, err)
+	t.Cleanup(cli.RpcClient.Shutdown)
+	return cli
+}
+
+func TestEstimateFee(t *testing.T) {
+	cli := newFakeBtcClient(t, map[string]interface{}{
+		"estimatesmartfee": map[string]interface{}{"feerate": 0.00012345, "blocks": 3},
+	})
+	estimate, err := cli.EstimateFeeByPriority(FeePriorityFast, nil)
+	require.NoError(t, err)
+	require.Equal(t, &FeeEstimate{FeePerKb: 12345, ConfTarget: 3, Source: FeeSourceSmartFee}, estimate)
+	require.Equal(t, 12.345, estimate.SatPerVByte())
+	require.Equal(t, int64(12345), SatPerVByteToFeePerKb(12.345))
+
+	estimate, err = cli.EstimateFee(6, EstimateModeEconomical, &FeeOptions{MaxFeePerKb: 10000})
+	require.NoError(t, err)
+	require.Equal(t, int64(10000), estimate.FeePerKb)
+	estimate, err = cli.EstimateFee(6, EstimateModeEconomical, &FeeOptions{MinFeePerKb: 20000})
+	require.NoError(t, err)
+	require.Equal(t, int64(20000), estimate.FeePerKb)
+
+	// A fresh node has no estimate.
+	cli = newFakeBtcClient(t, map[string]interface{}{
+		"estimatesmartfee": map[string]interface{}{"errors": []string{"Insufficient data or no feerate found"}, "blocks": 0},
+		"getmempoolinfo":   map[string]interface{}{"mempoolminfee": 0.00002, "minrelaytxfee": 0.00001},
+	})
+	feePerKb, err := cli.EstimateFeePerKb()
+	require.NoError(t, err)
+	require.Equal(t, int64(2000), feePerKb)
+	estimate, err = cli.EstimateFeeByPriority(FeePrioritySlow, &FeeOptions{FallbackFeePerKb: 5000})
+	require.NoError(t, err)
+	require.Equal(t, &FeeEstimate{FeePerKb: 5000, ConfTarget: 24, Source: FeeSourceFallback}, estimate)
+
+	cli = newFakeBtcClient(t, map[string]interface{}{
+		"estimatesmartfee": map[string]interface{}{"blocks": 0},
+	})
+	_, err = cli.EstimateFeePerKb()
+	require.ErrorIs(t, err, ErrFeeNotAvailable)
+}
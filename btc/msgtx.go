@@ -1,6 +1,7 @@
 package btc
 
 import (
+	"bytes"
 	"encoding/hex"
 	"github.com/btcsuite/btcd/blockchain"
 	"github.com/btcsuite/btcd/btcjson"
@@ -10,6 +11,28 @@ import (
 	"github.com/btcsuite/btcd/wire"
 )
 
+// SerializeMsgTx serializes tx to hex, as accepted by sendrawtransaction.
+func SerializeMsgTx(tx *wire.MsgTx) (string, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, tx.SerializeSize()))
+	if err := tx.Serialize(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf.Bytes()), nil
+}
+
+// DeserializeMsgTx parses a raw transaction in hex.
+func DeserializeMsgTx(rawHex string) (*wire.MsgTx, error) {
+	raw, err := hex.DecodeString(rawHex)
+	if err != nil {
+		return nil, err
+	}
+	tx := wire.NewMsgTx(wire.TxVersion)
+	if err = tx.Deserialize(bytes.NewReader(raw)); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
 func DecodeMsgTx(mtx *wire.MsgTx, chainParams *chaincfg.Params) *btcjson.TxRawDecodeResult {
 	return &btcjson.TxRawDecodeResult{
 		Txid:     mtx.TxHash().String(),
@@ -96,6 +119,10 @@ func createVoutList(mtx *wire.MsgTx, chainParams *chaincfg.Params, filterAddrMap
 		vout.ScriptPubKey.Asm = disbuf
 		vout.ScriptPubKey.Hex = hex.EncodeToString(v.PkScript)
 		vout.ScriptPubKey.Type = scriptClass.String()
+		if IsNullData(v.PkScript) {
+			// btcd only recognizes a single push of up to 80 bytes
+			vout.ScriptPubKey.Type = txscript.NullDataTy.String()
+		}
 		vout.ScriptPubKey.ReqSigs = int32(reqSigs)
 
 		voutList = append(voutList, vout)
@@ -0,0 +1,266 @@
+package btc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr/musig2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcwallet/wallet/txauthor"
+	"github.com/lizc2003/hdwallet/wallet"
+	"sort"
+)
+
+// MuSig2NonceSize is the size of a public nonce exchanged between signers.
+const MuSig2NonceSize = musig2.PubNonceSize
+
+var ErrMuSig2Session = errors.New("no musig2 session for input")
+
+// MuSig2Key is the n-of-n MuSig2 aggregation of the keys of the signers, the
+// key of a P2TR output spent by the key path with a single schnorr signature.
+type MuSig2Key struct {
+	// Keys are the keys of the signers, sorted as in BIP-327.
+	Keys []*btcec.PublicKey
+	// ScriptRoot is the root of the tapscript tree committed to by the
+	// output key, nil if the output has no script path (BIP-86).
+	ScriptRoot []byte
+
+	internalKey *btcec.PublicKey
+	outputKey   *btcec.PublicKey
+}
+
+// NewMuSig2Key aggregates the keys of the signers, their order does not
+// matter. scriptRoot is nil for a key path only output.
+func NewMuSig2Key(keys []*btcec.PublicKey, scriptRoot []byte) (*MuSig2Key, error) {
+	if len(keys) < 2 {
+		return nil, errors.New("wrong params")
+	}
+	sorted := make([]*btcec.PublicKey, len(keys))
+	copy(sorted, keys)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].SerializeCompressed(), sorted[j].SerializeCompressed()) < 0
+	})
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].IsEqual(sorted[i-1]) {
+			return nil, errors.New("duplicate musig2 key")
+		}
+	}
+
+	k := &MuSig2Key{Keys: sorted, ScriptRoot: scriptRoot}
+	aggKey, _, _, err := musig2.AggregateKeys(sorted, false, k.keyAggOption())
+	if err != nil {
+		return nil, err
+	}
+	k.internalKey = aggKey.PreTweakedKey
+	k.outputKey = aggKey.FinalKey
+	return k, nil
+}
+
+// InternalKey is the aggregated key before the taproot tweak.
+func (k *MuSig2Key) InternalKey() *btcec.PublicKey {
+	return k.internalKey
+}
+
+// OutputKey is the tweaked key of the P2TR output.
+func (k *MuSig2Key) OutputKey() *btcec.PublicKey {
+	return k.outputKey
+}
+
+func (k *MuSig2Key) Address(chainCfg *chaincfg.Params) (*btcutil.AddressTaproot, error) {
+	return btcutil.NewAddressTaproot(schnorr.SerializePubKey(k.outputKey), chainCfg)
+}
+
+func (k *MuSig2Key) PkScript() []byte {
+	return payToTaprootScript(k.outputKey)
+}
+
+func (k *MuSig2Key) hasKey(pubKey *btcec.PublicKey) bool {
+	for _, key := range k.Keys {
+		if key.IsEqual(pubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+func (k *MuSig2Key) keyAggOption() musig2.KeyAggOption {
+	if k.ScriptRoot != nil {
+		return musig2.WithTaprootKeyTweak(k.ScriptRoot)
+	}
+	return musig2.WithBIP86KeyTweak()
+}
+
+func (k *MuSig2Key) contextOption() musig2.ContextOption {
+	if k.ScriptRoot != nil {
+		return musig2.WithTaprootTweakCtx(k.ScriptRoot)
+	}
+	return musig2.WithBip86TweakCtx()
+}
+
+// MuSig2Signer is the state of one signer of a transaction, with a signing
+// session per input spending the MuSig2Key output. For each input, every
+// signer sends its public nonce to the others, then its partial signature to
+// the signer which combines them. A session is used for one signature only:
+// if the transaction changes after the nonces are exchanged, all signers
+// must start a new MuSig2Signer.
+type MuSig2Signer struct {
+	key      *MuSig2Key
+	ctx      *musig2.Context
+	sessions map[int]*musig2.Session
+	signed   map[int]bool
+}
+
+// NewMuSig2Signer creates the signer of w, whose key must be one of key.Keys.
+func NewMuSig2Signer(w *wallet.BtcWallet, key *MuSig2Key) (*MuSig2Signer, error) {
+	if w == nil || key == nil {
+		return nil, errors.New("wrong params")
+	}
+	if !key.hasKey(w.DeriveNativePublicKey()) {
+		return nil, wallet.ErrAddressNotMatch
+	}
+	ctx, err := musig2.NewContext(w.DeriveNativePrivateKey(), false,
+		musig2.WithKnownSigners(key.Keys), key.contextOption())
+	if err != nil {
+		return nil, err
+	}
+	return &MuSig2Signer{key: key, ctx: ctx,
+		sessions: make(map[int]*musig2.Session), signed: make(map[int]bool)}, nil
+}
+
+func (s *MuSig2Signer) Key() *MuSig2Key {
+	return s.key
+}
+
+// PublicNonce returns the nonce of the signer for input index, to be sent to
+// the other signers. A fresh nonce is generated on the first call.
+func (s *MuSig2Signer) PublicNonce(index int) ([MuSig2NonceSize]byte, error) {
+	session, ok := s.sessions[index]
+	if !ok {
+		var err error
+		session, err = s.ctx.NewSession()
+		if err != nil {
+			return [MuSig2NonceSize]byte{}, err
+		}
+		s.sessions[index] = session
+	}
+	return session.PublicNonce(), nil
+}
+
+// RegisterNonce registers the nonce of another signer for input index. It
+// returns true once the nonces of all signers are known.
+func (s *MuSig2Signer) RegisterNonce(index int, nonce [MuSig2NonceSize]byte) (bool, error) {
+	session, err := s.session(index)
+	if err != nil {
+		return false, err
+	}
+	return session.RegisterPubNonce(nonce)
+}
+
+// Sign returns the partial signature of the signer for input index of t,
+// which must spend the MuSig2Key output. The nonce of the session cannot be
+// used again, a second call returns an error.
+func (s *MuSig2Signer) Sign(t *BtcTransaction, index int) (*musig2.PartialSignature, error) {
+	session, err := s.session(index)
+	if err != nil {
+		return nil, err
+	}
+	sigHash, err := s.sigHash(t, index)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := session.Sign(sigHash)
+	if err != nil {
+		return nil, err
+	}
+	s.signed[index] = true
+	return sig, nil
+}
+
+// CombineSig adds the partial signature of another signer for input index.
+// Once all partial signatures are added, the final signature is verified and
+// set as the witness of the input, and true is returned. The signer must
+// have signed input index first.
+func (s *MuSig2Signer) CombineSig(t *BtcTransaction, index int, sig *musig2.PartialSignature) (bool, error) {
+	session, err := s.session(index)
+	if err != nil {
+		return false, err
+	}
+	if sig == nil {
+		return false, errors.New("wrong params")
+	}
+	if !s.signed[index] {
+		return false, fmt.Errorf("input %d must be signed first", index)
+	}
+	// Checked first as the session remembers the message it signed only.
+	sigHash, err := s.sigHash(t, index)
+	if err != nil {
+		return false, err
+	}
+	done, err := session.CombineSig(sig)
+	if err != nil || !done {
+		return false, err
+	}
+	finalSig := session.FinalSig()
+	if !finalSig.Verify(sigHash[:], s.key.outputKey) {
+		return false, errors.New("transaction changed after the input was signed")
+	}
+	t.Tx.TxIn[index].Witness = wire.TxWitness{finalSig.Serialize()}
+	return true, nil
+}
+
+func (s *MuSig2Signer) session(index int) (*musig2.Session, error) {
+	session, ok := s.sessions[index]
+	if !ok {
+		return nil, fmt.Errorf("%w %d", ErrMuSig2Session, index)
+	}
+	return session, nil
+}
+
+func (s *MuSig2Signer) sigHash(t *BtcTransaction, index int) ([32]byte, error) {
+	if index < 0 || index >= len(t.Tx.TxIn) {
+		return [32]byte{}, fmt.Errorf("input index out of range: %d", index)
+	}
+	if !bytes.Equal(t.PrevScripts[index], s.key.PkScript()) {
+		return [32]byte{}, errors.New("musig2 key does not match the spent output")
+	}
+	return t.TaprootKeySpendSigHash(index)
+}
+
+func payToTaprootScript(outputKey *btcec.PublicKey) []byte {
+	pkScript, _ := txscript.NewScriptBuilder().AddOp(txscript.OP_1).AddData(schnorr.SerializePubKey(outputKey)).Script()
+	return pkScript
+}
+
+// TaprootKeySpendSigHash returns the message signed by the key path spend of
+// taproot input index, with SigHashDefault.
+func (t *BtcTransaction) TaprootKeySpendSigHash(index int) ([32]byte, error) {
+	var sigHash [32]byte
+	if index < 0 || index >= len(t.Tx.TxIn) {
+		return sigHash, fmt.Errorf("input index out of range: %d", index)
+	}
+	if !txscript.IsPayToTaproot(t.PrevScripts[index]) {
+		return sigHash, fmt.Errorf("input %d does not spend a taproot output", index)
+	}
+	fetcher, err := txauthor.TXPrevOutFetcher(t.Tx, t.PrevScripts, t.PrevInputValues)
+	if err != nil {
+		return sigHash, err
+	}
+	h, err := txscript.CalcTaprootSignatureHash(txscript.NewTxSigHashes(t.Tx, fetcher),
+		txscript.SigHashDefault, t.Tx, index, fetcher)
+	if err != nil {
+		return sigHash, err
+	}
+	copy(sigHash[:], h)
+	return sigHash, nil
+}
+
+// Validate checks that all inputs are signed and their scripts execute, e.g.
+// once the inputs signed by MuSig2Signer are complete.
+func (t *BtcTransaction) Validate() error {
+	return validateMsgTx(t.Tx, t.PrevScripts, t.PrevInputValues)
+}
@@ -0,0 +1,119 @@
+package btc
+
+import (
+	"bytes"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr/musig2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/lizc2003/hdwallet/wallet"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestMuSig2Key(t *testing.T) {
+	wallets := newTestWallets(t)
+	k1, k2 := wallets[1].DeriveNativePublicKey(), wallets[2].DeriveNativePublicKey()
+
+	key, err := NewMuSig2Key([]*btcec.PublicKey{k1, k2}, nil)
+	require.NoError(t, err)
+	swapped, err := NewMuSig2Key([]*btcec.PublicKey{k2, k1}, nil)
+	require.NoError(t, err)
+	require.True(t, key.OutputKey().IsEqual(swapped.OutputKey()))
+	require.False(t, key.OutputKey().IsEqual(key.InternalKey()))
+
+	addr, err := key.Address(wallets[0].ChainParams())
+	require.NoError(t, err)
+	require.Equal(t, "bcrt1p", addr.EncodeAddress()[:6])
+
+	_, err = NewMuSig2Key([]*btcec.PublicKey{k1}, nil)
+	require.Error(t, err)
+	_, err = NewMuSig2Key([]*btcec.PublicKey{k1, k1}, nil)
+	require.Error(t, err)
+	_, err = NewMuSig2Signer(wallets[0], key)
+	require.ErrorIs(t, err, wallet.ErrAddressNotMatch)
+}
+
+func TestMuSig2_Spend(t *testing.T) {
+	wallets := newTestWallets(t)
+	chainParams := wallets[0].ChainParams()
+
+	for _, signers := range [][]*wallet.BtcWallet{wallets[1:], wallets} {
+		keys := make([]*btcec.PublicKey, len(signers))
+		for i, w := range signers {
+			keys[i] = w.DeriveNativePublicKey()
+		}
+		key, err := NewMuSig2Key(keys, nil)
+		require.NoError(t, err)
+		addr, err := key.Address(chainParams)
+		require.NoError(t, err)
+
+		// The MuSig2 input is spent with an input of wallets[2].
+		unspents := append(newTestUnspents(t, addr, 100000), newTestUnspents(t, wallets[2].DeriveNativeAddress(), 50000)...)
+		dest, err := btcutil.NewAddressWitnessPubKeyHash(bytes.Repeat([]byte{1}, 20), chainParams)
+		require.NoError(t, err)
+		tx, err := NewBtcTransaction(unspents, []BtcOutput{{Address: dest, Amount: 120000}},
+			wallets[2].DeriveNativeAddress(), 1000, chainParams)
+		require.NoError(t, err)
+		require.Len(t, tx.Tx.TxIn, 2)
+		index := 0
+		if !bytes.Equal(tx.PrevScripts[0], key.PkScript()) {
+			index = 1
+		}
+
+		parties := make([]*MuSig2Signer, len(signers))
+		nonces := make([][MuSig2NonceSize]byte, len(signers))
+		for i, w := range signers {
+			parties[i], err = NewMuSig2Signer(w, key)
+			require.NoError(t, err)
+			nonces[i], err = parties[i].PublicNonce(index)
+			require.NoError(t, err)
+		}
+		_, err = parties[0].Sign(tx, index)
+		require.ErrorIs(t, err, musig2.ErrCombinedNonceUnavailable)
+		for i, party := range parties {
+			for j, nonce := range nonces {
+				if i != j {
+					_, err = party.RegisterNonce(index, nonce)
+					require.NoError(t, err)
+				}
+			}
+		}
+
+		// The partial signatures are sent to parties[0], which combines them.
+		combinedNonce, err := musig2.AggregateNonces(nonces)
+		require.NoError(t, err)
+		_, err = parties[0].Sign(tx, index)
+		require.NoError(t, err)
+		_, err = parties[0].Sign(tx, index)
+		require.ErrorIs(t, err, musig2.ErrSigningContextReuse)
+		_, err = parties[0].Sign(tx, 1-index)
+		require.ErrorIs(t, err, ErrMuSig2Session)
+		for i := 1; i < len(parties); i++ {
+			sig, err := parties[i].Sign(tx, index)
+			require.NoError(t, err)
+			require.True(t, sig.Verify(nonces[i], combinedNonce, key.Keys, keys[i], mustTaprootSigHash(t, tx, index),
+				musig2.WithBip86SignTweak()))
+
+			var buf bytes.Buffer
+			require.NoError(t, sig.Encode(&buf))
+			var received musig2.PartialSignature
+			require.NoError(t, received.Decode(&buf))
+			done, err := parties[0].CombineSig(tx, index, &received)
+			require.NoError(t, err)
+			require.Equal(t, i == len(parties)-1, done)
+		}
+		require.Len(t, tx.Tx.TxIn[index].Witness, 1)
+		require.Len(t, tx.Tx.TxIn[index].Witness[0], 64)
+
+		require.Error(t, tx.Validate())
+		require.NoError(t, tx.Sign(wallets[2]))
+		require.NoError(t, tx.Validate())
+		require.LessOrEqual(t, tx.Weight(), int64(tx.estimateVSize()*4))
+	}
+}
+
+func mustTaprootSigHash(t *testing.T, tx *BtcTransaction, index int) [32]byte {
+	sigHash, err := tx.TaprootKeySpendSigHash(index)
+	require.NoError(t, err)
+	return sigHash
+}
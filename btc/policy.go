@@ -0,0 +1,195 @@
+package btc
+
+import (
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Bitcoin Core policy defaults.
+const (
+	DefaultDustRelayFeePerKb = 3000
+	DefaultMinRelayFeePerKb  = 1000
+	MaxStandardTxWeight      = 400000
+	DefaultMaxFee            = 10000000 // 0.1 BTC, -maxtxfee
+	DefaultMaxFeePerKb       = 10000000 // 0.1 BTC/kvB, sendrawtransaction maxfeerate
+	MaxStandardMultiSigKeys  = 3
+	// DefaultMaxDataCarrierSize is the size of an OP_RETURN script with 80 bytes of data.
+	DefaultMaxDataCarrierSize = 83
+)
+
+var (
+	ErrDustOutput        = errors.New("dust output")
+	ErrNonStandardScript = errors.New("non-standard output script")
+	ErrTxTooLarge        = errors.New("transaction weight exceeds standard limit")
+	ErrFeeBelowRelay     = errors.New("fee below minimum relay fee")
+	ErrFeeTooHigh        = errors.New("absurdly high fee")
+	ErrFeeRateTooHigh    = errors.New("absurdly high fee rate")
+	ErrDuplicateInput    = errors.New("duplicate input")
+	ErrNegativeFee       = errors.New("outputs exceed inputs")
+)
+
+// Policy is the set of standardness and sanity rules checked by CheckPolicy.
+// A zero limit disables the corresponding check.
+type Policy struct {
+	DustRelayFeePerKb int64
+	MinRelayFeePerKb  int64
+	MaxTxWeight       int64
+	MaxFee            int64
+	MaxFeePerKb       int64
+	// MaxDataCarrierSize limits the size of OP_RETURN output scripts.
+	MaxDataCarrierSize int
+}
+
+var DefaultPolicy = Policy{
+	DustRelayFeePerKb:  DefaultDustRelayFeePerKb,
+	MinRelayFeePerKb:   DefaultMinRelayFeePerKb,
+	MaxTxWeight:        MaxStandardTxWeight,
+	MaxFee:             DefaultMaxFee,
+	MaxFeePerKb:        DefaultMaxFeePerKb,
+	MaxDataCarrierSize: DefaultMaxDataCarrierSize,
+}
+
+// GetDustThreshold returns the smallest value of a non dust output paying to
+// pkScript, computed like Bitcoin Core: the cost of creating and spending it.
+func GetDustThreshold(pkScript []byte, dustRelayFeePerKb int64) int64 {
+	if txscript.IsUnspendable(pkScript) {
+		return 0
+	}
+	size := wire.NewTxOut(0, pkScript).SerializeSize()
+	if txscript.IsWitnessProgram(pkScript) {
+		// outpoint, sequence and a witness discounted by 4
+		size += 32 + 4 + 1 + 107/blockchain.WitnessScaleFactor + 4
+	} else {
+		size += 32 + 4 + 1 + 107 + 4
+	}
+	return int64(size) * dustRelayFeePerKb / 1000
+}
+
+// CheckOutput checks that txOut is standard and not dust.
+func (p *Policy) CheckOutput(txOut *wire.TxOut) error {
+	if txOut.Value < 0 || txOut.Value > btcutil.MaxSatoshi {
+		return fmt.Errorf("invalid output value: %d", txOut.Value)
+	}
+
+	if IsNullData(txOut.PkScript) {
+		if p.MaxDataCarrierSize > 0 && len(txOut.PkScript) > p.MaxDataCarrierSize {
+			return fmt.Errorf("%w: OP_RETURN script of %d bytes", ErrNonStandardScript, len(txOut.PkScript))
+		}
+		return nil
+	}
+
+	switch txscript.GetScriptClass(txOut.PkScript) {
+	case txscript.NonStandardTy:
+		return ErrNonStandardScript
+	case txscript.MultiSigTy:
+		numPubKeys, numSigs, err := txscript.CalcMultiSigStats(txOut.PkScript)
+		if err != nil {
+			return err
+		}
+		if numPubKeys > MaxStandardMultiSigKeys || numSigs < 1 {
+			return fmt.Errorf("%w: bare multisig %d-of-%d", ErrNonStandardScript, numSigs, numPubKeys)
+		}
+	}
+
+	if p.DustRelayFeePerKb > 0 {
+		if threshold := GetDustThreshold(txOut.PkScript, p.DustRelayFeePerKb); txOut.Value < threshold {
+			return fmt.Errorf("%w: %d below %d", ErrDustOutput, txOut.Value, threshold)
+		}
+	}
+	return nil
+}
+
+// IsNullData reports whether pkScript is OP_RETURN followed by data pushes.
+func IsNullData(pkScript []byte) bool {
+	return len(pkScript) > 0 && pkScript[0] == txscript.OP_RETURN &&
+		txscript.IsPushOnlyScript(pkScript[1:])
+}
+
+// NewOpReturnScript creates an OP_RETURN script pushing data. Its size is
+// checked by Policy, not here, as relay limits differ between nodes.
+func NewOpReturnScript(data []byte) ([]byte, error) {
+	return txscript.NewScriptBuilder().AddOp(txscript.OP_RETURN).AddData(data).Script()
+}
+
+// CheckTx checks the outputs, inputs, weight and fee of tx. inputValues are the
+// values of the spent outputs and weight is the signed (or estimated) weight.
+func (p *Policy) CheckTx(tx *wire.MsgTx, inputValues []btcutil.Amount, weight int64) error {
+	nullData := 0
+	for i, txOut := range tx.TxOut {
+		if err := p.CheckOutput(txOut); err != nil {
+			return fmt.Errorf("output %d: %w", i, err)
+		}
+		if IsNullData(txOut.PkScript) {
+			nullData++
+		}
+	}
+	if nullData > 1 {
+		return fmt.Errorf("%w: more than one OP_RETURN output", ErrNonStandardScript)
+	}
+
+	seen := make(map[wire.OutPoint]bool, len(tx.TxIn))
+	for _, txIn := range tx.TxIn {
+		if seen[txIn.PreviousOutPoint] {
+			return fmt.Errorf("%w: %v", ErrDuplicateInput, txIn.PreviousOutPoint)
+		}
+		seen[txIn.PreviousOutPoint] = true
+	}
+
+	if p.MaxTxWeight > 0 && weight > p.MaxTxWeight {
+		return fmt.Errorf("%w: %d", ErrTxTooLarge, weight)
+	}
+
+	var totalIn, totalOut int64
+	for _, v := range inputValues {
+		totalIn += int64(v)
+	}
+	for _, txOut := range tx.TxOut {
+		totalOut += txOut.Value
+	}
+	fee := totalIn - totalOut
+	if fee < 0 {
+		return ErrNegativeFee
+	}
+	vsize := int((weight + blockchain.WitnessScaleFactor - 1) / blockchain.WitnessScaleFactor)
+	if p.MinRelayFeePerKb > 0 && fee < feeForVSize(p.MinRelayFeePerKb, vsize) {
+		return fmt.Errorf("%w: %d for %d vbytes", ErrFeeBelowRelay, fee, vsize)
+	}
+	if p.MaxFee > 0 && fee > p.MaxFee {
+		return fmt.Errorf("%w: %d", ErrFeeTooHigh, fee)
+	}
+	if p.MaxFeePerKb > 0 && vsize > 0 && fee*1000/int64(vsize) > p.MaxFeePerKb {
+		return fmt.Errorf("%w: %d sat/kvB", ErrFeeRateTooHigh, fee*1000/int64(vsize))
+	}
+	return nil
+}
+
+// CheckPolicy checks the transaction against policy, DefaultPolicy if nil.
+// Before signing the weight is estimated from the spent output types.
+func (t *BtcTransaction) CheckPolicy(policy *Policy) error {
+	if policy == nil {
+		policy = &DefaultPolicy
+	}
+	return policy.CheckTx(t.Tx, t.PrevInputValues, t.Weight())
+}
+
+// Weight returns the weight of the signed transaction, or an estimate of it
+// if it is not signed yet.
+func (t *BtcTransaction) Weight() int64 {
+	if t.isSigned() {
+		return blockchain.GetTransactionWeight(btcutil.NewTx(t.Tx))
+	}
+	return int64(t.estimateVSize()) * blockchain.WitnessScaleFactor
+}
+
+func (t *BtcTransaction) isSigned() bool {
+	for _, txIn := range t.Tx.TxIn {
+		if len(txIn.SignatureScript) == 0 && len(txIn.Witness) == 0 {
+			return false
+		}
+	}
+	return len(t.Tx.TxIn) > 0
+}
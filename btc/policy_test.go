@@ -0,0 +1,66 @@
+package btc
+
+import (
+	"bytes"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestPolicy_DustThreshold(t *testing.T) {
+	wallets := newTestWallets(t)
+	expected := []int64{546, 540, 294}
+	for i, w := range wallets {
+		script, err := txscript.PayToAddrScript(w.DeriveNativeAddress())
+		require.NoError(t, err)
+		require.Equal(t, expected[i], GetDustThreshold(script, DefaultDustRelayFeePerKb))
+	}
+
+	nullData, err := txscript.NullDataScript([]byte("hello"))
+	require.NoError(t, err)
+	require.Equal(t, int64(0), GetDustThreshold(nullData, DefaultDustRelayFeePerKb))
+}
+
+func TestPolicy_Check(t *testing.T) {
+	wallets := newTestWallets(t)
+	w := wallets[2]
+	chainParams := w.ChainParams()
+	unspents := newTestUnspents(t, w.DeriveNativeAddress(), 100000, 200000)
+	dest, err := btcutil.NewAddressWitnessPubKeyHash(bytes.Repeat([]byte{1}, 20), chainParams)
+	require.NoError(t, err)
+
+	_, err = NewBtcTransaction(unspents, []BtcOutput{{Address: dest, Amount: 293}}, w.DeriveNativeAddress(), 1000, chainParams)
+	require.ErrorIs(t, err, ErrDustOutput)
+	_, err = NewBtcTransactionWithOptions(unspents, []BtcOutput{{Address: dest, Amount: 293}}, w.DeriveNativeAddress(),
+		1000, chainParams, &BtcTxOptions{Policy: &Policy{}})
+	require.NoError(t, err)
+
+	_, err = NewBtcTransactionWithOptions(unspents, []BtcOutput{{Address: dest, Amount: 1000}}, w.DeriveNativeAddress(),
+		100000, chainParams, &BtcTxOptions{Policy: &Policy{MaxFee: 10000}})
+	require.ErrorIs(t, err, ErrFeeTooHigh)
+	_, err = NewBtcTransactionWithOptions(unspents, []BtcOutput{{Address: dest, Amount: 1000}}, w.DeriveNativeAddress(),
+		200000, chainParams, &BtcTxOptions{Policy: &Policy{MaxFeePerKb: 100000}})
+	require.ErrorIs(t, err, ErrFeeRateTooHigh)
+	_, err = NewBtcTransactionWithOptions(unspents, []BtcOutput{{Address: dest, Amount: 1000}}, w.DeriveNativeAddress(),
+		1000, chainParams, &BtcTxOptions{Policy: &Policy{MaxTxWeight: 400}})
+	require.ErrorIs(t, err, ErrTxTooLarge)
+
+	tx, err := NewBtcTransaction(unspents, []BtcOutput{{Address: dest, Amount: 150000}}, w.DeriveNativeAddress(), 1000, chainParams)
+	require.NoError(t, err)
+	require.NoError(t, tx.Sign(w))
+	require.NoError(t, tx.CheckPolicy(nil))
+	require.Less(t, tx.Weight()-4*int64(tx.estimateVSize()), int64(8))
+
+	// Pay the whole fee to the change.
+	tx.Tx.TxOut[tx.ChangeIndex].Value += tx.GetFee() - 10
+	require.ErrorIs(t, tx.CheckPolicy(nil), ErrFeeBelowRelay)
+	tx.Tx.TxOut[tx.ChangeIndex].Value += 20
+	require.ErrorIs(t, tx.CheckPolicy(nil), ErrNegativeFee)
+
+	tx.Tx.TxIn = append(tx.Tx.TxIn, tx.Tx.TxIn[0])
+	require.ErrorIs(t, tx.CheckPolicy(nil), ErrDuplicateInput)
+
+	require.ErrorIs(t, DefaultPolicy.CheckOutput(wire.NewTxOut(1000, []byte{txscript.OP_TRUE})), ErrNonStandardScript)
+}
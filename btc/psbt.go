@@ -0,0 +1,753 @@
+package btc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lizc2003/hdwallet/wallet"
+	"io"
+	"strings"
+)
+
+var ErrPsbtMismatch = errors.New("psbts are for different transactions")
+
+// PsbtSource provides the optional data used when exporting a BtcTransaction to PSBT.
+type PsbtSource struct {
+	// PrevTxs are the full previous transactions, required for non segwit
+	// inputs. They are added for segwit v0 inputs too, which hardware wallets
+	// require against the fee overpayment attack.
+	PrevTxs []*wire.MsgTx
+	// Wallets owning the inputs or the change, used to add redeem scripts and
+	// BIP32 derivation info.
+	Wallets []*wallet.BtcWallet
+}
+
+// ToPsbt exports the unsigned transaction as a BIP174 packet.
+func (t *BtcTransaction) ToPsbt(src *PsbtSource) (*psbt.Packet, error) {
+	if src == nil {
+		src = &PsbtSource{}
+	}
+	unsignedTx := t.Tx.Copy()
+	for _, txIn := range unsignedTx.TxIn {
+		txIn.SignatureScript = nil
+		txIn.Witness = nil
+	}
+
+	p, err := psbt.NewFromUnsignedTx(unsignedTx)
+	if err != nil {
+		return nil, err
+	}
+	u, err := psbt.NewUpdater(p)
+	if err != nil {
+		return nil, err
+	}
+
+	prevTxs := make(map[chainhash.Hash]*wire.MsgTx, len(src.PrevTxs))
+	for _, tx := range src.PrevTxs {
+		prevTxs[tx.TxHash()] = tx
+	}
+
+	for i, txIn := range unsignedTx.TxIn {
+		prevScript := t.PrevScripts[i]
+		txOut := wire.NewTxOut(int64(t.PrevInputValues[i]), prevScript)
+		w := findWalletByScript(src.Wallets, prevScript)
+
+		prevTx, hasPrevTx := prevTxs[txIn.PreviousOutPoint.Hash]
+		if hasPrevTx {
+			if err = checkPrevTx(prevTx, txIn.PreviousOutPoint, txOut); err != nil {
+				return nil, err
+			}
+		}
+
+		class := txscript.GetScriptClass(prevScript)
+		switch class {
+		case txscript.WitnessV1TaprootTy:
+			err = u.AddInWitnessUtxo(txOut, i)
+		case txscript.WitnessV0PubKeyHashTy, txscript.WitnessV0ScriptHashTy:
+			if hasPrevTx {
+				err = u.AddInNonWitnessUtxo(prevTx, i)
+			}
+			if err == nil {
+				err = u.AddInWitnessUtxo(txOut, i)
+			}
+		case txscript.ScriptHashTy:
+			// Only the redeem script of the wallet shows a nested segwit input.
+			nested := w != nil && w.RedeemScript() != nil
+			if !hasPrevTx && !nested {
+				return nil, fmt.Errorf("previous transaction %s required for P2SH input %d",
+					txIn.PreviousOutPoint.Hash, i)
+			}
+			if hasPrevTx {
+				err = u.AddInNonWitnessUtxo(prevTx, i)
+			}
+			if err == nil && nested {
+				err = u.AddInWitnessUtxo(txOut, i)
+			}
+			if err == nil && nested {
+				err = u.AddInRedeemScript(w.RedeemScript(), i)
+			}
+		default:
+			if !hasPrevTx {
+				return nil, fmt.Errorf("previous transaction %s required for non segwit input %d",
+					txIn.PreviousOutPoint.Hash, i)
+			}
+			err = u.AddInNonWitnessUtxo(prevTx, i)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if w != nil && w.KeyOrigin() != nil {
+			path, err := w.KeyOrigin().PathIndexes()
+			if err != nil {
+				return nil, err
+			}
+			err = u.AddInBip32Derivation(w.KeyOrigin().MasterFingerprint, path,
+				w.DeriveNativePublicKey().SerializeCompressed(), i)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for i, txOut := range unsignedTx.TxOut {
+		w := findWalletByScript(src.Wallets, txOut.PkScript)
+		if w == nil || w.KeyOrigin() == nil {
+			continue
+		}
+		if w.RedeemScript() != nil {
+			if err = u.AddOutRedeemScript(w.RedeemScript(), i); err != nil {
+				return nil, err
+			}
+		}
+		path, err := w.KeyOrigin().PathIndexes()
+		if err != nil {
+			return nil, err
+		}
+		err = u.AddOutBip32Derivation(w.KeyOrigin().MasterFingerprint, path,
+			w.DeriveNativePublicKey().SerializeCompressed(), i)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// checkPrevTx checks that output op of prevTx is txOut.
+func checkPrevTx(prevTx *wire.MsgTx, op wire.OutPoint, txOut *wire.TxOut) error {
+	if op.Index >= uint32(len(prevTx.TxOut)) {
+		return fmt.Errorf("previous transaction %s has no output %d", op.Hash, op.Index)
+	}
+	prevOut := prevTx.TxOut[op.Index]
+	if prevOut.Value != txOut.Value || !bytes.Equal(prevOut.PkScript, txOut.PkScript) {
+		return fmt.Errorf("output %s does not match the previous transaction", op)
+	}
+	return nil
+}
+
+func (t *BtcTransaction) ToPsbtBase64(src *PsbtSource) (string, error) {
+	p, err := t.ToPsbt(src)
+	if err != nil {
+		return "", err
+	}
+	return p.B64Encode()
+}
+
+// DecodePsbt parses a PSBT given as base64, hex or raw bytes. Version 2
+// (BIP370) packets are converted to version 0.
+func DecodePsbt(s string) (*psbt.Packet, error) {
+	s = strings.TrimSpace(s)
+	var raw []byte
+	var err error
+	if strings.HasPrefix(s, "70736274ff") {
+		raw, err = hex.DecodeString(s)
+	} else if strings.HasPrefix(s, "psbt\xff") {
+		raw = []byte(s)
+	} else {
+		raw, err = base64.StdEncoding.DecodeString(s)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := psbtVersion(raw)
+	if err != nil {
+		return nil, err
+	}
+	if version == 2 {
+		raw, err = convertPsbtV2ToV0(raw)
+		if err != nil {
+			return nil, err
+		}
+	} else if version != 0 {
+		return nil, fmt.Errorf("unsupported psbt version: %d", version)
+	}
+	return psbt.NewFromRawBytes(bytes.NewReader(raw), false)
+}
+
+// SignPsbt adds partial signatures for every input spendable by w and returns
+// how many inputs were signed.
+func SignPsbt(p *psbt.Packet, w *wallet.BtcWallet) (int, error) {
+	fetcher, err := psbtPrevOutFetcher(p)
+	if err != nil {
+		return 0, err
+	}
+	u, err := psbt.NewUpdater(p)
+	if err != nil {
+		return 0, err
+	}
+
+	addrScript, err := txscript.PayToAddrScript(w.DeriveNativeAddress())
+	if err != nil {
+		return 0, err
+	}
+	pubKey := w.DeriveNativePublicKey().SerializeCompressed()
+	privKey := w.DeriveNativePrivateKey()
+	sigHashes := txscript.NewTxSigHashes(p.UnsignedTx, fetcher)
+
+	signed := 0
+	for i, txIn := range p.UnsignedTx.TxIn {
+		if p.Inputs[i].FinalScriptSig != nil || p.Inputs[i].FinalScriptWitness != nil {
+			continue
+		}
+		prevOut := fetcher.FetchPrevOutput(txIn.PreviousOutPoint)
+		if prevOut == nil || !bytes.Equal(prevOut.PkScript, addrScript) {
+			continue
+		}
+		if hasPartialSig(p.Inputs[i], pubKey) {
+			continue
+		}
+
+		hashType := txscript.SigHashAll
+		if p.Inputs[i].SighashType != 0 {
+			hashType = p.Inputs[i].SighashType
+		}
+
+		var sig []byte
+		var redeemScript []byte
+		switch w.SegWitType() {
+		case wallet.SegWitNone:
+			sig, err = txscript.RawTxInSignature(p.UnsignedTx, i, prevOut.PkScript, hashType, privKey)
+		case wallet.SegWitScript, wallet.SegWitNative:
+			redeemScript = w.RedeemScript()
+			sig, err = txscript.RawTxInWitnessSignature(p.UnsignedTx, sigHashes, i,
+				prevOut.Value, p2pkhScriptCode(pubKey), hashType, privKey)
+		default:
+			err = fmt.Errorf("unsupported segwit type: %d", w.SegWitType())
+		}
+		if err != nil {
+			return signed, err
+		}
+
+		if _, err = u.Sign(i, sig, pubKey, redeemScript, nil); err != nil {
+			return signed, err
+		}
+		signed++
+	}
+	return signed, nil
+}
+
+// CombinePsbt merges the signatures and metadata of several packets for the
+// same unsigned transaction into the first one.
+func CombinePsbt(packets ...*psbt.Packet) (*psbt.Packet, error) {
+	if len(packets) == 0 {
+		return nil, errors.New("no psbt to combine")
+	}
+	// The packets are left as they are.
+	var buf bytes.Buffer
+	if err := packets[0].Serialize(&buf); err != nil {
+		return nil, err
+	}
+	result, err := psbt.NewFromRawBytes(&buf, false)
+	if err != nil {
+		return nil, err
+	}
+	txHash := result.UnsignedTx.TxHash()
+	for _, p := range packets[1:] {
+		if p.UnsignedTx.TxHash() != txHash {
+			return nil, ErrPsbtMismatch
+		}
+		result.Unknowns = mergeUnknowns(result.Unknowns, p.Unknowns)
+		for i := range p.Inputs {
+			combinePsbtInput(&result.Inputs[i], &p.Inputs[i])
+		}
+		for i := range p.Outputs {
+			combinePsbtOutput(&result.Outputs[i], &p.Outputs[i])
+		}
+	}
+	if err := result.SanityCheck(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// FinalizePsbt finalizes all inputs that have enough signatures.
+func FinalizePsbt(p *psbt.Packet) error {
+	return psbt.MaybeFinalizeAll(p)
+}
+
+// ExtractPsbtTx finalizes the packet and returns the validated network transaction.
+func ExtractPsbtTx(p *psbt.Packet) (*wire.MsgTx, error) {
+	if !p.IsComplete() {
+		if err := FinalizePsbt(p); err != nil {
+			return nil, err
+		}
+	}
+	fetcher, err := psbtPrevOutFetcher(p)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := psbt.Extract(p)
+	if err != nil {
+		return nil, err
+	}
+
+	prevScripts := make([][]byte, len(tx.TxIn))
+	inputValues := make([]btcutil.Amount, len(tx.TxIn))
+	for i, txIn := range tx.TxIn {
+		prevOut := fetcher.FetchPrevOutput(txIn.PreviousOutPoint)
+		prevScripts[i] = prevOut.PkScript
+		inputValues[i] = btcutil.Amount(prevOut.Value)
+	}
+	if err = validateMsgTx(tx, prevScripts, inputValues); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+func findWalletByScript(wallets []*wallet.BtcWallet, pkScript []byte) *wallet.BtcWallet {
+	for _, w := range wallets {
+		addr := w.DeriveNativeAddress()
+		if addr == nil {
+			continue
+		}
+		script, err := txscript.PayToAddrScript(addr)
+		if err == nil && bytes.Equal(script, pkScript) {
+			return w
+		}
+	}
+	return nil
+}
+
+func psbtPrevOutFetcher(p *psbt.Packet) (*txscript.MultiPrevOutFetcher, error) {
+	fetcher := txscript.NewMultiPrevOutFetcher(nil)
+	for i, txIn := range p.UnsignedTx.TxIn {
+		in := &p.Inputs[i]
+		switch {
+		case in.WitnessUtxo != nil:
+			fetcher.AddPrevOut(txIn.PreviousOutPoint, in.WitnessUtxo)
+		case in.NonWitnessUtxo != nil:
+			idx := txIn.PreviousOutPoint.Index
+			if int(idx) >= len(in.NonWitnessUtxo.TxOut) {
+				return nil, psbt.ErrInvalidPrevOutNonWitnessTransaction
+			}
+			fetcher.AddPrevOut(txIn.PreviousOutPoint, in.NonWitnessUtxo.TxOut[idx])
+		default:
+			return nil, fmt.Errorf("missing utxo of psbt input %d", i)
+		}
+	}
+	return fetcher, nil
+}
+
+func p2pkhScriptCode(pubKey []byte) []byte {
+	script, _ := txscript.NewScriptBuilder().AddOp(txscript.OP_DUP).
+		AddOp(txscript.OP_HASH160).AddData(btcutil.Hash160(pubKey)).
+		AddOp(txscript.OP_EQUALVERIFY).AddOp(txscript.OP_CHECKSIG).Script()
+	return script
+}
+
+func hasPartialSig(in psbt.PInput, pubKey []byte) bool {
+	for _, s := range in.PartialSigs {
+		if bytes.Equal(s.PubKey, pubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+func combinePsbtInput(dst, src *psbt.PInput) {
+	if dst.NonWitnessUtxo == nil {
+		dst.NonWitnessUtxo = src.NonWitnessUtxo
+	}
+	if dst.WitnessUtxo == nil {
+		dst.WitnessUtxo = src.WitnessUtxo
+	}
+	for _, sig := range src.PartialSigs {
+		if !hasPartialSig(*dst, sig.PubKey) {
+			dst.PartialSigs = append(dst.PartialSigs, sig)
+		}
+	}
+	if dst.SighashType == 0 {
+		dst.SighashType = src.SighashType
+	}
+	if dst.RedeemScript == nil {
+		dst.RedeemScript = src.RedeemScript
+	}
+	if dst.WitnessScript == nil {
+		dst.WitnessScript = src.WitnessScript
+	}
+	dst.Bip32Derivation = mergeBip32Derivation(dst.Bip32Derivation, src.Bip32Derivation)
+	if dst.FinalScriptSig == nil {
+		dst.FinalScriptSig = src.FinalScriptSig
+	}
+	if dst.FinalScriptWitness == nil {
+		dst.FinalScriptWitness = src.FinalScriptWitness
+	}
+	if dst.TaprootKeySpendSig == nil {
+		dst.TaprootKeySpendSig = src.TaprootKeySpendSig
+	}
+	for _, sig := range src.TaprootScriptSpendSig {
+		if !hasTaprootScriptSpendSig(*dst, sig) {
+			dst.TaprootScriptSpendSig = append(dst.TaprootScriptSpendSig, sig)
+		}
+	}
+	for _, leaf := range src.TaprootLeafScript {
+		if !hasTaprootLeafScript(*dst, leaf) {
+			dst.TaprootLeafScript = append(dst.TaprootLeafScript, leaf)
+		}
+	}
+	if dst.TaprootInternalKey == nil {
+		dst.TaprootInternalKey = src.TaprootInternalKey
+	}
+	if dst.TaprootMerkleRoot == nil {
+		dst.TaprootMerkleRoot = src.TaprootMerkleRoot
+	}
+	dst.TaprootBip32Derivation = mergeTaprootBip32Derivation(dst.TaprootBip32Derivation, src.TaprootBip32Derivation)
+	dst.Unknowns = mergeUnknowns(dst.Unknowns, src.Unknowns)
+}
+
+func hasTaprootScriptSpendSig(in psbt.PInput, sig *psbt.TaprootScriptSpendSig) bool {
+	for _, x := range in.TaprootScriptSpendSig {
+		if bytes.Equal(x.XOnlyPubKey, sig.XOnlyPubKey) && bytes.Equal(x.LeafHash, sig.LeafHash) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasTaprootLeafScript(in psbt.PInput, leaf *psbt.TaprootTapLeafScript) bool {
+	for _, x := range in.TaprootLeafScript {
+		if bytes.Equal(x.ControlBlock, leaf.ControlBlock) {
+			return true
+		}
+	}
+	return false
+}
+
+func combinePsbtOutput(dst, src *psbt.POutput) {
+	if dst.RedeemScript == nil {
+		dst.RedeemScript = src.RedeemScript
+	}
+	if dst.WitnessScript == nil {
+		dst.WitnessScript = src.WitnessScript
+	}
+	dst.Bip32Derivation = mergeBip32Derivation(dst.Bip32Derivation, src.Bip32Derivation)
+	if dst.TaprootInternalKey == nil {
+		dst.TaprootInternalKey = src.TaprootInternalKey
+	}
+	if dst.TaprootTapTree == nil {
+		dst.TaprootTapTree = src.TaprootTapTree
+	}
+	dst.TaprootBip32Derivation = mergeTaprootBip32Derivation(dst.TaprootBip32Derivation, src.TaprootBip32Derivation)
+	dst.Unknowns = mergeUnknowns(dst.Unknowns, src.Unknowns)
+}
+
+func mergeTaprootBip32Derivation(dst, src []*psbt.TaprootBip32Derivation) []*psbt.TaprootBip32Derivation {
+	for _, d := range src {
+		found := false
+		for _, x := range dst {
+			if bytes.Equal(x.XOnlyPubKey, d.XOnlyPubKey) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			dst = append(dst, d)
+		}
+	}
+	return dst
+}
+
+func mergeUnknowns(dst, src []*psbt.Unknown) []*psbt.Unknown {
+	for _, u := range src {
+		found := false
+		for _, x := range dst {
+			if bytes.Equal(x.Key, u.Key) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			dst = append(dst, u)
+		}
+	}
+	return dst
+}
+
+func mergeBip32Derivation(dst, src []*psbt.Bip32Derivation) []*psbt.Bip32Derivation {
+	for _, d := range src {
+		found := false
+		for _, x := range dst {
+			if bytes.Equal(x.PubKey, d.PubKey) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			dst = append(dst, d)
+		}
+	}
+	return dst
+}
+
+// psbt key types of BIP370 that do not exist in version 0
+const (
+	psbtGlobalUnsignedTx       = 0x00
+	psbtGlobalTxVersion        = 0x02
+	psbtGlobalFallbackLocktime = 0x03
+	psbtGlobalInputCount       = 0x04
+	psbtGlobalOutputCount      = 0x05
+	psbtGlobalTxModifiable     = 0x06
+	psbtGlobalVersion          = 0xfb
+
+	psbtInPreviousTxid    = 0x0e
+	psbtInOutputIndex     = 0x0f
+	psbtInSequence        = 0x10
+	psbtInRequiredTime    = 0x11
+	psbtInRequiredHeight  = 0x12
+	psbtOutAmount         = 0x03
+	psbtOutScript         = 0x04
+	psbtLocktimeThreshold = 500000000
+)
+
+type psbtKV struct {
+	key   []byte
+	value []byte
+}
+
+func psbtVersion(raw []byte) (uint32, error) {
+	r := bytes.NewReader(raw)
+	maps, err := readPsbtMaps(r, 1)
+	if err != nil {
+		return 0, err
+	}
+	for _, kv := range maps[0] {
+		if len(kv.key) == 1 && kv.key[0] == psbtGlobalVersion {
+			return psbtUint32(kv)
+		}
+	}
+	return 0, nil
+}
+
+// convertPsbtV2ToV0 builds the global unsigned transaction from the per input
+// and per output fields of a BIP370 packet.
+func convertPsbtV2ToV0(raw []byte) ([]byte, error) {
+	r := bytes.NewReader(raw)
+	globals, err := readPsbtMaps(r, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := wire.NewMsgTx(2)
+	var inputCount, outputCount uint64
+	var fallbackLocktime uint32
+	global := make([]psbtKV, 0, len(globals[0]))
+	for _, kv := range globals[0] {
+		if len(kv.key) != 1 {
+			global = append(global, kv)
+			continue
+		}
+		switch kv.key[0] {
+		case psbtGlobalTxVersion:
+			var version uint32
+			version, err = psbtUint32(kv)
+			tx.Version = int32(version)
+		case psbtGlobalFallbackLocktime:
+			fallbackLocktime, err = psbtUint32(kv)
+		case psbtGlobalInputCount:
+			inputCount, err = wire.ReadVarInt(bytes.NewReader(kv.value), 0)
+		case psbtGlobalOutputCount:
+			outputCount, err = wire.ReadVarInt(bytes.NewReader(kv.value), 0)
+		case psbtGlobalTxModifiable, psbtGlobalVersion:
+		default:
+			global = append(global, kv)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Each map takes at least its terminating byte.
+	if inputCount+outputCount > uint64(r.Len()) {
+		return nil, fmt.Errorf("psbt v2 has %d inputs and %d outputs in %d bytes", inputCount, outputCount, r.Len())
+	}
+	inputs, err := readPsbtMaps(r, int(inputCount))
+	if err != nil {
+		return nil, err
+	}
+	outputs, err := readPsbtMaps(r, int(outputCount))
+	if err != nil {
+		return nil, err
+	}
+
+	var heightLock, timeLock uint32
+	hasHeight, hasTime, allHeight, allTime := false, false, true, true
+	for i, m := range inputs {
+		txIn := wire.NewTxIn(&wire.OutPoint{}, nil, nil)
+		hasTxid, hasIndex := false, false
+		keep := m[:0]
+		inHeight, inTime := false, false
+		for _, kv := range m {
+			if len(kv.key) != 1 {
+				keep = append(keep, kv)
+				continue
+			}
+			var v uint32
+			switch kv.key[0] {
+			case psbtInPreviousTxid:
+				if len(kv.value) != chainhash.HashSize {
+					err = fmt.Errorf("psbt key 0x%02x: invalid value length %d", kv.key[0], len(kv.value))
+				}
+				copy(txIn.PreviousOutPoint.Hash[:], kv.value)
+				hasTxid = true
+			case psbtInOutputIndex:
+				txIn.PreviousOutPoint.Index, err = psbtUint32(kv)
+				hasIndex = true
+			case psbtInSequence:
+				txIn.Sequence, err = psbtUint32(kv)
+			case psbtInRequiredTime:
+				v, err = psbtUint32(kv)
+				if err == nil && v < psbtLocktimeThreshold {
+					err = fmt.Errorf("required time locktime %d is a height", v)
+				}
+				inTime = true
+				if v > timeLock {
+					timeLock = v
+				}
+			case psbtInRequiredHeight:
+				v, err = psbtUint32(kv)
+				if err == nil && (v == 0 || v >= psbtLocktimeThreshold) {
+					err = fmt.Errorf("required height locktime %d out of range", v)
+				}
+				inHeight = true
+				if v > heightLock {
+					heightLock = v
+				}
+			default:
+				keep = append(keep, kv)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("psbt v2 input %d: %w", i, err)
+			}
+		}
+		if !hasTxid || !hasIndex {
+			return nil, fmt.Errorf("psbt v2 input %d misses its outpoint", i)
+		}
+		if inHeight || inTime {
+			hasHeight = hasHeight || inHeight
+			hasTime = hasTime || inTime
+			allHeight = allHeight && inHeight
+			allTime = allTime && inTime
+		}
+		inputs[i] = keep
+		tx.AddTxIn(txIn)
+	}
+
+	tx.LockTime = fallbackLocktime
+	if hasHeight && allHeight {
+		tx.LockTime = heightLock
+	} else if hasTime && allTime {
+		tx.LockTime = timeLock
+	} else if hasHeight || hasTime {
+		return nil, errors.New("psbt v2 inputs have incompatible locktime requirements")
+	}
+
+	for i, m := range outputs {
+		txOut := &wire.TxOut{}
+		hasAmount, hasScript := false, false
+		keep := m[:0]
+		for _, kv := range m {
+			if len(kv.key) == 1 && kv.key[0] == psbtOutAmount {
+				if len(kv.value) != 8 {
+					return nil, fmt.Errorf("psbt v2 output %d: invalid amount length %d", i, len(kv.value))
+				}
+				txOut.Value = int64(binary.LittleEndian.Uint64(kv.value))
+				hasAmount = true
+			} else if len(kv.key) == 1 && kv.key[0] == psbtOutScript {
+				txOut.PkScript = kv.value
+				hasScript = true
+			} else {
+				keep = append(keep, kv)
+			}
+		}
+		if !hasAmount || !hasScript {
+			return nil, fmt.Errorf("psbt v2 output %d misses amount or script", i)
+		}
+		outputs[i] = keep
+		tx.AddTxOut(txOut)
+	}
+
+	var txBuf bytes.Buffer
+	if err = tx.SerializeNoWitness(&txBuf); err != nil {
+		return nil, err
+	}
+	global = append([]psbtKV{{key: []byte{psbtGlobalUnsignedTx}, value: txBuf.Bytes()}}, global...)
+
+	var buf bytes.Buffer
+	buf.Write([]byte("psbt\xff"))
+	for _, m := range append(append([][]psbtKV{global}, inputs...), outputs...) {
+		for _, kv := range m {
+			wire.WriteVarBytes(&buf, 0, kv.key)
+			wire.WriteVarBytes(&buf, 0, kv.value)
+		}
+		buf.WriteByte(0)
+	}
+	return buf.Bytes(), nil
+}
+
+// psbtUint32 decodes the 4 bytes value of kv.
+func psbtUint32(kv psbtKV) (uint32, error) {
+	if len(kv.value) != 4 {
+		return 0, fmt.Errorf("psbt key 0x%02x: invalid value length %d", kv.key[0], len(kv.value))
+	}
+	return binary.LittleEndian.Uint32(kv.value), nil
+}
+
+// readPsbtMaps reads the magic if needed and count key-value maps.
+func readPsbtMaps(r *bytes.Reader, count int) ([][]psbtKV, error) {
+	if r.Size()-int64(r.Len()) == 0 {
+		magic := make([]byte, 5)
+		if _, err := io.ReadFull(r, magic); err != nil || string(magic) != "psbt\xff" {
+			return nil, psbt.ErrInvalidMagicBytes
+		}
+	}
+
+	maps := make([][]psbtKV, 0, count)
+	for i := 0; i < count; i++ {
+		var m []psbtKV
+		for {
+			key, err := wire.ReadVarBytes(r, 0, psbt.MaxPsbtKeyLength, "psbt key")
+			if err != nil {
+				return nil, err
+			}
+			if len(key) == 0 {
+				break
+			}
+			value, err := wire.ReadVarBytes(r, 0, psbt.MaxPsbtValueLength, "psbt value")
+			if err != nil {
+				return nil, err
+			}
+			m = append(m, psbtKV{key: key, value: value})
+		}
+		maps = append(maps, m)
+	}
+	return maps, nil
+}
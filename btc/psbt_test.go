@@ -0,0 +1,264 @@
+package btc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lizc2003/hdwallet/wallet"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+const testMnemonic = "purse cheese cage reason cost flat jump usage hospital grit delay loan"
+
+func newTestWallets(t *testing.T) []*wallet.BtcWallet {
+	hdw, err := wallet.NewHDWallet(testMnemonic, "", wallet.BtcChainRegtest, wallet.ChainMainNet)
+	require.NoError(t, err)
+
+	w0, err := hdw.NewWallet(wallet.SymbolBtc, 0, 0, 0)
+	require.NoError(t, err)
+	w1, err := hdw.NewSegWitWallet(0, 0, 0)
+	require.NoError(t, err)
+	w2, err := hdw.NewNativeSegWitWallet(0, 0, 0)
+	require.NoError(t, err)
+	return []*wallet.BtcWallet{w0.(*wallet.BtcWallet), w1.(*wallet.BtcWallet), w2.(*wallet.BtcWallet)}
+}
+
+// newFundingTx returns a fake transaction paying amount satoshi to each wallet.
+func newFundingTx(t *testing.T, wallets []*wallet.BtcWallet, amount int64) (*wire.MsgTx, []BtcUnspent) {
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 7}, []byte{txscript.OP_TRUE}, nil))
+	for _, w := range wallets {
+		script, err := txscript.PayToAddrScript(w.DeriveNativeAddress())
+		require.NoError(t, err)
+		tx.AddTxOut(wire.NewTxOut(amount, script))
+	}
+
+	unspents := make([]BtcUnspent, len(wallets))
+	for i, out := range tx.TxOut {
+		unspents[i] = BtcUnspent{TxID: tx.TxHash().String(), Vout: uint32(i),
+			ScriptPubKey: hexString(out.PkScript), Amount: out.Value}
+	}
+	return tx, unspents
+}
+
+func TestPsbt_SignCombineFinalize(t *testing.T) {
+	wallets := newTestWallets(t)
+	chainParams := wallets[0].ChainParams()
+	prevTx, unspents := newFundingTx(t, wallets, 100000)
+
+	dest, err := btcutil.NewAddressWitnessPubKeyHash(bytes.Repeat([]byte{1}, 20), chainParams)
+	require.NoError(t, err)
+	tx, err := NewBtcTransaction(unspents, []BtcOutput{{Address: dest, Amount: 250000}},
+		wallets[2].DeriveNativeAddress(), 2000, chainParams)
+	require.NoError(t, err)
+	require.Len(t, tx.Tx.TxIn, 3)
+
+	b64, err := tx.ToPsbtBase64(&PsbtSource{PrevTxs: []*wire.MsgTx{prevTx}, Wallets: wallets})
+	require.NoError(t, err)
+
+	p1, err := DecodePsbt(b64)
+	require.NoError(t, err)
+	for _, in := range p1.Inputs {
+		require.Len(t, in.Bip32Derivation, 1)
+		// also for segwit inputs, as hardware wallets require
+		require.NotNil(t, in.NonWitnessUtxo)
+	}
+	if tx.HasChange() {
+		require.Len(t, p1.Outputs[tx.ChangeIndex].Bip32Derivation, 1)
+	}
+
+	p2, err := DecodePsbt(b64)
+	require.NoError(t, err)
+
+	n, err := SignPsbt(p1, wallets[0])
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	for _, w := range wallets[1:] {
+		n, err = SignPsbt(p2, w)
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+	}
+
+	_, err = ExtractPsbtTx(p2)
+	require.Error(t, err)
+
+	p, err := CombinePsbt(p1, p2)
+	require.NoError(t, err)
+	require.NoError(t, FinalizePsbt(p))
+	require.True(t, p.IsComplete())
+
+	signedTx, err := ExtractPsbtTx(p)
+	require.NoError(t, err)
+
+	// The same wallets signing in one go give the same transaction.
+	require.NoError(t, tx.SignWithSecretsSource(multiWallet(wallets)))
+	require.Equal(t, tx.GetTxid(), signedTx.TxHash().String())
+	var b1, b2 bytes.Buffer
+	require.NoError(t, tx.Tx.Serialize(&b1))
+	require.NoError(t, signedTx.Serialize(&b2))
+	require.Equal(t, b1.Bytes(), b2.Bytes())
+}
+
+func TestPsbt_NestedSegwitInput(t *testing.T) {
+	wallets := newTestWallets(t)
+	chainParams := wallets[0].ChainParams()
+	prevTx, unspents := newFundingTx(t, wallets, 100000)
+	tx, err := NewBtcTransaction(unspents[1:2], []BtcOutput{{Address: wallets[0].DeriveNativeAddress(), Amount: 50000}},
+		wallets[1].DeriveNativeAddress(), 1000, chainParams)
+	require.NoError(t, err)
+
+	// A P2SH output is only known to be segwit by the redeem script of its wallet.
+	_, err = tx.ToPsbt(nil)
+	require.Error(t, err)
+	p, err := tx.ToPsbt(&PsbtSource{Wallets: wallets})
+	require.NoError(t, err)
+	require.NotNil(t, p.Inputs[0].WitnessUtxo)
+	require.Equal(t, wallets[1].RedeemScript(), p.Inputs[0].RedeemScript)
+	p, err = tx.ToPsbt(&PsbtSource{PrevTxs: []*wire.MsgTx{prevTx}})
+	require.NoError(t, err)
+	require.NotNil(t, p.Inputs[0].NonWitnessUtxo)
+	require.Nil(t, p.Inputs[0].WitnessUtxo)
+
+	// The spent output must be the one of the previous transaction.
+	tx.PrevInputValues[0]++
+	_, err = tx.ToPsbt(&PsbtSource{PrevTxs: []*wire.MsgTx{prevTx}})
+	require.Error(t, err)
+}
+
+func TestPsbt_CombineTaproot(t *testing.T) {
+	wallets := newTestWallets(t)
+	tree := newTestTaprootTree(t, TaprootNUMSKey, wallets)
+	tx, index := newTaprootSpend(t, tree, wallets[2])
+	b64, err := tx.ToPsbtBase64(nil)
+	require.NoError(t, err)
+
+	controlBlock, err := tree.ControlBlock(0)
+	require.NoError(t, err)
+	leafHash := tree.Leaves[0].TapHash()
+	packets := make([]*psbt.Packet, 3)
+	for i := range packets {
+		packets[i], err = DecodePsbt(b64)
+		require.NoError(t, err)
+		packets[i].Inputs[index].TaprootScriptSpendSig = []*psbt.TaprootScriptSpendSig{{
+			XOnlyPubKey: schnorr.SerializePubKey(wallets[2].DeriveNativePublicKey()),
+			LeafHash:    leafHash[:], Signature: bytes.Repeat([]byte{byte(i)}, 64)}}
+		packets[i].Inputs[index].TaprootLeafScript = []*psbt.TaprootTapLeafScript{{
+			ControlBlock: controlBlock, Script: tree.Leaves[0].Script, LeafVersion: tree.Leaves[0].LeafVersion}}
+		packets[i].Inputs[index].TaprootBip32Derivation = []*psbt.TaprootBip32Derivation{{
+			XOnlyPubKey: schnorr.SerializePubKey(wallets[i].DeriveNativePublicKey()), Bip32Path: []uint32{uint32(i)}}}
+		packets[i].Unknowns = []*psbt.Unknown{{Key: []byte{0xfc, byte(i % 2)}, Value: []byte{byte(i)}}}
+	}
+
+	p, err := CombinePsbt(packets...)
+	require.NoError(t, err)
+	require.Len(t, p.Inputs[index].TaprootScriptSpendSig, 1)
+	require.Len(t, p.Inputs[index].TaprootLeafScript, 1)
+	require.Equal(t, byte(0), p.Inputs[index].TaprootScriptSpendSig[0].Signature[0])
+	require.Len(t, p.Inputs[index].TaprootBip32Derivation, 3)
+	require.Len(t, p.Unknowns, 2)
+	// The first packet is not changed.
+	require.Len(t, packets[0].Inputs[index].TaprootBip32Derivation, 1)
+	require.Len(t, packets[0].Unknowns, 1)
+}
+
+func TestPsbt_DecodeV2(t *testing.T) {
+	wallets := newTestWallets(t)
+	prevTx, _ := newFundingTx(t, wallets, 100000)
+	prevHash := prevTx.TxHash()
+
+	u32 := func(v uint32) []byte {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, v)
+		return b
+	}
+	u64 := func(v uint64) []byte {
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, v)
+		return b
+	}
+	var witnessUtxo bytes.Buffer
+	require.NoError(t, wire.WriteTxOut(&witnessUtxo, 0, 0, prevTx.TxOut[2]))
+
+	encode := func(txVersion, txid, index, amount, height []byte) string {
+		var buf bytes.Buffer
+		buf.WriteString("psbt\xff")
+		writeMap := func(kvs ...[]byte) {
+			for i := 0; i < len(kvs); i += 2 {
+				require.NoError(t, wire.WriteVarBytes(&buf, 0, kvs[i]))
+				require.NoError(t, wire.WriteVarBytes(&buf, 0, kvs[i+1]))
+			}
+			buf.WriteByte(0)
+		}
+		writeMap([]byte{0x02}, txVersion, []byte{0x03}, u32(0),
+			[]byte{0x04}, []byte{1}, []byte{0x05}, []byte{1}, []byte{0xfb}, u32(2))
+		writeMap([]byte{0x01}, witnessUtxo.Bytes(), []byte{0x0e}, txid, []byte{0x0f}, index,
+			[]byte{0x12}, height)
+		writeMap([]byte{0x03}, amount, []byte{0x04}, prevTx.TxOut[0].PkScript)
+		return base64.StdEncoding.EncodeToString(buf.Bytes())
+	}
+
+	// Fields of a wrong length are rejected, not read out of bounds.
+	for _, malformed := range []string{
+		encode([]byte{2}, prevHash[:], u32(2), u64(90000), u32(150)),
+		encode(u32(2), prevHash[:8], u32(2), u64(90000), u32(150)),
+		encode(u32(2), prevHash[:], []byte{2, 0}, u64(90000), u32(150)),
+		encode(u32(2), prevHash[:], u32(2), u32(90000), u32(150)),
+		// a required height must be below the time threshold
+		encode(u32(2), prevHash[:], u32(2), u64(90000), u32(LockTimeThreshold)),
+		encode(u32(2), prevHash[:], u32(2), u64(90000), u32(0)),
+	} {
+		_, err := DecodePsbt(malformed)
+		require.Error(t, err)
+	}
+
+	p, err := DecodePsbt(encode(u32(2), prevHash[:], u32(2), u64(90000), u32(150)))
+	require.NoError(t, err)
+	require.Equal(t, int32(2), p.UnsignedTx.Version)
+	require.Equal(t, uint32(150), p.UnsignedTx.LockTime)
+	require.Equal(t, prevHash, p.UnsignedTx.TxIn[0].PreviousOutPoint.Hash)
+	require.Equal(t, int64(90000), p.UnsignedTx.TxOut[0].Value)
+	require.NotNil(t, p.Inputs[0].WitnessUtxo)
+
+	n, err := SignPsbt(p, wallets[2])
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	_, err = ExtractPsbtTx(p)
+	require.NoError(t, err)
+}
+
+type multiWallet []*wallet.BtcWallet
+
+func (m multiWallet) GetKey(addr btcutil.Address) (*btcec.PrivateKey, bool, error) {
+	for _, w := range m {
+		if k, compressed, err := w.GetKey(addr); err == nil {
+			return k, compressed, nil
+		}
+	}
+	return nil, false, wallet.ErrAddressNotMatch
+}
+
+func (m multiWallet) GetScript(addr btcutil.Address) ([]byte, error) {
+	for _, w := range m {
+		if w.DeriveAddress() == addr.EncodeAddress() {
+			return w.RedeemScript(), nil
+		}
+	}
+	return nil, wallet.ErrAddressNotMatch
+}
+
+func (m multiWallet) ChainParams() *chaincfg.Params {
+	return m[0].ChainParams()
+}
+
+func hexString(b []byte) string {
+	return hex.EncodeToString(b)
+}
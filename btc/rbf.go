@@ -0,0 +1,203 @@
+package btc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcwallet/wallet/txauthor"
+)
+
+// https://github.com/bitcoin/bips/blob/master/bip-0125.mediawiki
+const (
+	MaxRbfSequence = mempool.MaxRBFSequence
+
+	DefaultIncrementalRelayFeePerKb = 1000
+)
+
+var (
+	ErrRbfNotSignaled = errors.New("transaction does not signal replace-by-fee")
+	ErrRbfFeeTooLow   = errors.New("replacement fee too low")
+)
+
+type BumpFeeParams struct {
+	// FeePerKb is the target fee rate of the replacement.
+	FeePerKb int64
+	// ChangeAddress receives the change of the replacement. For a cancel, it
+	// receives everything.
+	ChangeAddress btcutil.Address
+	// ChangeIndex is the index of the change output of the original
+	// transaction, -1 if none. It must pay to ChangeAddress.
+	ChangeIndex int
+	// Unspents are added as inputs if the change is not enough to pay the fee.
+	// Those with Confirmations 0 are skipped, a replacement may not spend
+	// unconfirmed ones (BIP125 rule 2), so Confirmations must be set.
+	Unspents []BtcUnspent
+	// Cancel replaces the transaction with one paying everything back to ChangeAddress.
+	Cancel bool
+	// IncrementalRelayFeePerKb defaults to DefaultIncrementalRelayFeePerKb.
+	IncrementalRelayFeePerKb int64
+	// Policy is checked on the replacement, DefaultPolicy if nil.
+	Policy *Policy
+}
+
+// SignalsRbf reports whether tx opts in to replacement. It does not check the
+// unconfirmed ancestors, which also make tx replaceable if they signal.
+func SignalsRbf(tx *wire.MsgTx) bool {
+	for _, txIn := range tx.TxIn {
+		if txIn.Sequence <= MaxRbfSequence {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *BtcTransaction) SignalsRbf() bool {
+	return SignalsRbf(t.Tx)
+}
+
+// EnableRbf sets the sequence of all inputs to signal replace-by-fee.
+// It must be called before signing.
+func (t *BtcTransaction) EnableRbf() {
+	for _, txIn := range t.Tx.TxIn {
+		if txIn.Sequence > MaxRbfSequence {
+			txIn.Sequence = MaxRbfSequence
+		}
+	}
+}
+
+// BumpFee builds an unsigned replacement of the broadcast transaction tx.
+// prevouts are the outputs spent by tx, in the order of its inputs.
+// All inputs of tx are spent again, the change is reduced or inputs from
+// params.Unspents are added to reach the new fee rate.
+func BumpFee(tx *wire.MsgTx, prevouts []BtcUnspent, params *BumpFeeParams, chainCfg *chaincfg.Params) (*BtcTransaction, error) {
+	if params == nil || params.FeePerKb <= 0 || params.ChangeAddress == nil {
+		return nil, errors.New("wrong params")
+	}
+	if !params.ChangeAddress.IsForNet(chainCfg) {
+		return nil, errors.New("change address is not the corresponding network address")
+	}
+	if !SignalsRbf(tx) {
+		return nil, ErrRbfNotSignaled
+	}
+	if len(prevouts) != len(tx.TxIn) {
+		return nil, errors.New("prevouts do not match the transaction inputs")
+	}
+	if params.ChangeIndex < -1 || params.ChangeIndex >= len(tx.TxOut) {
+		return nil, errors.New("wrong params")
+	}
+	var totalIn int64
+	for i, txIn := range tx.TxIn {
+		hash, err := chainhash.NewHashFromStr(prevouts[i].TxID)
+		if err != nil {
+			return nil, err
+		}
+		if txIn.PreviousOutPoint.Hash != *hash || txIn.PreviousOutPoint.Index != prevouts[i].Vout {
+			return nil, fmt.Errorf("prevout %d does not match the transaction input", i)
+		}
+		totalIn += prevouts[i].Amount
+	}
+
+	changeBytes, err := txscript.PayToAddrScript(params.ChangeAddress)
+	if err != nil {
+		return nil, err
+	}
+	if params.ChangeIndex >= 0 && !bytes.Equal(tx.TxOut[params.ChangeIndex].PkScript, changeBytes) {
+		return nil, fmt.Errorf("output %d does not pay to the change address", params.ChangeIndex)
+	}
+
+	oldFee := totalIn - int64(txauthor.SumOutputValues(tx.TxOut))
+	oldVSize := mempool.GetTxVirtualSize(btcutil.NewTx(tx))
+	incremental := params.IncrementalRelayFeePerKb
+	if incremental <= 0 {
+		incremental = DefaultIncrementalRelayFeePerKb
+	}
+
+	// Paying the old fee rate plus the increment on a replacement which is not
+	// smaller than the original also pays the old absolute fee plus the increment.
+	feePerKb := params.FeePerKb
+	if minFeePerKb := (oldFee*1000+oldVSize-1)/oldVSize + incremental; feePerKb < minFeePerKb {
+		feePerKb = minFeePerKb
+	}
+
+	var txOuts []*wire.TxOut
+	if !params.Cancel {
+		for i, txOut := range tx.TxOut {
+			if i != params.ChangeIndex {
+				txOuts = append(txOuts, wire.NewTxOut(txOut.Value, txOut.PkScript))
+			}
+		}
+	}
+
+	changeSource := txauthor.ChangeSource{
+		NewScript: func() ([]byte, error) {
+			return changeBytes, nil
+		},
+		ScriptSize: len(changeBytes),
+	}
+	unspents := prevouts
+	skipped := 0
+	if !params.Cancel {
+		candidates := excludeUnspents(matureUnspents(params.Unspents), prevouts)
+		confirmed := confirmedUnspents(candidates)
+		skipped = len(candidates) - len(confirmed)
+		unspents = append(append([]BtcUnspent{}, prevouts...), confirmed...)
+	}
+	inputSource := makeInputSource(unspents)
+	unsignedTx, inputs, err := newUnsignedTransaction(txOuts, btcutil.Amount(feePerKb),
+		func(target btcutil.Amount) (btcutil.Amount, []*wire.TxIn, []btcutil.Amount, [][]byte, error) {
+			// Always spend all the original inputs.
+			if target < btcutil.Amount(totalIn) {
+				target = btcutil.Amount(totalIn)
+			}
+			return inputSource(target)
+		}, &changeSource, describeUnspents(unspents))
+	if errors.Is(err, ErrInsufficientFunds) && skipped > 0 {
+		return nil, fmt.Errorf("%w, %d unspents without confirmations skipped", err, skipped)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if unsignedTx.ChangeIndex >= 0 {
+		unsignedTx.RandomizeChangePosition()
+	} else if params.Cancel {
+		return nil, errors.New("nothing left to cancel after fees")
+	}
+
+	unsignedTx.Tx.Version = tx.Version
+	unsignedTx.Tx.LockTime = tx.LockTime
+	for _, txIn := range unsignedTx.Tx.TxIn {
+		txIn.Sequence = MaxRbfSequence
+	}
+
+	policy := params.Policy
+	if policy == nil {
+		policy = &DefaultPolicy
+	}
+	t := &BtcTransaction{AuthoredTx: *unsignedTx, chainParams: chainCfg, feePerKb: feePerKb,
+		policy: policy, inputs: inputs}
+	newFee := t.GetFee()
+	if newFee < oldFee+feeForVSize(incremental, t.estimateVSize()) {
+		return nil, fmt.Errorf("%w: %d, original %d", ErrRbfFeeTooLow, newFee, oldFee)
+	}
+	if err = t.CheckPolicy(policy); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// confirmedUnspents returns the unspents which are not in the mempool.
+func confirmedUnspents(unspents []BtcUnspent) []BtcUnspent {
+	confirmed := make([]BtcUnspent, 0, len(unspents))
+	for _, u := range unspents {
+		if u.Confirmations > 0 {
+			confirmed = append(confirmed, u)
+		}
+	}
+	return confirmed
+}
@@ -0,0 +1,138 @@
+package btc
+
+import (
+	"bytes"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestRbf_BumpFee(t *testing.T) {
+	wallets := newTestWallets(t)
+	w := wallets[2]
+	chainParams := w.ChainParams()
+	changeAddress := w.DeriveNativeAddress()
+	unspents := newTestUnspents(t, changeAddress, 100000, 200000, 300000)
+	for i := range unspents {
+		unspents[i].Confirmations = 1
+	}
+	dest, err := btcutil.NewAddressWitnessPubKeyHash(bytes.Repeat([]byte{1}, 20), chainParams)
+	require.NoError(t, err)
+
+	tx, err := NewBtcTransaction(unspents[:1], []BtcOutput{{Address: dest, Amount: 50000}},
+		changeAddress, 1000, chainParams)
+	require.NoError(t, err)
+	require.False(t, tx.SignalsRbf())
+	require.NoError(t, tx.Sign(w))
+	_, err = BumpFee(tx.Tx, unspents[:1], &BumpFeeParams{FeePerKb: 5000, ChangeAddress: changeAddress, ChangeIndex: tx.ChangeIndex}, chainParams)
+	require.ErrorIs(t, err, ErrRbfNotSignaled)
+
+	tx, err = NewBtcTransactionWithOptions(unspents[:1], []BtcOutput{{Address: dest, Amount: 50000}},
+		changeAddress, 1000, chainParams, &BtcTxOptions{Rbf: true})
+	require.NoError(t, err)
+	require.True(t, tx.SignalsRbf())
+	require.NoError(t, tx.Sign(w))
+	oldFee := tx.GetFee()
+	oldVSize := mempool.GetTxVirtualSize(btcutil.NewTx(tx.Tx))
+
+	// The change pays the higher fee.
+	bumped, err := BumpFee(tx.Tx, unspents[:1], &BumpFeeParams{FeePerKb: 5000, ChangeAddress: changeAddress, ChangeIndex: tx.ChangeIndex}, chainParams)
+	require.NoError(t, err)
+	require.True(t, bumped.SignalsRbf())
+	require.Len(t, bumped.Tx.TxIn, 1)
+	require.True(t, bumped.HasChange())
+	require.NoError(t, bumped.Sign(w))
+	newVSize := mempool.GetTxVirtualSize(btcutil.NewTx(bumped.Tx))
+	require.GreaterOrEqual(t, bumped.GetFee(), newVSize*5)
+	require.GreaterOrEqual(t, bumped.GetFee(), oldFee+newVSize)
+	destScript, _ := txscript.PayToAddrScript(dest)
+	requireOutput(t, bumped, destScript, 50000)
+
+	// A rate below the original is raised to satisfy the relay increment.
+	bumped, err = BumpFee(tx.Tx, unspents[:1], &BumpFeeParams{FeePerKb: 500, ChangeAddress: changeAddress, ChangeIndex: tx.ChangeIndex}, chainParams)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, bumped.GetFee(), oldFee+oldVSize)
+
+	// Not enough change, another input is added.
+	bumped, err = BumpFee(tx.Tx, unspents[:1], &BumpFeeParams{FeePerKb: 400000,
+		ChangeAddress: changeAddress, ChangeIndex: tx.ChangeIndex, Unspents: unspents}, chainParams)
+	require.NoError(t, err)
+	require.Len(t, bumped.Tx.TxIn, 2)
+	require.Equal(t, tx.Tx.TxIn[0].PreviousOutPoint, bumped.Tx.TxIn[0].PreviousOutPoint)
+	require.NoError(t, bumped.Sign(w))
+	requireOutput(t, bumped, destScript, 50000)
+
+	_, err = BumpFee(tx.Tx, unspents[:1], &BumpFeeParams{FeePerKb: 400000, ChangeAddress: changeAddress, ChangeIndex: tx.ChangeIndex}, chainParams)
+	require.Error(t, err)
+
+	// Unconfirmed unspents may not be added (BIP125 rule 2).
+	unconfirmed := append([]BtcUnspent{}, unspents...)
+	unconfirmed[1].Confirmations = 0
+	bumped, err = BumpFee(tx.Tx, unspents[:1], &BumpFeeParams{FeePerKb: 400000,
+		ChangeAddress: changeAddress, ChangeIndex: tx.ChangeIndex, Unspents: unconfirmed}, chainParams)
+	require.NoError(t, err)
+	require.Len(t, bumped.Tx.TxIn, 2)
+	require.Equal(t, unspents[2].TxID, bumped.Tx.TxIn[1].PreviousOutPoint.Hash.String())
+	// Unspents without Confirmations set are reported if they could fund it.
+	for i := range unconfirmed {
+		unconfirmed[i].Confirmations = 0
+	}
+	_, err = BumpFee(tx.Tx, unspents[:1], &BumpFeeParams{FeePerKb: 400000,
+		ChangeAddress: changeAddress, ChangeIndex: tx.ChangeIndex, Unspents: unconfirmed}, chainParams)
+	require.ErrorIs(t, err, ErrInsufficientFunds)
+	require.Contains(t, err.Error(), "without confirmations")
+
+	// The replacement is checked against the policy.
+	_, err = BumpFee(tx.Tx, unspents[:1], &BumpFeeParams{FeePerKb: 5000, ChangeAddress: changeAddress,
+		ChangeIndex: tx.ChangeIndex, Policy: &Policy{MaxFee: oldFee}}, chainParams)
+	require.ErrorIs(t, err, ErrFeeTooHigh)
+	_, err = BumpFee(tx.Tx, unspents[:1], &BumpFeeParams{FeePerKb: 5000, ChangeAddress: changeAddress,
+		ChangeIndex: 1 - tx.ChangeIndex}, chainParams)
+	require.Error(t, err)
+
+	// Cancel pays everything back to the wallet.
+	cancel, err := BumpFee(tx.Tx, unspents[:1], &BumpFeeParams{FeePerKb: 3000,
+		ChangeAddress: changeAddress, ChangeIndex: tx.ChangeIndex, Cancel: true}, chainParams)
+	require.NoError(t, err)
+	require.Len(t, cancel.Tx.TxOut, 1)
+	require.Len(t, cancel.Tx.TxIn, 1)
+	require.Greater(t, cancel.GetFee(), oldFee)
+	require.NoError(t, cancel.Sign(w))
+}
+
+func TestRbf_BumpFeeSelfPayment(t *testing.T) {
+	wallets := newTestWallets(t)
+	w := wallets[2]
+	chainParams := w.ChainParams()
+	changeAddress := w.DeriveNativeAddress()
+	unspents := newTestUnspents(t, changeAddress, 100000)
+
+	// A payment to the change address is not the change.
+	tx, err := NewBtcTransactionWithOptions(unspents, []BtcOutput{{Address: changeAddress, Amount: 50000}},
+		changeAddress, 1000, chainParams, &BtcTxOptions{Rbf: true})
+	require.NoError(t, err)
+	require.True(t, tx.HasChange())
+	require.NoError(t, tx.Sign(w))
+
+	bumped, err := BumpFee(tx.Tx, unspents, &BumpFeeParams{FeePerKb: 5000,
+		ChangeAddress: changeAddress, ChangeIndex: tx.ChangeIndex}, chainParams)
+	require.NoError(t, err)
+	require.Len(t, bumped.Tx.TxOut, 2)
+	changeScript, _ := txscript.PayToAddrScript(changeAddress)
+	payment := bumped.Tx.TxOut[1-bumped.ChangeIndex]
+	require.Equal(t, changeScript, payment.PkScript)
+	require.Equal(t, int64(50000), payment.Value)
+	require.NoError(t, bumped.Sign(w))
+}
+
+func requireOutput(t *testing.T, tx *BtcTransaction, pkScript []byte, value int64) {
+	for _, txOut := range tx.Tx.TxOut {
+		if bytes.Equal(txOut.PkScript, pkScript) {
+			require.Equal(t, value, txOut.Value)
+			return
+		}
+	}
+	t.Fatalf("output not found")
+}
@@ -3,13 +3,15 @@ package btc
 import (
 	"encoding/json"
 	"errors"
+	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/rpcclient"
 	"github.com/lizc2003/hdwallet/wallet"
 	"net/url"
 )
 
 type BtcClient struct {
-	RpcClient *rpcclient.Client
+	RpcClient   *rpcclient.Client
+	chainParams *chaincfg.Params
 }
 
 func NewBtcClient(URL string, user string, pass string, chainId int) (*BtcClient, error) {
@@ -51,20 +53,7 @@ func NewBtcClient(URL string, user string, pass string, chainId int) (*BtcClient
 		return nil, err
 	}
 
-	return &BtcClient{RpcClient: client}, nil
-}
-
-func (this *BtcClient) EstimateFeePerKb() (int64, error) {
-	feeResult, err := this.RpcClient.EstimateSmartFee(6, nil)
-	if err != nil {
-		return 0, err
-	}
-
-	if feeResult.FeeRate != nil && *feeResult.FeeRate > 0 {
-		return BtcToSatoshi(*feeResult.FeeRate), nil
-	} else {
-		return 0, errors.New("Fee not available")
-	}
+	return &BtcClient{RpcClient: client, chainParams: chainParams}, nil
 }
 
 // https://bitcoincore.org/en/doc/0.21.0/rpc/rawtransactions/sendrawtransaction/
@@ -0,0 +1,452 @@
+package btc
+
+import (
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcwallet/wallet/txauthor"
+	"github.com/btcsuite/btcwallet/wallet/txrules"
+	"github.com/btcsuite/btcwallet/wallet/txsizes"
+)
+
+// InputType is the way an input is spent, which determines its size.
+type InputType int
+
+const (
+	InputP2PKH InputType = iota + 1
+	InputNestedP2WPKH
+	InputP2WPKH
+	// InputP2TR is the key path spend of a taproot output.
+	InputP2TR
+	// InputP2TRScriptPath is the script path spend of a taproot output.
+	InputP2TRScriptPath
+	InputP2SHMultisig
+	InputP2WSHMultisig
+	InputNestedP2WSHMultisig
+)
+
+const (
+	// The estimation assumes the largest DER signature with its sighash
+	// byte, and schnorr signatures with SigHashDefault.
+	ecdsaSigSize   = 73
+	schnorrSigSize = 64
+	// inputOutPointSize is the outpoint and the sequence of an input.
+	inputOutPointSize = 32 + 4 + 4
+	p2wshPkScriptSize = 1 + 1 + 32
+)
+
+var ErrUnknownInputSize = errors.New("cannot estimate the size of the input")
+
+var inputTypeNames = map[InputType]string{
+	InputP2PKH:               "p2pkh",
+	InputNestedP2WPKH:        "p2sh-p2wpkh",
+	InputP2WPKH:              "p2wpkh",
+	InputP2TR:                "p2tr",
+	InputP2TRScriptPath:      "p2tr-script",
+	InputP2SHMultisig:        "p2sh-multisig",
+	InputP2WSHMultisig:       "p2wsh-multisig",
+	InputNestedP2WSHMultisig: "p2sh-p2wsh-multisig",
+}
+
+func (t InputType) String() string {
+	if name, ok := inputTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("InputType(%d)", int(t))
+}
+
+// InputDescriptor describes how an input is spent, for size estimation.
+type InputDescriptor struct {
+	Type InputType `json:"type"`
+	// M and N are the number of signatures and keys of a multisig input, or
+	// of the TapscriptMultisig leaf of an InputP2TRScriptPath input. N is
+	// zero for a leaf satisfied by a single signature.
+	M int `json:"m,omitempty"`
+	N int `json:"n,omitempty"`
+	// LeafScriptSize and LeafDepth are the size of the tapscript leaf spent
+	// by an InputP2TRScriptPath input and its depth in the tree, zero for a
+	// lone leaf.
+	LeafScriptSize int `json:"leafScriptSize,omitempty"`
+	LeafDepth      int `json:"leafDepth,omitempty"`
+}
+
+// TxSize is the estimated size of a signed transaction.
+type TxSize struct {
+	Weight int64 `json:"weight"`
+	VSize  int64 `json:"vsize"`
+}
+
+// NewInputDescriptor returns the descriptor of the usual spend of pkScript:
+// P2PKH, P2WPKH, P2TR key path, and P2SH which is nested P2WPKH unless
+// redeemScript, if known, is a multisig script. The spends of other scripts,
+// e.g. P2WSH, must be described explicitly.
+func NewInputDescriptor(pkScript, redeemScript []byte) (InputDescriptor, error) {
+	switch {
+	case txscript.IsPayToPubKeyHash(pkScript):
+		return InputDescriptor{Type: InputP2PKH}, nil
+	case txscript.IsPayToWitnessPubKeyHash(pkScript):
+		return InputDescriptor{Type: InputP2WPKH}, nil
+	case txscript.IsPayToTaproot(pkScript):
+		return InputDescriptor{Type: InputP2TR}, nil
+	case txscript.IsPayToScriptHash(pkScript):
+		if len(redeemScript) == 0 || txscript.IsPayToWitnessPubKeyHash(redeemScript) {
+			return InputDescriptor{Type: InputNestedP2WPKH}, nil
+		}
+		if isMultisig, _ := txscript.IsMultisigScript(redeemScript); isMultisig {
+			n, m, err := txscript.CalcMultiSigStats(redeemScript)
+			if err != nil {
+				return InputDescriptor{}, err
+			}
+			return InputDescriptor{Type: InputP2SHMultisig, M: m, N: n}, nil
+		}
+	}
+	return InputDescriptor{}, fmt.Errorf("%w: %v output", ErrUnknownInputSize, txscript.GetScriptClass(pkScript))
+}
+
+// Weight returns the weight of the input once signed, including its witness.
+func (d InputDescriptor) Weight() (int, error) {
+	base, witness, err := d.sizes()
+	if err != nil {
+		return 0, err
+	}
+	return base*blockchain.WitnessScaleFactor + witness, nil
+}
+
+// VirtualSize returns the virtual size of the input once signed.
+func (d InputDescriptor) VirtualSize() (int, error) {
+	weight, err := d.Weight()
+	if err != nil {
+		return 0, err
+	}
+	return (weight + blockchain.WitnessScaleFactor - 1) / blockchain.WitnessScaleFactor, nil
+}
+
+// sizes returns the size of the input without witness and of its witness.
+func (d InputDescriptor) sizes() (int, int, error) {
+	var sigScript, witness int
+	switch d.Type {
+	case InputP2PKH:
+		sigScript = 1 + ecdsaSigSize + 1 + 33
+	case InputNestedP2WPKH:
+		sigScript = 1 + txsizes.P2WPKHPkScriptSize
+		witness = 1 + 1 + ecdsaSigSize + 1 + 33
+	case InputP2WPKH:
+		witness = 1 + 1 + ecdsaSigSize + 1 + 33
+	case InputP2TR:
+		witness = 1 + 1 + schnorrSigSize
+	case InputP2TRScriptPath:
+		if d.LeafScriptSize <= 0 || d.LeafDepth < 0 || d.LeafDepth > txscript.ControlBlockMaxNodeCount {
+			return 0, 0, fmt.Errorf("%w: tapscript leaf size %d depth %d", ErrUnknownInputSize, d.LeafScriptSize, d.LeafDepth)
+		}
+		items, stack := 1, 1+schnorrSigSize
+		if d.N > 0 {
+			if d.M < 1 || d.M > d.N {
+				return 0, 0, fmt.Errorf("%w: %d-of-%d tapscript", ErrUnknownInputSize, d.M, d.N)
+			}
+			// The keys which do not sign have an empty signature.
+			items, stack = d.N, d.M*(1+schnorrSigSize)+(d.N-d.M)
+		}
+		controlBlock := txscript.ControlBlockBaseSize + d.LeafDepth*txscript.ControlBlockNodeSize
+		witness = wire.VarIntSerializeSize(uint64(items+2)) + stack +
+			wire.VarIntSerializeSize(uint64(d.LeafScriptSize)) + d.LeafScriptSize +
+			wire.VarIntSerializeSize(uint64(controlBlock)) + controlBlock
+	case InputP2SHMultisig, InputP2WSHMultisig, InputNestedP2WSHMultisig:
+		if d.M < 1 || d.M > d.N || d.N > txscript.MaxPubKeysPerMultiSig {
+			return 0, 0, fmt.Errorf("%w: %d-of-%d multisig", ErrUnknownInputSize, d.M, d.N)
+		}
+		script := multisigScriptSize(d.M, d.N)
+		// OP_0 for the extra item popped by OP_CHECKMULTISIG, then the signatures.
+		sigs := 1 + d.M*(1+ecdsaSigSize)
+		switch d.Type {
+		case InputP2SHMultisig:
+			sigScript = sigs + pushDataSize(script) + script
+		case InputNestedP2WSHMultisig:
+			sigScript = 1 + p2wshPkScriptSize
+			fallthrough
+		default:
+			witness = wire.VarIntSerializeSize(uint64(d.M+2)) + sigs +
+				wire.VarIntSerializeSize(uint64(script)) + script
+		}
+	default:
+		return 0, 0, fmt.Errorf("%w: type %v", ErrUnknownInputSize, d.Type)
+	}
+	return inputOutPointSize + wire.VarIntSerializeSize(uint64(sigScript)) + sigScript, witness, nil
+}
+
+// EstimateTxSize estimates the size of a transaction spending inputs to
+// outputs once signed. The signatures are assumed to be of the largest size.
+func EstimateTxSize(inputs []InputDescriptor, outputScripts [][]byte) (TxSize, error) {
+	sizes := make([]int, len(outputScripts))
+	for i, script := range outputScripts {
+		sizes[i] = len(script)
+	}
+	weight, err := estimateWeight(inputs, sizes)
+	if err != nil {
+		return TxSize{}, err
+	}
+	return newTxSize(weight), nil
+}
+
+func newTxSize(weight int) TxSize {
+	return TxSize{Weight: int64(weight),
+		VSize: int64((weight + blockchain.WitnessScaleFactor - 1) / blockchain.WitnessScaleFactor)}
+}
+
+// estimateWeight estimates the weight of a transaction spending inputs to
+// outputs of the given script sizes.
+func estimateWeight(inputs []InputDescriptor, outputScriptSizes []int) (int, error) {
+	base := 4 + 4 + wire.VarIntSerializeSize(uint64(len(inputs))) +
+		wire.VarIntSerializeSize(uint64(len(outputScriptSizes)))
+	for _, size := range outputScriptSizes {
+		base += 8 + wire.VarIntSerializeSize(uint64(size)) + size
+	}
+
+	var witness, noWitness int
+	for i, input := range inputs {
+		b, w, err := input.sizes()
+		if err != nil {
+			return 0, fmt.Errorf("input %d: %w", i, err)
+		}
+		base += b
+		witness += w
+		if w == 0 {
+			noWitness++
+		}
+	}
+	if witness > 0 {
+		// The segwit marker and flag, and an empty witness for the other inputs.
+		witness += 2 + noWitness
+	}
+	return base*blockchain.WitnessScaleFactor + witness, nil
+}
+
+// selectionBaseWeight is the weight coin selection reserves for the
+// transaction without its inputs, at most numInputs. Adding the
+// selectionInputWeight of the selected inputs bounds the estimateWeight of
+// the transaction, rounded up to its virtual size.
+func selectionBaseWeight(outputScriptSizes []int, numInputs int) (int, error) {
+	weight, err := estimateWeight(nil, outputScriptSizes)
+	if err != nil {
+		return 0, err
+	}
+	inputCount := wire.VarIntSerializeSize(uint64(numInputs)) - wire.VarIntSerializeSize(0)
+	// The segwit marker and flag, and the rounding of the virtual size.
+	return weight + inputCount*blockchain.WitnessScaleFactor + 2 + blockchain.WitnessScaleFactor - 1, nil
+}
+
+// selectionInputWeight is the weight coin selection reserves for spending d,
+// including an empty witness if the transaction has other witnesses.
+func selectionInputWeight(d InputDescriptor) (int, error) {
+	base, witness, err := d.sizes()
+	if err != nil {
+		return 0, err
+	}
+	if witness == 0 {
+		witness = 1
+	}
+	return base*blockchain.WitnessScaleFactor + witness, nil
+}
+
+// feeForWeight is the fee of weight at feePerKb rounded up, so that the fees
+// of the parts of a transaction add up to at least the fee of the whole.
+func feeForWeight(feePerKb int64, weight int) int64 {
+	scale := int64(1000 * blockchain.WitnessScaleFactor)
+	return (feePerKb*int64(weight) + scale - 1) / scale
+}
+
+// multisigScriptSize is the size of the script of an m-of-n multisig:
+// <m> <key 1> ... <key n> <n> OP_CHECKMULTISIG.
+func multisigScriptSize(m, n int) int {
+	return smallIntSize(m) + n*(1+33) + smallIntSize(n) + 1
+}
+
+func smallIntSize(n int) int {
+	if n <= 16 {
+		return 1
+	}
+	return 2
+}
+
+func pushDataSize(size int) int {
+	switch {
+	case size < txscript.OP_PUSHDATA1:
+		return 1
+	case size <= 0xff:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// FeePreview is the estimated fee of a transaction, see PreviewFee.
+type FeePreview struct {
+	TxSize
+	FeePerKb int64 `json:"feePerKb"`
+	Fee      int64 `json:"fee"`
+	// Amount is the sum of the outputs, without change.
+	Amount int64 `json:"amount"`
+}
+
+// PreviewFee estimates the size and the fee at feePerKb of a transaction
+// spending inputs to outputs, with a change output of changeScriptSize if
+// not zero, P2WPKH if negative. NewBtcTransaction uses the same estimation.
+func PreviewFee(inputs []InputDescriptor, outputs []BtcOutput, changeScriptSize int,
+	feePerKb int64, chainCfg *chaincfg.Params) (*FeePreview, error) {
+
+	if len(inputs) == 0 || feePerKb <= 0 {
+		return nil, errors.New("wrong params")
+	}
+	return previewFee(inputs, outputs, changeScriptSize, feePerKb, chainCfg)
+}
+
+func previewFee(inputs []InputDescriptor, outputs []BtcOutput, changeScriptSize int,
+	feePerKb int64, chainCfg *chaincfg.Params) (*FeePreview, error) {
+
+	if changeScriptSize < 0 {
+		changeScriptSize = txsizes.P2WPKHPkScriptSize
+	}
+	txOuts, err := makeTxOutputs(outputs, &DefaultPolicy, chainCfg)
+	if err != nil {
+		return nil, err
+	}
+	weight, err := estimateWeight(inputs, outputScriptSizes(txOuts, changeScriptSize))
+	if err != nil {
+		return nil, err
+	}
+	size := newTxSize(weight)
+	return &FeePreview{TxSize: size, FeePerKb: feePerKb, Fee: feeForVSize(feePerKb, int(size.VSize)),
+		Amount: int64(txauthor.SumOutputValues(txOuts))}, nil
+}
+
+func outputScriptSizes(txOuts []*wire.TxOut, changeScriptSize int) []int {
+	sizes := make([]int, 0, len(txOuts)+1)
+	for _, txOut := range txOuts {
+		sizes = append(sizes, len(txOut.PkScript))
+	}
+	if changeScriptSize > 0 {
+		sizes = append(sizes, changeScriptSize)
+	}
+	return sizes
+}
+
+// scriptDescriptor returns the descriptor of the usual spend of pkScript.
+// As txauthor does, the spends of unknown scripts are estimated as P2PKH.
+func scriptDescriptor(pkScript []byte) InputDescriptor {
+	d, err := NewInputDescriptor(pkScript, nil)
+	if err != nil {
+		return InputDescriptor{Type: InputP2PKH}
+	}
+	return d
+}
+
+// inputDescriptors returns the descriptors of the inputs, derived from the
+// spent scripts if the transaction was not built from unspents.
+func (t *BtcTransaction) inputDescriptors() []InputDescriptor {
+	if len(t.inputs) == len(t.Tx.TxIn) {
+		return t.inputs
+	}
+	return t.scriptDescriptors()
+}
+
+func (t *BtcTransaction) scriptDescriptors() []InputDescriptor {
+	descriptors := make([]InputDescriptor, len(t.PrevScripts))
+	for i, pkScript := range t.PrevScripts {
+		descriptors[i] = scriptDescriptor(pkScript)
+	}
+	return descriptors
+}
+
+// estimateVSize estimates the virtual size of the transaction once signed.
+func (t *BtcTransaction) estimateVSize() int {
+	sizes := outputScriptSizes(t.Tx.TxOut, 0)
+	weight, err := estimateWeight(t.inputDescriptors(), sizes)
+	if err != nil {
+		// Invalid descriptors are rejected when the transaction is built.
+		weight, _ = estimateWeight(t.scriptDescriptors(), sizes)
+	}
+	return int(newTxSize(weight).VSize)
+}
+
+// describeUnspents returns the descriptors of the inputs spending unspents,
+// for newUnsignedTransaction.
+func describeUnspents(unspents []BtcUnspent) func(txIn *wire.TxIn, pkScript []byte) InputDescriptor {
+	descriptors := make(map[wire.OutPoint]InputDescriptor, len(unspents))
+	for _, u := range unspents {
+		if outPoint, err := u.OutPoint(); err == nil {
+			descriptors[outPoint] = u.spendDescriptor()
+		}
+	}
+	return func(txIn *wire.TxIn, pkScript []byte) InputDescriptor {
+		if d, ok := descriptors[txIn.PreviousOutPoint]; ok {
+			return d
+		}
+		return scriptDescriptor(pkScript)
+	}
+}
+
+// newUnsignedTransaction is txauthor.NewUnsignedTransaction with the size
+// estimation of the descriptors of the inputs, describe returns the
+// descriptor of the input spending pkScript.
+func newUnsignedTransaction(outputs []*wire.TxOut, feeRatePerKb btcutil.Amount, fetchInputs txauthor.InputSource,
+	changeSource *txauthor.ChangeSource, describe func(txIn *wire.TxIn, pkScript []byte) InputDescriptor) (
+	*txauthor.AuthoredTx, []InputDescriptor, error) {
+
+	targetAmount := txauthor.SumOutputValues(outputs)
+	scriptSizes := outputScriptSizes(outputs, changeSource.ScriptSize)
+	weight, err := estimateWeight([]InputDescriptor{{Type: InputP2WPKH}}, scriptSizes)
+	if err != nil {
+		return nil, nil, err
+	}
+	targetFee := btcutil.Amount(feeForVSize(int64(feeRatePerKb), int(newTxSize(weight).VSize)))
+
+	for {
+		inputAmount, inputs, inputValues, scripts, err := fetchInputs(targetAmount + targetFee)
+		if err != nil {
+			return nil, nil, err
+		}
+		if inputAmount < targetAmount+targetFee {
+			return nil, nil, fmt.Errorf("%w: total %d, required %d", ErrInsufficientFunds,
+				inputAmount, targetAmount+targetFee)
+		}
+
+		descriptors := make([]InputDescriptor, len(inputs))
+		for i, txIn := range inputs {
+			descriptors[i] = describe(txIn, scripts[i])
+		}
+		weight, err := estimateWeight(descriptors, scriptSizes)
+		if err != nil {
+			return nil, nil, err
+		}
+		maxRequiredFee := btcutil.Amount(feeForVSize(int64(feeRatePerKb), int(newTxSize(weight).VSize)))
+		if inputAmount-targetAmount < maxRequiredFee {
+			targetFee = maxRequiredFee
+			continue
+		}
+
+		unsignedTx := &wire.MsgTx{Version: wire.TxVersion, TxIn: inputs, TxOut: outputs}
+		changeIndex := -1
+		changeAmount := inputAmount - targetAmount - maxRequiredFee
+		changeScript, err := changeSource.NewScript()
+		if err != nil {
+			return nil, nil, err
+		}
+		change := wire.NewTxOut(int64(changeAmount), changeScript)
+		if changeAmount != 0 && !txrules.IsDustOutput(change, txrules.DefaultRelayFeePerKb) {
+			l := len(outputs)
+			unsignedTx.TxOut = append(outputs[:l:l], change)
+			changeIndex = l
+		}
+
+		return &txauthor.AuthoredTx{
+			Tx:              unsignedTx,
+			PrevScripts:     scripts,
+			PrevInputValues: inputValues,
+			TotalInput:      inputAmount,
+			ChangeIndex:     changeIndex,
+		}, descriptors, nil
+	}
+}
@@ -0,0 +1,244 @@
+package btc
+
+import (
+	"bytes"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lizc2003/hdwallet/wallet"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNewInputDescriptor(t *testing.T) {
+	wallets := newTestWallets(t)
+	chainParams := wallets[0].ChainParams()
+
+	for i, typ := range []InputType{InputP2PKH, InputNestedP2WPKH, InputP2WPKH} {
+		script, err := txscript.PayToAddrScript(wallets[i].DeriveNativeAddress())
+		require.NoError(t, err)
+		d, err := NewInputDescriptor(script, nil)
+		require.NoError(t, err)
+		require.Equal(t, typ, d.Type)
+	}
+	key, err := NewMuSig2Key([]*btcec.PublicKey{wallets[1].DeriveNativePublicKey(), wallets[2].DeriveNativePublicKey()}, nil)
+	require.NoError(t, err)
+	d, err := NewInputDescriptor(key.PkScript(), nil)
+	require.NoError(t, err)
+	require.Equal(t, InputDescriptor{Type: InputP2TR}, d)
+
+	// A P2SH output is nested P2WPKH unless its redeem script is known.
+	redeemScript := newTestMultisigScript(t, 2, wallets)
+	p2sh, err := btcutil.NewAddressScriptHash(redeemScript, chainParams)
+	require.NoError(t, err)
+	script, err := txscript.PayToAddrScript(p2sh)
+	require.NoError(t, err)
+	d, err = NewInputDescriptor(script, redeemScript)
+	require.NoError(t, err)
+	require.Equal(t, InputDescriptor{Type: InputP2SHMultisig, M: 2, N: 3}, d)
+
+	witnessHash := chainhash.HashB(redeemScript)
+	p2wsh, err := btcutil.NewAddressWitnessScriptHash(witnessHash, chainParams)
+	require.NoError(t, err)
+	script, err = txscript.PayToAddrScript(p2wsh)
+	require.NoError(t, err)
+	_, err = NewInputDescriptor(script, redeemScript)
+	require.ErrorIs(t, err, ErrUnknownInputSize)
+
+	for _, d := range []InputDescriptor{{}, {Type: InputP2WSHMultisig, M: 3, N: 2},
+		{Type: InputP2TRScriptPath}, {Type: InputP2TRScriptPath, LeafScriptSize: 34, M: 2, N: 1}} {
+		_, err = d.Weight()
+		require.ErrorIs(t, err, ErrUnknownInputSize, d)
+	}
+}
+
+func newTestMultisigScript(t *testing.T, m int, wallets []*wallet.BtcWallet) []byte {
+	keys := make([]*btcutil.AddressPubKey, len(wallets))
+	for i, w := range wallets {
+		var err error
+		keys[i], err = btcutil.NewAddressPubKey(w.DeriveNativePublicKey().SerializeCompressed(), w.ChainParams())
+		require.NoError(t, err)
+	}
+	script, err := txscript.MultiSigScript(keys, m)
+	require.NoError(t, err)
+	return script
+}
+
+func TestEstimateTxSize(t *testing.T) {
+	wallets := newTestWallets(t)
+	chainParams := wallets[0].ChainParams()
+	dest, err := btcutil.NewAddressWitnessPubKeyHash(bytes.Repeat([]byte{1}, 20), chainParams)
+	require.NoError(t, err)
+
+	// The estimate is the weight of ECDSA signatures of the largest size, a
+	// signature usually is 1 or 2 bytes shorter.
+	for _, w := range wallets {
+		tx, err := NewBtcTransaction(newTestUnspents(t, w.DeriveNativeAddress(), 50000, 60000),
+			[]BtcOutput{{Address: dest, Amount: 100000}}, w.DeriveNativeAddress(), 1000, chainParams)
+		require.NoError(t, err)
+		require.NoError(t, tx.Sign(w))
+		size, err := EstimateTxSize(tx.inputDescriptors(), txOutScripts(tx))
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, size.Weight, tx.Weight())
+		require.LessOrEqual(t, size.Weight-tx.Weight(), int64(2*4*len(tx.Tx.TxIn)))
+		require.Equal(t, (size.Weight+3)/4, size.VSize)
+	}
+
+	// Schnorr signatures have a fixed size.
+	tree := newTestTaprootTree(t, wallets[1].DeriveNativePublicKey(), wallets)
+	tx, index := newTaprootSpend(t, tree, wallets[2])
+	require.NoError(t, tx.SignTaprootKeyPath(index, tree, wallets[1]))
+	require.NoError(t, tx.Sign(wallets[2]))
+	requireTaprootSize(t, tx, index, InputDescriptor{Type: InputP2TR})
+
+	tx, index = newTaprootSpend(t, tree, wallets[2])
+	require.NoError(t, tx.SignTapscriptInput(index, tree, wallets[2]))
+	require.NoError(t, tx.Sign(wallets[2]))
+	d, err := tree.InputDescriptor(0)
+	require.NoError(t, err)
+	require.Equal(t, InputDescriptor{Type: InputP2TRScriptPath, LeafScriptSize: 34, LeafDepth: 2}, d)
+	requireTaprootSize(t, tx, index, d)
+
+	tx, index = newTaprootSpend(t, tree, wallets[2])
+	sig1, err := tx.SignTapscript(index, tree, 2, wallets[0])
+	require.NoError(t, err)
+	sig2, err := tx.SignTapscript(index, tree, 2, wallets[1])
+	require.NoError(t, err)
+	require.NoError(t, tx.SetTapscriptWitness(index, tree, 2, [][]byte{{}, sig2, sig1}))
+	require.NoError(t, tx.Sign(wallets[2]))
+	d, err = tree.InputDescriptor(2)
+	require.NoError(t, err)
+	require.Equal(t, 2, d.M)
+	require.Equal(t, 3, d.N)
+	require.Equal(t, 1, d.LeafDepth)
+	requireTaprootSize(t, tx, index, d)
+
+	_, err = tree.InputDescriptor(3)
+	require.ErrorIs(t, err, ErrNotTapscriptLeaf)
+}
+
+// requireTaprootSize checks the estimate of tx, whose input index spends a
+// taproot output as described by d and whose other input is P2WPKH.
+func requireTaprootSize(t *testing.T, tx *BtcTransaction, index int, d InputDescriptor) {
+	inputs := []InputDescriptor{{Type: InputP2WPKH}, {Type: InputP2WPKH}}
+	inputs[index] = d
+	size, err := EstimateTxSize(inputs, txOutScripts(tx))
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, size.Weight, tx.Weight())
+	require.Less(t, size.Weight-tx.Weight(), int64(4))
+}
+
+func txOutScripts(tx *BtcTransaction) [][]byte {
+	scripts := make([][]byte, len(tx.Tx.TxOut))
+	for i, txOut := range tx.Tx.TxOut {
+		scripts[i] = txOut.PkScript
+	}
+	return scripts
+}
+
+func TestPreviewFee(t *testing.T) {
+	wallets := newTestWallets(t)
+	chainParams := wallets[0].ChainParams()
+	outputs := []BtcOutput{{Address: wallets[0].DeriveNativeAddress(), Amount: 10000},
+		{Address: wallets[2].DeriveNativeAddress(), Amount: 20000}}
+
+	preview, err := PreviewFee([]InputDescriptor{{Type: InputP2PKH}, {Type: InputP2WPKH}, {Type: InputNestedP2WPKH}},
+		outputs, -1, 2000, chainParams)
+	require.NoError(t, err)
+	fee, amount, err := EstimateFee(1, 1, 1, outputs, 2000, -1, chainParams)
+	require.NoError(t, err)
+	require.Equal(t, fee, preview.Fee)
+	require.Equal(t, amount, preview.Amount)
+	require.Equal(t, int64(30000), amount)
+	require.Equal(t, preview.VSize*2, preview.Fee)
+
+	// A 2-of-3 P2WSH input is larger than a P2WPKH one, smaller than a P2PKH one.
+	multisig, err := PreviewFee([]InputDescriptor{{Type: InputP2WSHMultisig, M: 2, N: 3}}, outputs, 0, 1000, chainParams)
+	require.NoError(t, err)
+	p2wpkh, err := PreviewFee([]InputDescriptor{{Type: InputP2WPKH}}, outputs, 0, 1000, chainParams)
+	require.NoError(t, err)
+	p2pkh, err := PreviewFee([]InputDescriptor{{Type: InputP2PKH}}, outputs, 0, 1000, chainParams)
+	require.NoError(t, err)
+	require.Greater(t, multisig.Fee, p2wpkh.Fee)
+	require.Less(t, multisig.Fee, p2pkh.Fee)
+
+	_, err = PreviewFee(nil, outputs, 0, 1000, chainParams)
+	require.Error(t, err)
+	_, err = PreviewFee([]InputDescriptor{{Type: InputP2WSHMultisig}}, outputs, 0, 1000, chainParams)
+	require.ErrorIs(t, err, ErrUnknownInputSize)
+}
+
+func TestSelectionWeight(t *testing.T) {
+	// The fees coin selection reserves for the parts of a transaction bound
+	// the fee of its estimate.
+	outputScriptSizes := []int{22, 34, 25}
+	inputs := []InputDescriptor{{Type: InputP2PKH}, {Type: InputP2WPKH}, {Type: InputNestedP2WPKH},
+		{Type: InputP2TR}, {Type: InputP2WSHMultisig, M: 2, N: 3}, {Type: InputP2SHMultisig, M: 1, N: 2}}
+	for n := 1; n <= len(inputs); n++ {
+		for _, feePerKb := range []int64{1000, 1999, 20011} {
+			base, err := selectionBaseWeight(outputScriptSizes, len(inputs))
+			require.NoError(t, err)
+			fee := feeForWeight(feePerKb, base)
+			for _, d := range inputs[:n] {
+				weight, err := selectionInputWeight(d)
+				require.NoError(t, err)
+				fee += feeForWeight(feePerKb, weight)
+			}
+			weight, err := estimateWeight(inputs[:n], outputScriptSizes)
+			require.NoError(t, err)
+			require.GreaterOrEqual(t, fee, feeForVSize(feePerKb, int(newTxSize(weight).VSize)))
+		}
+	}
+	// Only P2PKH inputs have no witness.
+	base, err := selectionBaseWeight(outputScriptSizes, 1)
+	require.NoError(t, err)
+	weight, err := estimateWeight(inputs[:1], outputScriptSizes)
+	require.NoError(t, err)
+	input, err := selectionInputWeight(inputs[0])
+	require.NoError(t, err)
+	require.Equal(t, weight+2+1+3, base+input)
+}
+
+func TestNewBtcTransaction_InputDescriptor(t *testing.T) {
+	wallets := newTestWallets(t)
+	chainParams := wallets[0].ChainParams()
+	witnessHash := chainhash.HashB(newTestMultisigScript(t, 2, wallets))
+	p2wsh, err := btcutil.NewAddressWitnessScriptHash(witnessHash, chainParams)
+	require.NoError(t, err)
+	outputs := []BtcOutput{{Address: wallets[0].DeriveNativeAddress(), Amount: 10000}}
+	change := wallets[2].DeriveNativeAddress()
+
+	unspents := newTestUnspents(t, p2wsh, 100000)
+	unspents[0].Spend = &InputDescriptor{Type: InputP2WSHMultisig, M: 2, N: 3}
+	tx, err := NewBtcTransaction(unspents, outputs, change, 1000, chainParams)
+	require.NoError(t, err)
+	require.True(t, tx.HasChange())
+	preview, err := PreviewFee([]InputDescriptor{*unspents[0].Spend}, outputs, -1, 1000, chainParams)
+	require.NoError(t, err)
+	require.Equal(t, preview.Fee, tx.GetFee())
+	require.Equal(t, int(preview.VSize), tx.estimateVSize())
+
+	// Without Spend, the input is estimated as P2PKH.
+	unspents[0].Spend = nil
+	tx, err = NewBtcTransaction(unspents, outputs, change, 1000, chainParams)
+	require.NoError(t, err)
+	require.Greater(t, tx.GetFee(), preview.Fee)
+
+	// The descriptors are kept by the envelope.
+	script, err := txscript.PayToAddrScript(p2wsh)
+	require.NoError(t, err)
+	prevTx := wire.NewMsgTx(2)
+	prevTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 7}, []byte{txscript.OP_TRUE}, nil))
+	prevTx.AddTxOut(wire.NewTxOut(100000, script))
+	unspents = []BtcUnspent{{TxID: prevTx.TxHash().String(), ScriptPubKey: hexString(script), Amount: 100000}}
+	tx, err = NewBtcTransaction(unspents, outputs, change, 1000, chainParams)
+	require.NoError(t, err)
+	tx.inputs[0] = InputDescriptor{Type: InputP2WSHMultisig, M: 2, N: 3}
+	env, err := tx.NewEnvelope([]*wire.MsgTx{prevTx})
+	require.NoError(t, err)
+	decoded, err := TransactionFromEnvelope(env)
+	require.NoError(t, err)
+	require.Equal(t, tx.inputs, decoded.inputs)
+}
@@ -0,0 +1,129 @@
+package btc
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcwallet/wallet/txauthor"
+)
+
+var ErrNothingToSweep = errors.New("no unspents to sweep")
+
+// NewSweepTransaction spends all unspents to destination, which receives
+// their sum minus the fee for feePerKb. There is no change output. The size
+// is estimated from the descriptor of each unspent, so the outputs of scripts
+// other than P2PKH, P2SH-P2WPKH, P2WPKH and P2TR key path must have Spend.
+// opts.CoinSelector and opts.LongTermFeePerKb are ignored.
+func NewSweepTransaction(unspents []BtcUnspent, destination btcutil.Address, feePerKb int64,
+	chainCfg *chaincfg.Params, opts *BtcTxOptions) (*BtcTransaction, error) {
+
+	if opts == nil {
+		opts = &BtcTxOptions{}
+	}
+	policy := opts.Policy
+	if policy == nil {
+		policy = &DefaultPolicy
+	}
+	if destination == nil || feePerKb <= 0 {
+		return nil, errors.New("wrong params")
+	}
+	if len(unspents) == 0 {
+		return nil, ErrNothingToSweep
+	}
+	if !destination.IsForNet(chainCfg) {
+		return nil, errors.New("out address is not the corresponding network address")
+	}
+	pkScript, err := txscript.PayToAddrScript(destination)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := wire.NewMsgTx(wire.TxVersion)
+	prevScripts := make([][]byte, 0, len(unspents))
+	inputValues := make([]btcutil.Amount, 0, len(unspents))
+	inputs := make([]InputDescriptor, 0, len(unspents))
+	var total btcutil.Amount
+	for i, u := range unspents {
+		hash, err := chainhash.NewHashFromStr(u.TxID)
+		if err != nil {
+			return nil, fmt.Errorf("unspent %d: %w", i, err)
+		}
+		script, err := hex.DecodeString(u.ScriptPubKey)
+		if err != nil {
+			return nil, fmt.Errorf("unspent %d: %w", i, err)
+		}
+		spend, err := u.InputDescriptor()
+		if err != nil {
+			return nil, fmt.Errorf("unspent %d: %w", i, err)
+		}
+		if _, err = spend.Weight(); err != nil {
+			return nil, fmt.Errorf("unspent %d: %w", i, err)
+		}
+		if !u.IsMature() {
+			return nil, fmt.Errorf("unspent %d: %w", i, ErrImmatureCoinbase)
+		}
+		amount := btcutil.Amount(u.Amount)
+		tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Hash: *hash, Index: u.Vout}, nil, nil))
+		prevScripts = append(prevScripts, script)
+		inputValues = append(inputValues, amount)
+		inputs = append(inputs, spend)
+		total += amount
+	}
+	tx.AddTxOut(wire.NewTxOut(0, pkScript))
+
+	if opts.Rbf {
+		for _, txIn := range tx.TxIn {
+			txIn.Sequence = MaxRbfSequence
+		}
+	}
+	if opts.LockTime != 0 {
+		setLockTime(tx, opts.LockTime)
+	} else if opts.TipHeight > 0 {
+		setLockTime(tx, AntiFeeSnipingLockTime(opts.TipHeight))
+	}
+
+	t := &BtcTransaction{AuthoredTx: txauthor.AuthoredTx{
+		Tx:              tx,
+		PrevScripts:     prevScripts,
+		PrevInputValues: inputValues,
+		TotalInput:      total,
+		ChangeIndex:     -1,
+	}, chainParams: chainCfg, feePerKb: feePerKb, policy: policy, inputs: inputs}
+
+	// The value of the output doesn't change the size, so the fee is exact
+	// up to the length of the signatures.
+	fee := feeForVSize(feePerKb, t.estimateVSize())
+	tx.TxOut[0].Value = int64(total) - fee
+	if tx.TxOut[0].Value <= 0 {
+		return nil, fmt.Errorf("%w: total %d, fee %d", ErrInsufficientFunds, total, fee)
+	}
+
+	if err = t.CheckPolicy(policy); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// NewSweepTransaction sweeps all unspents of addresses with at least minConf
+// confirmations to destination.
+func (this *BtcClient) NewSweepTransaction(addresses []btcutil.Address, minConf int,
+	destination btcutil.Address, feePerKb int64, chainCfg *chaincfg.Params, opts *BtcTxOptions) (*BtcTransaction, error) {
+
+	if len(addresses) == 0 {
+		return nil, errors.New("wrong params")
+	}
+	utxos, err := this.RpcClient.ListUnspentMinMaxAddresses(minConf, 9999999, addresses)
+	if err != nil {
+		return nil, err
+	}
+	unspents, err := NewBtcUnspentsFromListUnspent(utxos)
+	if err != nil {
+		return nil, err
+	}
+	return NewSweepTransaction(unspents, destination, feePerKb, chainCfg, opts)
+}
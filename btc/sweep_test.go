@@ -0,0 +1,46 @@
+package btc
+
+import (
+	"bytes"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestSweep(t *testing.T) {
+	wallets := newTestWallets(t)
+	chainParams := wallets[0].ChainParams()
+	var unspents []BtcUnspent
+	for _, w := range wallets {
+		unspents = append(unspents, newTestUnspents(t, w.DeriveNativeAddress(), 10000, 20000)...)
+	}
+	dest, err := btcutil.NewAddressWitnessPubKeyHash(bytes.Repeat([]byte{1}, 20), chainParams)
+	require.NoError(t, err)
+
+	tx, err := NewSweepTransaction(unspents, dest, 5000, chainParams, &BtcTxOptions{Rbf: true})
+	require.NoError(t, err)
+	require.Len(t, tx.Tx.TxIn, len(unspents))
+	require.Len(t, tx.Tx.TxOut, 1)
+	require.False(t, tx.HasChange())
+	require.True(t, tx.SignalsRbf())
+	require.Equal(t, sumUnspents(unspents), tx.Tx.TxOut[0].Value+tx.GetFee())
+
+	require.NoError(t, tx.SignWithSecretsSource(multiWallet(wallets)))
+	// The estimate is an upper bound, off by at most a byte per signature.
+	vsize := mempool.GetTxVirtualSize(btcutil.NewTx(tx.Tx))
+	require.GreaterOrEqual(t, tx.GetFee()*1000/vsize, int64(5000))
+	require.LessOrEqual(t, tx.GetFee(), feeForVSize(5000, int(vsize)+len(unspents)*2))
+
+	_, err = NewSweepTransaction(unspents[:1], dest, 100000, chainParams, nil)
+	require.ErrorIs(t, err, ErrInsufficientFunds)
+
+	_, err = NewSweepTransaction(nil, dest, 1000, chainParams, nil)
+	require.ErrorIs(t, err, ErrNothingToSweep)
+
+	// The size of a P2WSH input depends on its script.
+	wsh, err := btcutil.NewAddressWitnessScriptHash(bytes.Repeat([]byte{2}, 32), chainParams)
+	require.NoError(t, err)
+	_, err = NewSweepTransaction(newTestUnspents(t, wsh, 10000), dest, 1000, chainParams, nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,414 @@
+package btc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcwallet/wallet/txauthor"
+	"github.com/lizc2003/hdwallet/wallet"
+)
+
+// MaxTapscriptMultisigKeys is the maximum number of keys of TapscriptMultisig,
+// the limit of the standard stack size.
+const MaxTapscriptMultisigKeys = 999
+
+var ErrNotTapscriptLeaf = errors.New("not a leaf of the taproot tree")
+
+// TaprootNUMSKey is the internal key of BIP-341 nobody knows the private key
+// of, for outputs which can only be spent by a script path.
+var TaprootNUMSKey = func() *btcec.PublicKey {
+	b, _ := hex.DecodeString("0250929b74c1a04954b78b4b6035e97a5e078a5a0f28ec96d547bfee9ace803ac0")
+	key, _ := btcec.ParsePubKey(b)
+	return key
+}()
+
+// TapscriptChecksig is the leaf spendable by key:
+//
+//	<key> OP_CHECKSIG
+func TapscriptChecksig(key *btcec.PublicKey) (txscript.TapLeaf, error) {
+	if key == nil {
+		return txscript.TapLeaf{}, errors.New("wrong params")
+	}
+	script, err := txscript.NewScriptBuilder().
+		AddData(schnorr.SerializePubKey(key)).
+		AddOp(txscript.OP_CHECKSIG).
+		Script()
+	if err != nil {
+		return txscript.TapLeaf{}, err
+	}
+	return txscript.NewBaseTapLeaf(script), nil
+}
+
+// TapscriptDelayedChecksig is the leaf spendable by key after delay, a
+// relative lock sequence (see CsvBlocks and CsvSeconds), or if absolute, a
+// block height or unix timestamp:
+//
+//	<delay> OP_CHECKSEQUENCEVERIFY|OP_CHECKLOCKTIMEVERIFY OP_DROP <key> OP_CHECKSIG
+func TapscriptDelayedChecksig(key *btcec.PublicKey, delay uint32, absolute bool) (txscript.TapLeaf, error) {
+	if key == nil || delay == 0 {
+		return txscript.TapLeaf{}, errors.New("wrong params")
+	}
+	lockOp := byte(txscript.OP_CHECKSEQUENCEVERIFY)
+	if absolute {
+		lockOp = txscript.OP_CHECKLOCKTIMEVERIFY
+	}
+	script, err := txscript.NewScriptBuilder().
+		AddInt64(int64(delay)).
+		AddOp(lockOp).
+		AddOp(txscript.OP_DROP).
+		AddData(schnorr.SerializePubKey(key)).
+		AddOp(txscript.OP_CHECKSIG).
+		Script()
+	if err != nil {
+		return txscript.TapLeaf{}, err
+	}
+	return txscript.NewBaseTapLeaf(script), nil
+}
+
+// TapscriptMultisig is the m-of-n leaf of keys (BIP-342):
+//
+//	<key 1> OP_CHECKSIG <key 2> OP_CHECKSIGADD ... <key n> OP_CHECKSIGADD <m> OP_NUMEQUAL
+//
+// Its witness stack has the signatures of the keys in reverse order, key n
+// first, with an empty item for the keys which do not sign.
+func TapscriptMultisig(m int, keys []*btcec.PublicKey) (txscript.TapLeaf, error) {
+	if m < 1 || m > len(keys) || len(keys) > MaxTapscriptMultisigKeys {
+		return txscript.TapLeaf{}, errors.New("wrong params")
+	}
+	builder := txscript.NewScriptBuilder()
+	for i, key := range keys {
+		if key == nil {
+			return txscript.TapLeaf{}, errors.New("wrong params")
+		}
+		builder.AddData(schnorr.SerializePubKey(key))
+		if i == 0 {
+			builder.AddOp(txscript.OP_CHECKSIG)
+		} else {
+			builder.AddOp(txscript.OP_CHECKSIGADD)
+		}
+	}
+	script, err := builder.AddInt64(int64(m)).AddOp(txscript.OP_NUMEQUAL).Script()
+	if err != nil {
+		return txscript.TapLeaf{}, err
+	}
+	return txscript.NewBaseTapLeaf(script), nil
+}
+
+// TaprootTree is a P2TR output of InternalKey committing to a tree of
+// tapscript leaves. It is spent by the key path with the key of InternalKey
+// tweaked by the root of the tree, or by the script path of any leaf.
+type TaprootTree struct {
+	InternalKey *btcec.PublicKey
+	// Leaves are in the order given to NewTaprootTree.
+	Leaves []txscript.TapLeaf
+
+	tree      *txscript.IndexedTapScriptTree
+	outputKey *btcec.PublicKey
+}
+
+// NewTaprootTree builds a balanced tree of leaves. internalKey is
+// TaprootNUMSKey if the output has no key path. For a MuSig2 key path, it is
+// the InternalKey of the MuSig2Key, whose ScriptRoot is then the RootHash of
+// the tree.
+func NewTaprootTree(internalKey *btcec.PublicKey, leaves ...txscript.TapLeaf) (*TaprootTree, error) {
+	if internalKey == nil || len(leaves) == 0 {
+		return nil, errors.New("wrong params")
+	}
+	seen := make(map[[32]byte]bool, len(leaves))
+	for _, leaf := range leaves {
+		h := leaf.TapHash()
+		if seen[h] {
+			return nil, errors.New("duplicate tapscript leaf")
+		}
+		seen[h] = true
+	}
+
+	tree := txscript.AssembleTaprootScriptTree(leaves...)
+	rootHash := tree.RootNode.TapHash()
+	return &TaprootTree{
+		InternalKey: internalKey,
+		Leaves:      append([]txscript.TapLeaf{}, leaves...),
+		tree:        tree,
+		outputKey:   txscript.ComputeTaprootOutputKey(internalKey, rootHash[:]),
+	}, nil
+}
+
+// RootHash is the merkle root committed to by the output key.
+func (tr *TaprootTree) RootHash() []byte {
+	h := tr.tree.RootNode.TapHash()
+	return h[:]
+}
+
+func (tr *TaprootTree) OutputKey() *btcec.PublicKey {
+	return tr.outputKey
+}
+
+func (tr *TaprootTree) Address(chainCfg *chaincfg.Params) (*btcutil.AddressTaproot, error) {
+	return btcutil.NewAddressTaproot(schnorr.SerializePubKey(tr.outputKey), chainCfg)
+}
+
+func (tr *TaprootTree) PkScript() []byte {
+	return payToTaprootScript(tr.outputKey)
+}
+
+// LeafIndex returns the index of the leaf of script, -1 if none.
+func (tr *TaprootTree) LeafIndex(script []byte) int {
+	for i, leaf := range tr.Leaves {
+		if bytes.Equal(leaf.Script, script) {
+			return i
+		}
+	}
+	return -1
+}
+
+// ControlBlock returns the control block of the script path of leaf index,
+// the last item of its witness.
+func (tr *TaprootTree) ControlBlock(index int) ([]byte, error) {
+	if index < 0 || index >= len(tr.Leaves) {
+		return nil, fmt.Errorf("%w: %d", ErrNotTapscriptLeaf, index)
+	}
+	proof := tr.tree.LeafMerkleProofs[tr.tree.LeafProofIndex[tr.Leaves[index].TapHash()]]
+	controlBlock := proof.ToControlBlock(tr.InternalKey)
+	return controlBlock.ToBytes()
+}
+
+// InputDescriptor returns the descriptor of spending tree by the script path
+// of leaf index, for size estimation. A TapscriptMultisig leaf is assumed to
+// be signed by m keys, any other leaf by a single signature.
+func (tr *TaprootTree) InputDescriptor(index int) (InputDescriptor, error) {
+	if index < 0 || index >= len(tr.Leaves) {
+		return InputDescriptor{}, fmt.Errorf("%w: %d", ErrNotTapscriptLeaf, index)
+	}
+	proof := tr.tree.LeafMerkleProofs[tr.tree.LeafProofIndex[tr.Leaves[index].TapHash()]]
+	script := tr.Leaves[index].Script
+	d := InputDescriptor{Type: InputP2TRScriptPath, LeafScriptSize: len(script),
+		LeafDepth: len(proof.InclusionProof) / txscript.ControlBlockNodeSize}
+	d.M, d.N, _ = parseMultisigLeaf(script)
+	return d, nil
+}
+
+// SignTaprootKeyPath signs input index spending tree by the key path. w must
+// hold the InternalKey of tree.
+func (t *BtcTransaction) SignTaprootKeyPath(index int, tree *TaprootTree, w *wallet.BtcWallet) error {
+	if err := t.checkTaprootTree(index, tree); err != nil {
+		return err
+	}
+	if !bytes.Equal(schnorr.SerializePubKey(w.DeriveNativePublicKey()), schnorr.SerializePubKey(tree.InternalKey)) {
+		return wallet.ErrAddressNotMatch
+	}
+	hashCache, err := t.sigHashes()
+	if err != nil {
+		return err
+	}
+	sig, err := txscript.RawTxInTaprootSignature(t.Tx, hashCache, index, int64(t.PrevInputValues[index]),
+		t.PrevScripts[index], tree.RootHash(), txscript.SigHashDefault, w.DeriveNativePrivateKey())
+	if err != nil {
+		return err
+	}
+	t.Tx.TxIn[index].Witness = wire.TxWitness{sig}
+	return nil
+}
+
+// TapscriptSigHash returns the message signed by the keys of leaf leafIndex
+// of tree for input index, with SigHashDefault.
+func (t *BtcTransaction) TapscriptSigHash(index int, tree *TaprootTree, leafIndex int) ([32]byte, error) {
+	var sigHash [32]byte
+	if err := t.checkTaprootTree(index, tree); err != nil {
+		return sigHash, err
+	}
+	if leafIndex < 0 || leafIndex >= len(tree.Leaves) {
+		return sigHash, fmt.Errorf("%w: %d", ErrNotTapscriptLeaf, leafIndex)
+	}
+	fetcher, err := txauthor.TXPrevOutFetcher(t.Tx, t.PrevScripts, t.PrevInputValues)
+	if err != nil {
+		return sigHash, err
+	}
+	h, err := txscript.CalcTapscriptSignaturehash(txscript.NewTxSigHashes(t.Tx, fetcher),
+		txscript.SigHashDefault, t.Tx, index, fetcher, tree.Leaves[leafIndex])
+	if err != nil {
+		return sigHash, err
+	}
+	copy(sigHash[:], h)
+	return sigHash, nil
+}
+
+// SignTapscript returns the signature of w for the script path of leaf
+// leafIndex of tree by input index, e.g. one of the signatures of a
+// TapscriptMultisig leaf. The lock time and sequences must be set first.
+func (t *BtcTransaction) SignTapscript(index int, tree *TaprootTree, leafIndex int, w *wallet.BtcWallet) ([]byte, error) {
+	sigHash, err := t.TapscriptSigHash(index, tree, leafIndex)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := schnorr.Sign(w.DeriveNativePrivateKey(), sigHash[:])
+	if err != nil {
+		return nil, err
+	}
+	return sig.Serialize(), nil
+}
+
+// SetTapscriptWitness sets the witness of input index spending leaf leafIndex
+// of tree: the stack satisfying the script, then the script and its control
+// block.
+func (t *BtcTransaction) SetTapscriptWitness(index int, tree *TaprootTree, leafIndex int, stack [][]byte) error {
+	if err := t.checkTaprootTree(index, tree); err != nil {
+		return err
+	}
+	controlBlock, err := tree.ControlBlock(leafIndex)
+	if err != nil {
+		return err
+	}
+	witness := make(wire.TxWitness, 0, len(stack)+2)
+	witness = append(witness, stack...)
+	witness = append(witness, tree.Leaves[leafIndex].Script, controlBlock)
+	t.Tx.TxIn[index].Witness = witness
+	return nil
+}
+
+// SetTapscriptDelay sets the sequence or lock time required by the
+// TapscriptDelayedChecksig leaf leafIndex of tree on input index, other leaves
+// need none. The signatures of all inputs commit to them, so the delays of all
+// inputs must be set before any input is signed.
+func (t *BtcTransaction) SetTapscriptDelay(index int, tree *TaprootTree, leafIndex int) error {
+	if err := t.checkTaprootTree(index, tree); err != nil {
+		return err
+	}
+	if leafIndex < 0 || leafIndex >= len(tree.Leaves) {
+		return fmt.Errorf("%w: %d", ErrNotTapscriptLeaf, leafIndex)
+	}
+	leaf, ok := parseChecksigLeaf(tree.Leaves[leafIndex].Script)
+	if !ok || leaf.delay == 0 {
+		return nil
+	}
+	return t.applyDelay(index, leaf.delay, leaf.absolute)
+}
+
+// SignTapscriptInput signs input index spending tree by the script path of
+// the TapscriptChecksig or TapscriptDelayedChecksig leaf of w. For a delayed
+// leaf, the sequence or lock time required by the delay is set, unless that
+// changes a signed input, see SetTapscriptDelay. Tapscript inputs must be
+// signed before the other inputs, see SignWithSecretsSource.
+func (t *BtcTransaction) SignTapscriptInput(index int, tree *TaprootTree, w *wallet.BtcWallet) error {
+	if err := t.checkTaprootTree(index, tree); err != nil {
+		return err
+	}
+	xOnlyKey := schnorr.SerializePubKey(w.DeriveNativePublicKey())
+	leafIndex := -1
+	var leaf *checksigLeaf
+	for i, l := range tree.Leaves {
+		if parsed, ok := parseChecksigLeaf(l.Script); ok && bytes.Equal(parsed.key, xOnlyKey) {
+			leafIndex, leaf = i, parsed
+			break
+		}
+	}
+	if leaf == nil {
+		return wallet.ErrAddressNotMatch
+	}
+	if leaf.delay > 0 {
+		if err := t.applyDelay(index, leaf.delay, leaf.absolute); err != nil {
+			return err
+		}
+	}
+	sig, err := t.SignTapscript(index, tree, leafIndex, w)
+	if err != nil {
+		return err
+	}
+	return t.SetTapscriptWitness(index, tree, leafIndex, [][]byte{sig})
+}
+
+func (t *BtcTransaction) checkTaprootTree(index int, tree *TaprootTree) error {
+	if tree == nil {
+		return errors.New("wrong params")
+	}
+	if index < 0 || index >= len(t.Tx.TxIn) {
+		return fmt.Errorf("input index out of range: %d", index)
+	}
+	if !bytes.Equal(t.PrevScripts[index], tree.PkScript()) {
+		return errors.New("taproot tree does not match the spent output")
+	}
+	return nil
+}
+
+func (t *BtcTransaction) sigHashes() (*txscript.TxSigHashes, error) {
+	fetcher, err := txauthor.TXPrevOutFetcher(t.Tx, t.PrevScripts, t.PrevInputValues)
+	if err != nil {
+		return nil, err
+	}
+	return txscript.NewTxSigHashes(t.Tx, fetcher), nil
+}
+
+// checksigLeaf is a parsed TapscriptChecksig or TapscriptDelayedChecksig.
+type checksigLeaf struct {
+	key      []byte
+	delay    uint32
+	absolute bool
+}
+
+func parseChecksigLeaf(script []byte) (*checksigLeaf, bool) {
+	var ops []byte
+	var data [][]byte
+	tokenizer := txscript.MakeScriptTokenizer(0, script)
+	for tokenizer.Next() {
+		ops = append(ops, tokenizer.Opcode())
+		data = append(data, tokenizer.Data())
+	}
+	if tokenizer.Err() != nil {
+		return nil, false
+	}
+	switch {
+	case len(ops) == 2 && ops[0] == txscript.OP_DATA_32 && ops[1] == txscript.OP_CHECKSIG:
+		return &checksigLeaf{key: data[0]}, true
+	case len(ops) == 5 && ops[2] == txscript.OP_DROP && ops[3] == txscript.OP_DATA_32 && ops[4] == txscript.OP_CHECKSIG:
+		leaf := &checksigLeaf{key: data[3]}
+		switch ops[1] {
+		case txscript.OP_CHECKSEQUENCEVERIFY:
+		case txscript.OP_CHECKLOCKTIMEVERIFY:
+			leaf.absolute = true
+		default:
+			return nil, false
+		}
+		delay, err := decodeScriptNum(ops[0], data[0])
+		if err != nil || delay <= 0 || delay > 0xffffffff {
+			return nil, false
+		}
+		leaf.delay = uint32(delay)
+		return leaf, true
+	}
+	return nil, false
+}
+
+// parseMultisigLeaf returns m and n of a TapscriptMultisig leaf.
+func parseMultisigLeaf(script []byte) (int, int, bool) {
+	var ops []byte
+	var data [][]byte
+	tokenizer := txscript.MakeScriptTokenizer(0, script)
+	for tokenizer.Next() {
+		ops = append(ops, tokenizer.Opcode())
+		data = append(data, tokenizer.Data())
+	}
+	if tokenizer.Err() != nil || len(ops) < 4 || len(ops)%2 != 0 || ops[len(ops)-1] != txscript.OP_NUMEQUAL {
+		return 0, 0, false
+	}
+	n := len(ops)/2 - 1
+	for i := 0; i < n; i++ {
+		op := byte(txscript.OP_CHECKSIGADD)
+		if i == 0 {
+			op = txscript.OP_CHECKSIG
+		}
+		if ops[2*i] != txscript.OP_DATA_32 || ops[2*i+1] != op {
+			return 0, 0, false
+		}
+	}
+	m, err := decodeScriptNum(ops[len(ops)-2], data[len(ops)-2])
+	if err != nil || m < 1 || m > int64(n) {
+		return 0, 0, false
+	}
+	return int(m), n, true
+}
@@ -0,0 +1,144 @@
+package btc
+
+import (
+	"bytes"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lizc2003/hdwallet/wallet"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func newTestTaprootTree(t *testing.T, internalKey *btcec.PublicKey, wallets []*wallet.BtcWallet) *TaprootTree {
+	hot, err := TapscriptChecksig(wallets[2].DeriveNativePublicKey())
+	require.NoError(t, err)
+	recovery, err := TapscriptDelayedChecksig(wallets[0].DeriveNativePublicKey(), CsvBlocks(144), false)
+	require.NoError(t, err)
+	multisig, err := TapscriptMultisig(2, []*btcec.PublicKey{wallets[0].DeriveNativePublicKey(),
+		wallets[1].DeriveNativePublicKey(), wallets[2].DeriveNativePublicKey()})
+	require.NoError(t, err)
+	tree, err := NewTaprootTree(internalKey, hot, recovery, multisig)
+	require.NoError(t, err)
+	return tree
+}
+
+func TestTaprootTree(t *testing.T) {
+	wallets := newTestWallets(t)
+	tree := newTestTaprootTree(t, TaprootNUMSKey, wallets)
+
+	addr, err := tree.Address(wallets[0].ChainParams())
+	require.NoError(t, err)
+	require.Equal(t, "bcrt1p", addr.EncodeAddress()[:6])
+	pkScript, err := txscript.PayToAddrScript(addr)
+	require.NoError(t, err)
+	require.Equal(t, pkScript, tree.PkScript())
+
+	for i, leaf := range tree.Leaves {
+		require.Equal(t, i, tree.LeafIndex(leaf.Script))
+		b, err := tree.ControlBlock(i)
+		require.NoError(t, err)
+		controlBlock, err := txscript.ParseControlBlock(b)
+		require.NoError(t, err)
+		require.NoError(t, txscript.VerifyTaprootLeafCommitment(controlBlock,
+			schnorr.SerializePubKey(tree.OutputKey()), leaf.Script))
+	}
+	require.Equal(t, -1, tree.LeafIndex([]byte{txscript.OP_TRUE}))
+	_, err = tree.ControlBlock(3)
+	require.ErrorIs(t, err, ErrNotTapscriptLeaf)
+
+	// A MuSig2 key path with the same script path.
+	keys := []*btcec.PublicKey{wallets[1].DeriveNativePublicKey(), wallets[2].DeriveNativePublicKey()}
+	aggKey, err := NewMuSig2Key(keys, nil)
+	require.NoError(t, err)
+	tree = newTestTaprootTree(t, aggKey.InternalKey(), wallets)
+	key, err := NewMuSig2Key(keys, tree.RootHash())
+	require.NoError(t, err)
+	require.Equal(t, tree.PkScript(), key.PkScript())
+
+	_, err = NewTaprootTree(TaprootNUMSKey, tree.Leaves[0], tree.Leaves[0])
+	require.Error(t, err)
+	_, err = TapscriptMultisig(3, keys)
+	require.Error(t, err)
+}
+
+func newTaprootSpend(t *testing.T, tree *TaprootTree, w *wallet.BtcWallet) (*BtcTransaction, int) {
+	chainParams := w.ChainParams()
+	treeAddr, err := tree.Address(chainParams)
+	require.NoError(t, err)
+	unspents := append(newTestUnspents(t, treeAddr, 100000), newTestUnspents(t, w.DeriveNativeAddress(), 50000)...)
+	dest, err := btcutil.NewAddressWitnessPubKeyHash(bytes.Repeat([]byte{1}, 20), chainParams)
+	require.NoError(t, err)
+	tx, err := NewBtcTransaction(unspents, []BtcOutput{{Address: dest, Amount: 120000}},
+		w.DeriveNativeAddress(), 1000, chainParams)
+	require.NoError(t, err)
+	require.Len(t, tx.Tx.TxIn, 2)
+	if bytes.Equal(tx.PrevScripts[0], tree.PkScript()) {
+		return tx, 0
+	}
+	return tx, 1
+}
+
+func TestTaprootTree_Spend(t *testing.T) {
+	wallets := newTestWallets(t)
+	tree := newTestTaprootTree(t, wallets[1].DeriveNativePublicKey(), wallets)
+
+	// key path
+	tx, index := newTaprootSpend(t, tree, wallets[2])
+	require.ErrorIs(t, tx.SignTaprootKeyPath(index, tree, wallets[2]), wallet.ErrAddressNotMatch)
+	require.NoError(t, tx.SignTaprootKeyPath(index, tree, wallets[1]))
+	require.NoError(t, tx.Sign(wallets[2]))
+	require.Len(t, tx.Tx.TxIn[index].Witness, 1)
+
+	// hot leaf
+	tx, index = newTaprootSpend(t, tree, wallets[2])
+	require.NoError(t, tx.SignTapscriptInput(index, tree, wallets[2]))
+	require.NoError(t, tx.Sign(wallets[2]))
+	require.Equal(t, uint32(wire.MaxTxInSequenceNum), tx.Tx.TxIn[index].Sequence)
+	require.Len(t, tx.Tx.TxIn[index].Witness, 3)
+
+	// recovery leaf after the delay
+	tx, index = newTaprootSpend(t, tree, wallets[2])
+	require.NoError(t, tx.SignTapscriptInput(index, tree, wallets[0]))
+	require.NoError(t, tx.Sign(wallets[2]))
+	require.Equal(t, CsvBlocks(144), tx.Tx.TxIn[index].Sequence)
+
+	// a key of no single key leaf
+	tx, index = newTaprootSpend(t, tree, wallets[2])
+	require.ErrorIs(t, tx.SignTapscriptInput(index, tree, wallets[1]), wallet.ErrAddressNotMatch)
+
+	// 2-of-3 leaf signed by keys 1 and 3, the last one first
+	sig1, err := tx.SignTapscript(index, tree, 2, wallets[0])
+	require.NoError(t, err)
+	sig3, err := tx.SignTapscript(index, tree, 2, wallets[2])
+	require.NoError(t, err)
+	require.NoError(t, tx.SetTapscriptWitness(index, tree, 2, [][]byte{sig3, {}, sig1}))
+	require.NoError(t, tx.Sign(wallets[2]))
+
+	// two inputs by the recovery leaf
+	treeAddr, err := tree.Address(wallets[2].ChainParams())
+	require.NoError(t, err)
+	unspents := newTestUnspents(t, treeAddr, 100000, 100000)
+	tx, err = NewBtcTransaction(unspents, []BtcOutput{{Address: wallets[2].DeriveNativeAddress(), Amount: 150000}},
+		wallets[2].DeriveNativeAddress(), 1000, wallets[2].ChainParams())
+	require.NoError(t, err)
+	require.Len(t, tx.Tx.TxIn, 2)
+	require.NoError(t, tx.SignTapscriptInput(0, tree, wallets[0]))
+	require.ErrorIs(t, tx.SignTapscriptInput(1, tree, wallets[0]), ErrSignedInputChanged)
+	tx.Tx.TxIn[0].Witness = nil
+	require.NoError(t, tx.SetTapscriptDelay(0, tree, 1))
+	require.NoError(t, tx.SetTapscriptDelay(1, tree, 1))
+	require.NoError(t, tx.SignTapscriptInput(0, tree, wallets[0]))
+	require.NoError(t, tx.SignTapscriptInput(1, tree, wallets[0]))
+	require.NoError(t, validateMsgTx(tx.Tx, tx.PrevScripts, tx.PrevInputValues))
+	require.ErrorIs(t, tx.SetTapscriptDelay(0, tree, 3), ErrNotTapscriptLeaf)
+
+	// a single signature is not enough
+	tx, index = newTaprootSpend(t, tree, wallets[2])
+	sig1, err = tx.SignTapscript(index, tree, 2, wallets[0])
+	require.NoError(t, err)
+	require.NoError(t, tx.SetTapscriptWitness(index, tree, 2, [][]byte{{}, {}, sig1}))
+	require.Error(t, tx.Sign(wallets[2]))
+}
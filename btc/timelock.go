@@ -0,0 +1,313 @@
+package btc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcwallet/wallet/txauthor"
+	"github.com/lizc2003/hdwallet/wallet"
+	"math/rand"
+)
+
+const (
+	// LockTimeThreshold separates block heights from unix timestamps in nLockTime.
+	LockTimeThreshold = txscript.LockTimeThreshold
+
+	antiFeeSnipingMaxDelta = 100
+)
+
+var ErrNotVaultScript = errors.New("not a vault script")
+
+// ErrSignedInputChanged is returned if a delay would change the sequences,
+// version or lock time of a transaction with signed inputs, to which their
+// signatures commit.
+var ErrSignedInputChanged = errors.New("delay would invalidate a signed input")
+
+// AntiFeeSnipingLockTime returns the nLockTime Bitcoin Core uses for a
+// transaction created at tipHeight: the next block can include it, but a
+// miner reorging the tip can't. Sometimes it goes back further, for the
+// privacy of transactions delayed in broadcast.
+func AntiFeeSnipingLockTime(tipHeight int64) uint32 {
+	lockTime := tipHeight
+	if rand.Intn(10) == 0 {
+		lockTime -= int64(rand.Intn(antiFeeSnipingMaxDelta))
+	}
+	if lockTime < 0 {
+		lockTime = 0
+	}
+	return uint32(lockTime)
+}
+
+// CsvBlocks is the input sequence for a relative lock of blocks (BIP68).
+func CsvBlocks(blocks uint16) uint32 {
+	return blockchain.LockTimeToSequence(false, uint32(blocks))
+}
+
+// CsvSeconds is the input sequence for a relative lock of seconds, rounded
+// down to a multiple of 512 seconds (BIP68).
+func CsvSeconds(seconds uint32) uint32 {
+	return blockchain.LockTimeToSequence(true, seconds)
+}
+
+// IsRelativeLockTime reports whether sequence enables a BIP68 relative lock.
+func IsRelativeLockTime(sequence uint32) bool {
+	return sequence&wire.SequenceLockTimeDisabled == 0
+}
+
+// SetLockTime sets nLockTime, a block height or a unix timestamp from
+// LockTimeThreshold. Final input sequences are lowered so that it is enforced.
+// It must be called before signing.
+func (t *BtcTransaction) SetLockTime(lockTime uint32) {
+	setLockTime(t.Tx, lockTime)
+}
+
+func setLockTime(tx *wire.MsgTx, lockTime uint32) {
+	tx.LockTime = lockTime
+	if lockTime == 0 {
+		return
+	}
+	for _, txIn := range tx.TxIn {
+		if txIn.Sequence == wire.MaxTxInSequenceNum {
+			txIn.Sequence = wire.MaxTxInSequenceNum - 1
+		}
+	}
+}
+
+// SetSequence sets the sequence of an input, e.g. CsvBlocks(n) to spend a CSV
+// locked output. It must be called before signing.
+func (t *BtcTransaction) SetSequence(index int, sequence uint32) error {
+	if index < 0 || index >= len(t.Tx.TxIn) {
+		return fmt.Errorf("input index out of range: %d", index)
+	}
+	t.Tx.TxIn[index].Sequence = sequence
+	if IsRelativeLockTime(sequence) && t.Tx.Version < 2 {
+		// BIP68 only applies to version 2 transactions.
+		t.Tx.Version = 2
+	}
+	return nil
+}
+
+// VaultScript is a P2WSH script spendable by HotKey at any time, or by
+// RecoveryKey after Delay:
+//
+//	OP_IF <hot key> OP_CHECKSIG
+//	OP_ELSE <delay> OP_CHECKSEQUENCEVERIFY|OP_CHECKLOCKTIMEVERIFY OP_DROP <recovery key> OP_CHECKSIG
+//	OP_ENDIF
+type VaultScript struct {
+	HotKey      *btcec.PublicKey
+	RecoveryKey *btcec.PublicKey
+	// Delay is a relative lock sequence (see CsvBlocks and CsvSeconds), or
+	// if Absolute, a block height or unix timestamp.
+	Delay    uint32
+	Absolute bool
+}
+
+func (v *VaultScript) Script() ([]byte, error) {
+	if v.HotKey == nil || v.RecoveryKey == nil {
+		return nil, errors.New("vault keys are required")
+	}
+	lockOp := byte(txscript.OP_CHECKSEQUENCEVERIFY)
+	if v.Absolute {
+		lockOp = txscript.OP_CHECKLOCKTIMEVERIFY
+	}
+	return txscript.NewScriptBuilder().
+		AddOp(txscript.OP_IF).
+		AddData(v.HotKey.SerializeCompressed()).
+		AddOp(txscript.OP_CHECKSIG).
+		AddOp(txscript.OP_ELSE).
+		AddInt64(int64(v.Delay)).
+		AddOp(lockOp).
+		AddOp(txscript.OP_DROP).
+		AddData(v.RecoveryKey.SerializeCompressed()).
+		AddOp(txscript.OP_CHECKSIG).
+		AddOp(txscript.OP_ENDIF).
+		Script()
+}
+
+func (v *VaultScript) Address(chainCfg *chaincfg.Params) (*btcutil.AddressWitnessScriptHash, error) {
+	script, err := v.Script()
+	if err != nil {
+		return nil, err
+	}
+	return btcutil.NewAddressWitnessScriptHash(chainhash.HashB(script), chainCfg)
+}
+
+// ParseVaultScript is the inverse of VaultScript.Script.
+func ParseVaultScript(script []byte) (*VaultScript, error) {
+	var ops []byte
+	var data [][]byte
+	tokenizer := txscript.MakeScriptTokenizer(0, script)
+	for tokenizer.Next() {
+		ops = append(ops, tokenizer.Opcode())
+		data = append(data, tokenizer.Data())
+	}
+	if tokenizer.Err() != nil || len(ops) != 10 ||
+		ops[0] != txscript.OP_IF || ops[2] != txscript.OP_CHECKSIG || ops[3] != txscript.OP_ELSE ||
+		ops[6] != txscript.OP_DROP || ops[8] != txscript.OP_CHECKSIG || ops[9] != txscript.OP_ENDIF {
+		return nil, ErrNotVaultScript
+	}
+
+	v := &VaultScript{}
+	switch ops[5] {
+	case txscript.OP_CHECKSEQUENCEVERIFY:
+	case txscript.OP_CHECKLOCKTIMEVERIFY:
+		v.Absolute = true
+	default:
+		return nil, ErrNotVaultScript
+	}
+	delay, err := decodeScriptNum(ops[4], data[4])
+	if err != nil || delay < 0 || delay > 0xffffffff {
+		return nil, ErrNotVaultScript
+	}
+	v.Delay = uint32(delay)
+	if v.HotKey, err = btcec.ParsePubKey(data[1]); err != nil {
+		return nil, ErrNotVaultScript
+	}
+	if v.RecoveryKey, err = btcec.ParsePubKey(data[7]); err != nil {
+		return nil, ErrNotVaultScript
+	}
+	return v, nil
+}
+
+// SetVaultDelay sets the sequence or lock time required by the recovery path
+// of vault on input index. The signatures of all inputs commit to them, so the
+// delays of all inputs must be set before any input is signed.
+func (t *BtcTransaction) SetVaultDelay(index int, vault *VaultScript) error {
+	if _, err := t.checkVaultScript(index, vault); err != nil {
+		return err
+	}
+	return t.applyDelay(index, vault.Delay, vault.Absolute)
+}
+
+// SignVaultInput signs input index spending a vault output. The hot path is
+// used if w holds HotKey, the recovery path if it holds RecoveryKey, in which
+// case the sequence or lock time required by the delay is set, unless that
+// changes a signed input, see SetVaultDelay. Vault inputs must be signed
+// before the other inputs, see SignWithSecretsSource.
+func (t *BtcTransaction) SignVaultInput(index int, vault *VaultScript, w *wallet.BtcWallet) error {
+	script, err := t.checkVaultScript(index, vault)
+	if err != nil {
+		return err
+	}
+
+	pubKey := w.DeriveNativePublicKey()
+	var selector []byte
+	switch {
+	case pubKey.IsEqual(vault.HotKey):
+		selector = []byte{1}
+	case pubKey.IsEqual(vault.RecoveryKey):
+		if err = t.applyDelay(index, vault.Delay, vault.Absolute); err != nil {
+			return err
+		}
+	default:
+		return wallet.ErrAddressNotMatch
+	}
+
+	fetcher, err := txauthor.TXPrevOutFetcher(t.Tx, t.PrevScripts, t.PrevInputValues)
+	if err != nil {
+		return err
+	}
+	sig, err := txscript.RawTxInWitnessSignature(t.Tx, txscript.NewTxSigHashes(t.Tx, fetcher), index,
+		int64(t.PrevInputValues[index]), script, txscript.SigHashAll, w.DeriveNativePrivateKey())
+	if err != nil {
+		return err
+	}
+	t.Tx.TxIn[index].Witness = wire.TxWitness{sig, selector, script}
+	return nil
+}
+
+func (t *BtcTransaction) checkVaultScript(index int, vault *VaultScript) ([]byte, error) {
+	if vault == nil {
+		return nil, errors.New("wrong params")
+	}
+	if index < 0 || index >= len(t.Tx.TxIn) {
+		return nil, fmt.Errorf("input index out of range: %d", index)
+	}
+	script, err := vault.Script()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(t.PrevScripts[index], payToWitnessScriptHash(script)) {
+		return nil, errors.New("vault script does not match the spent output")
+	}
+	return script, nil
+}
+
+// applyDelay sets the lock time or the sequence of input index required by a
+// script locked for delay, see VaultScript. It fails with
+// ErrSignedInputChanged if another input is signed and the transaction changes.
+func (t *BtcTransaction) applyDelay(index int, delay uint32, absolute bool) error {
+	if index < 0 || index >= len(t.Tx.TxIn) {
+		return fmt.Errorf("input index out of range: %d", index)
+	}
+	tx := t.Tx.Copy()
+	if !absolute {
+		tx.TxIn[index].Sequence = delay
+		if IsRelativeLockTime(delay) && tx.Version < 2 {
+			// BIP68 only applies to version 2 transactions.
+			tx.Version = 2
+		}
+	} else {
+		if tx.LockTime < delay || (tx.LockTime < LockTimeThreshold) != (delay < LockTimeThreshold) {
+			setLockTime(tx, delay)
+		}
+		if tx.TxIn[index].Sequence == wire.MaxTxInSequenceNum {
+			tx.TxIn[index].Sequence = wire.MaxTxInSequenceNum - 1
+		}
+	}
+
+	changed := tx.Version != t.Tx.Version || tx.LockTime != t.Tx.LockTime
+	for i, txIn := range tx.TxIn {
+		changed = changed || txIn.Sequence != t.Tx.TxIn[i].Sequence
+	}
+	if !changed {
+		return nil
+	}
+	for i, txIn := range t.Tx.TxIn {
+		if i != index && (len(txIn.SignatureScript) > 0 || len(txIn.Witness) > 0) {
+			return fmt.Errorf("%w: %d", ErrSignedInputChanged, i)
+		}
+	}
+	t.Tx.Version = tx.Version
+	t.Tx.LockTime = tx.LockTime
+	for i, txIn := range tx.TxIn {
+		t.Tx.TxIn[i].Sequence = txIn.Sequence
+	}
+	return nil
+}
+
+func payToWitnessScriptHash(script []byte) []byte {
+	pkScript, _ := txscript.NewScriptBuilder().AddOp(txscript.OP_0).AddData(chainhash.HashB(script)).Script()
+	return pkScript
+}
+
+// decodeScriptNum decodes a minimally encoded number of up to 5 bytes.
+func decodeScriptNum(op byte, data []byte) (int64, error) {
+	switch {
+	case op == txscript.OP_0:
+		return 0, nil
+	case op == txscript.OP_1NEGATE:
+		return -1, nil
+	case op >= txscript.OP_1 && op <= txscript.OP_16:
+		return int64(op - (txscript.OP_1 - 1)), nil
+	case len(data) == 0 || len(data) > 5:
+		return 0, errors.New("invalid script number")
+	}
+
+	var v int64
+	for i, b := range data {
+		v |= int64(b) << uint(8*i)
+	}
+	if data[len(data)-1]&0x80 != 0 {
+		v &= ^(int64(0x80) << uint(8*(len(data)-1)))
+		v = -v
+	}
+	return v, nil
+}
@@ -0,0 +1,151 @@
+package btc
+
+import (
+	"bytes"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lizc2003/hdwallet/wallet"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestVaultScript(t *testing.T) {
+	wallets := newTestWallets(t)
+	hot, recovery := wallets[2], wallets[0]
+
+	for _, vault := range []*VaultScript{
+		{HotKey: hot.DeriveNativePublicKey(), RecoveryKey: recovery.DeriveNativePublicKey(), Delay: CsvBlocks(144)},
+		{HotKey: hot.DeriveNativePublicKey(), RecoveryKey: recovery.DeriveNativePublicKey(), Delay: CsvSeconds(86400)},
+		{HotKey: hot.DeriveNativePublicKey(), RecoveryKey: recovery.DeriveNativePublicKey(), Delay: 800000, Absolute: true},
+		{HotKey: hot.DeriveNativePublicKey(), RecoveryKey: recovery.DeriveNativePublicKey(), Delay: 5, Absolute: true},
+	} {
+		script, err := vault.Script()
+		require.NoError(t, err)
+		parsed, err := ParseVaultScript(script)
+		require.NoError(t, err)
+		require.Equal(t, vault.Delay, parsed.Delay)
+		require.Equal(t, vault.Absolute, parsed.Absolute)
+		require.True(t, vault.HotKey.IsEqual(parsed.HotKey))
+		require.True(t, vault.RecoveryKey.IsEqual(parsed.RecoveryKey))
+
+		addr, err := vault.Address(hot.ChainParams())
+		require.NoError(t, err)
+		require.Equal(t, "bcrt1q", addr.EncodeAddress()[:6])
+	}
+
+	_, err := ParseVaultScript([]byte{0x51})
+	require.ErrorIs(t, err, ErrNotVaultScript)
+	require.Equal(t, uint32(0x400000|168), CsvSeconds(86400))
+}
+
+func newVaultSpend(t *testing.T, vault *VaultScript, w *wallet.BtcWallet) *BtcTransaction {
+	chainParams := w.ChainParams()
+	vaultAddr, err := vault.Address(chainParams)
+	require.NoError(t, err)
+	unspents := append(newTestUnspents(t, vaultAddr, 100000), newTestUnspents(t, w.DeriveNativeAddress(), 50000)...)
+	dest, err := btcutil.NewAddressWitnessPubKeyHash(bytes.Repeat([]byte{1}, 20), chainParams)
+	require.NoError(t, err)
+	tx, err := NewBtcTransaction(unspents, []BtcOutput{{Address: dest, Amount: 120000}},
+		w.DeriveNativeAddress(), 1000, chainParams)
+	require.NoError(t, err)
+	require.Len(t, tx.Tx.TxIn, 2)
+	return tx
+}
+
+func TestVaultScript_Spend(t *testing.T) {
+	wallets := newTestWallets(t)
+	hot, recovery := wallets[2], wallets[0]
+	csv := &VaultScript{HotKey: hot.DeriveNativePublicKey(), RecoveryKey: recovery.DeriveNativePublicKey(),
+		Delay: CsvBlocks(10)}
+
+	// hot path, no delay
+	tx := newVaultSpend(t, csv, hot)
+	require.NoError(t, tx.SignVaultInput(0, csv, hot))
+	require.Equal(t, wire.MaxTxInSequenceNum, tx.Tx.TxIn[0].Sequence)
+	require.NoError(t, tx.Sign(hot))
+	require.Len(t, tx.Tx.TxIn[0].Witness, 3)
+
+	// recovery path sets the sequence
+	tx = newVaultSpend(t, csv, hot)
+	require.NoError(t, tx.SignVaultInput(0, csv, recovery))
+	require.Equal(t, uint32(10), tx.Tx.TxIn[0].Sequence)
+	require.Equal(t, int32(2), tx.Tx.Version)
+	require.NoError(t, tx.Sign(hot))
+
+	cltv := &VaultScript{HotKey: hot.DeriveNativePublicKey(), RecoveryKey: recovery.DeriveNativePublicKey(),
+		Delay: 1000, Absolute: true}
+	tx = newVaultSpend(t, cltv, hot)
+	require.NoError(t, tx.SignVaultInput(0, cltv, recovery))
+	require.Equal(t, uint32(1000), tx.Tx.LockTime)
+	require.NoError(t, tx.Sign(hot))
+
+	require.ErrorIs(t, tx.SignVaultInput(0, cltv, wallets[1]), wallet.ErrAddressNotMatch)
+	require.Error(t, tx.SignVaultInput(0, csv, hot))
+
+	// with a nested P2WPKH input
+	tx = newVaultSpend(t, csv, wallets[1])
+	require.NoError(t, tx.SignVaultInput(0, csv, hot))
+	require.NoError(t, tx.Sign(wallets[1]))
+	require.Len(t, tx.Tx.TxIn[1].Witness, 2)
+	require.NotEmpty(t, tx.Tx.TxIn[1].SignatureScript)
+	require.NoError(t, tx.Validate())
+}
+
+func TestVaultScript_SpendDelayed(t *testing.T) {
+	wallets := newTestWallets(t)
+	hot, recovery := wallets[2], wallets[0]
+	chainParams := hot.ChainParams()
+	csv := &VaultScript{HotKey: hot.DeriveNativePublicKey(), RecoveryKey: recovery.DeriveNativePublicKey(),
+		Delay: CsvBlocks(10)}
+	cltv := &VaultScript{HotKey: hot.DeriveNativePublicKey(), RecoveryKey: recovery.DeriveNativePublicKey(),
+		Delay: 1000, Absolute: true}
+	csvAddr, err := csv.Address(chainParams)
+	require.NoError(t, err)
+	cltvAddr, err := cltv.Address(chainParams)
+	require.NoError(t, err)
+	newSpend := func() *BtcTransaction {
+		unspents := newTestUnspents(t, csvAddr, 100000)
+		unspents = append(unspents, newTestUnspents(t, cltvAddr, 100000)...)
+		unspents[1].TxID = unspents[0].TxID
+		unspents[1].Vout = 1
+		unspents = append(unspents, newTestUnspents(t, hot.DeriveNativeAddress(), 50000)...)
+		tx, err := NewBtcTransaction(unspents, []BtcOutput{{Address: hot.DeriveNativeAddress(), Amount: 220000}},
+			hot.DeriveNativeAddress(), 1000, chainParams)
+		require.NoError(t, err)
+		require.Len(t, tx.Tx.TxIn, 3)
+		return tx
+	}
+
+	// Signing the second input changes the transaction the first signed.
+	tx := newSpend()
+	require.NoError(t, tx.SignVaultInput(0, csv, recovery))
+	require.ErrorIs(t, tx.SignVaultInput(1, cltv, recovery), ErrSignedInputChanged)
+
+	tx = newSpend()
+	require.NoError(t, tx.SetVaultDelay(0, csv))
+	require.NoError(t, tx.SetVaultDelay(1, cltv))
+	require.NoError(t, tx.SignVaultInput(0, csv, recovery))
+	require.NoError(t, tx.SignVaultInput(1, cltv, recovery))
+	require.NoError(t, tx.Sign(hot))
+	require.Equal(t, CsvBlocks(10), tx.Tx.TxIn[0].Sequence)
+	require.Equal(t, uint32(1000), tx.Tx.LockTime)
+	require.NoError(t, validateMsgTx(tx.Tx, tx.PrevScripts, tx.PrevInputValues))
+}
+
+func TestAntiFeeSniping(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		lockTime := AntiFeeSnipingLockTime(800000)
+		require.LessOrEqual(t, lockTime, uint32(800000))
+		require.Greater(t, lockTime, uint32(800000-100))
+	}
+
+	wallets := newTestWallets(t)
+	w := wallets[2]
+	unspents := newTestUnspents(t, w.DeriveNativeAddress(), 100000)
+	tx, err := NewBtcTransactionWithOptions(unspents, []BtcOutput{{Address: w.DeriveNativeAddress(), Amount: 50000}},
+		w.DeriveNativeAddress(), 1000, w.ChainParams(), &BtcTxOptions{TipHeight: 1000})
+	require.NoError(t, err)
+	require.Greater(t, tx.Tx.LockTime, uint32(900))
+	require.Equal(t, wire.MaxTxInSequenceNum-1, tx.Tx.TxIn[0].Sequence)
+	require.NoError(t, tx.Sign(w))
+}
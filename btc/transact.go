@@ -14,36 +14,78 @@ import (
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcwallet/wallet/txauthor"
 	"github.com/lizc2003/hdwallet/wallet"
+	"math"
 )
 
-type BtcUnspent struct {
-	TxID         string  `json:"txid"`
-	Vout         uint32  `json:"vout"`
-	ScriptPubKey string  `json:"scriptPubKey"`
-	RedeemScript string  `json:"redeemScript,omitempty"`
-	Amount       float64 `json:"amount"`
-}
-
 type BtcOutput struct {
 	Address btcutil.Address `json:"address"`
 	Amount  int64           `json:"amount"`
+	// PkScript pays to a raw output script instead of Address.
+	PkScript []byte `json:"pkScript,omitempty"`
+	// OpReturn embeds data in an OP_RETURN output instead of paying to Address.
+	OpReturn []byte `json:"opReturn,omitempty"`
+}
+
+// NewOpReturnOutput creates a zero value output carrying data, e.g. a payment commitment.
+func NewOpReturnOutput(data []byte) BtcOutput {
+	return BtcOutput{OpReturn: data}
+}
+
+func NewScriptOutput(pkScript []byte, amount int64) BtcOutput {
+	return BtcOutput{PkScript: pkScript, Amount: amount}
 }
 
 type BtcTransaction struct {
 	txauthor.AuthoredTx
 	chainParams *chaincfg.Params
 	feePerKb    int64
+	selection   *CoinSelection
+	policy      *Policy
+	// inputs are the descriptors of the inputs of Tx, for the estimation of
+	// its size. They are derived from PrevScripts if nil.
+	inputs []InputDescriptor
+}
+
+// BtcTxOptions are the optional settings of NewBtcTransactionWithOptions.
+type BtcTxOptions struct {
+	// CoinSelector chooses the unspents to spend. If nil, unspents are spent in order.
+	CoinSelector CoinSelector
+	// LongTermFeePerKb is the fee rate the waste metric compares against.
+	LongTermFeePerKb int64
+	// Rbf signals replace-by-fee (BIP125) on all inputs.
+	Rbf bool
+	// LockTime is the nLockTime of the transaction. If zero and TipHeight is
+	// known, an anti fee sniping lock time is used.
+	LockTime  uint32
+	TipHeight int64
+	// Policy is checked before signing and broadcasting, DefaultPolicy if nil.
+	Policy *Policy
 }
 
 func NewBtcTransaction(unspents []BtcUnspent, outputs []BtcOutput,
 	changeAddress btcutil.Address, feePerKb int64, chainCfg *chaincfg.Params) (*BtcTransaction, error) {
+	return NewBtcTransactionWithOptions(unspents, outputs, changeAddress, feePerKb, chainCfg, nil)
+}
 
+func NewBtcTransactionWithOptions(unspents []BtcUnspent, outputs []BtcOutput,
+	changeAddress btcutil.Address, feePerKb int64, chainCfg *chaincfg.Params, opts *BtcTxOptions) (*BtcTransaction, error) {
+
+	if opts == nil {
+		opts = &BtcTxOptions{}
+	}
+	policy := opts.Policy
+	if policy == nil {
+		policy = &DefaultPolicy
+	}
 	if len(unspents) == 0 || changeAddress == nil || feePerKb <= 0 {
 		return nil, errors.New("wrong params")
 	}
 	if !changeAddress.IsForNet(chainCfg) {
 		return nil, errors.New("change address is not the corresponding network address")
 	}
+	if unspents = matureUnspents(unspents); len(unspents) == 0 {
+		return nil, ErrImmatureCoinbase
+	}
 	changeBytes, err := txscript.PayToAddrScript(changeAddress)
 	if err != nil {
 		return nil, err
@@ -51,7 +93,7 @@ func NewBtcTransaction(unspents []BtcUnspent, outputs []BtcOutput,
 
 	feeRatePerKb := btcutil.Amount(feePerKb)
 
-	txOuts, err := makeTxOutputs(outputs, feeRatePerKb, chainCfg)
+	txOuts, err := makeTxOutputs(outputs, policy, chainCfg)
 	if err != nil {
 		return nil, err
 	}
@@ -63,9 +105,54 @@ func NewBtcTransaction(unspents []BtcUnspent, outputs []BtcOutput,
 		ScriptSize: len(changeBytes),
 	}
 
-	unsignedTx, err := txauthor.NewUnsignedTransaction(txOuts, feeRatePerKb, makeInputSource(unspents), &changeSource)
-	if err != nil {
-		return nil, err
+	var selection *CoinSelection
+	var unsignedTx *txauthor.AuthoredTx
+	var inputs []InputDescriptor
+	inputSource := makeInputSource(unspents)
+	if opts.CoinSelector != nil {
+		weight, err := selectionBaseWeight(outputScriptSizes(txOuts, 0), len(unspents))
+		if err != nil {
+			return nil, err
+		}
+		target := txauthor.SumOutputValues(txOuts) + btcutil.Amount(feeForWeight(feePerKb, weight))
+		params := CoinSelectionParams{
+			FeePerKb:         feePerKb,
+			LongTermFeePerKb: opts.LongTermFeePerKb,
+			ChangeScriptSize: len(changeBytes),
+		}
+		selection, err = opts.CoinSelector.Select(unspents, int64(target), params)
+		if err != nil {
+			return nil, err
+		}
+		if selection.Changeless {
+			unsignedTx, inputs, err = newChangelessTransaction(txOuts, feeRatePerKb, selection.Unspents,
+				changeSource, params.costOfChange())
+			if err != nil && !errors.Is(err, ErrInsufficientFunds) {
+				return nil, err
+			}
+		}
+		// Exactly the selected unspents are spent, the fees of the selector
+		// bound the estimation of the transaction.
+		unspents = selection.Unspents
+		inputSource = makeSelectedInputSource(unspents)
+	}
+
+	if unsignedTx == nil {
+		unsignedTx, inputs, err = newUnsignedTransaction(txOuts, feeRatePerKb, inputSource,
+			&changeSource, describeUnspents(unspents))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.Rbf {
+		for _, txIn := range unsignedTx.Tx.TxIn {
+			txIn.Sequence = MaxRbfSequence
+		}
+	}
+	if opts.LockTime != 0 {
+		setLockTime(unsignedTx.Tx, opts.LockTime)
+	} else if opts.TipHeight > 0 {
+		setLockTime(unsignedTx.Tx, AntiFeeSnipingLockTime(opts.TipHeight))
 	}
 	// Randomize change position, if change exists, before signing.  This
 	// doesn't affect the serialize size, so the change amount will still
@@ -74,15 +161,51 @@ func NewBtcTransaction(unspents []BtcUnspent, outputs []BtcOutput,
 		unsignedTx.RandomizeChangePosition()
 	}
 
-	return &BtcTransaction{*unsignedTx, chainCfg, feePerKb}, nil
+	t := &BtcTransaction{AuthoredTx: *unsignedTx, chainParams: chainCfg,
+		feePerKb: feePerKb, selection: selection, policy: policy, inputs: inputs}
+	if err = t.CheckPolicy(policy); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// newChangelessTransaction builds the transaction of a changeless selection
+// from the selected unspents only, so that no other input goes to the fee.
+// The excess is dropped to the fee if it is not more than costOfChange,
+// otherwise nil is returned and the transaction is built with change.
+func newChangelessTransaction(txOuts []*wire.TxOut, feeRatePerKb btcutil.Amount, selected []BtcUnspent,
+	changeSource txauthor.ChangeSource, costOfChange int64) (*txauthor.AuthoredTx, []InputDescriptor, error) {
+
+	// Don't reserve the fee of a change output.
+	changeSource.ScriptSize = 0
+	unsignedTx, inputs, err := newUnsignedTransaction(txOuts, feeRatePerKb, makeSelectedInputSource(selected),
+		&changeSource, describeUnspents(selected))
+	if err != nil {
+		return nil, nil, err
+	}
+	if unsignedTx.ChangeIndex >= 0 {
+		if unsignedTx.Tx.TxOut[unsignedTx.ChangeIndex].Value > costOfChange {
+			return nil, nil, nil
+		}
+		unsignedTx.Tx.TxOut = unsignedTx.Tx.TxOut[:unsignedTx.ChangeIndex]
+		unsignedTx.ChangeIndex = -1
+	}
+	return unsignedTx, inputs, nil
 }
 
 func (t *BtcTransaction) Sign(wallet *wallet.BtcWallet) error {
 	return t.SignWithSecretsSource(wallet)
 }
 
+// SignWithSecretsSource signs all inputs. Inputs which already have a
+// witness, e.g. from SignVaultInput, are kept as they are.
 func (t *BtcTransaction) SignWithSecretsSource(secretsSource txauthor.SecretsSource) error {
-	err := t.AddAllInputScripts(secretsSource)
+	var err error
+	if t.Tx.HasWitness() {
+		err = t.addMissingInputScripts(secretsSource)
+	} else {
+		err = t.AddAllInputScripts(secretsSource)
+	}
 	if err != nil {
 		return err
 	}
@@ -94,6 +217,96 @@ func (t *BtcTransaction) SignWithSecretsSource(secretsSource txauthor.SecretsSou
 	return nil
 }
 
+// addMissingInputScripts is txauthor.AddAllInputScripts for the inputs without witness.
+func (t *BtcTransaction) addMissingInputScripts(secrets txauthor.SecretsSource) error {
+	fetcher, err := txauthor.TXPrevOutFetcher(t.Tx, t.PrevScripts, t.PrevInputValues)
+	if err != nil {
+		return err
+	}
+	hashCache := txscript.NewTxSigHashes(t.Tx, fetcher)
+	chainParams := secrets.ChainParams()
+
+	for i, txIn := range t.Tx.TxIn {
+		if len(txIn.Witness) > 0 {
+			continue
+		}
+		pkScript := t.PrevScripts[i]
+		value := int64(t.PrevInputValues[i])
+		_, addrs, _, err := txscript.ExtractPkScriptAddrs(pkScript, chainParams)
+		if err != nil {
+			return err
+		}
+		if len(addrs) != 1 {
+			return fmt.Errorf("cannot sign input %d", i)
+		}
+
+		switch {
+		case txscript.IsPayToScriptHash(pkScript):
+			// nested P2WPKH, the key is looked up by the P2SH address and the
+			// redeem script derived from it, as txauthor does.
+			privKey, _, err := secrets.GetKey(addrs[0])
+			if err != nil {
+				return err
+			}
+			witnessAddr, err := btcutil.NewAddressWitnessPubKeyHash(
+				btcutil.Hash160(privKey.PubKey().SerializeCompressed()), chainParams)
+			if err != nil {
+				return err
+			}
+			redeemScript, err := txscript.PayToAddrScript(witnessAddr)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(btcutil.Hash160(redeemScript), addrs[0].ScriptAddress()) {
+				return fmt.Errorf("cannot sign input %d", i)
+			}
+			sigScript, err := txscript.NewScriptBuilder().AddData(redeemScript).Script()
+			if err != nil {
+				return err
+			}
+			txIn.SignatureScript = sigScript
+			txIn.Witness, err = txscript.WitnessSignature(t.Tx, hashCache, i, value,
+				redeemScript, txscript.SigHashAll, privKey, true)
+			if err != nil {
+				return err
+			}
+
+		case txscript.IsPayToWitnessPubKeyHash(pkScript):
+			privKey, _, err := secrets.GetKey(addrs[0])
+			if err != nil {
+				return err
+			}
+			txIn.Witness, err = txscript.WitnessSignature(t.Tx, hashCache, i, value,
+				pkScript, txscript.SigHashAll, privKey, true)
+			if err != nil {
+				return err
+			}
+
+		case txscript.IsPayToTaproot(pkScript):
+			privKey, _, err := secrets.GetKey(addrs[0])
+			if err != nil {
+				return err
+			}
+			txIn.Witness, err = txscript.TaprootWitnessSignature(t.Tx, hashCache, i, value,
+				pkScript, txscript.SigHashDefault, privKey)
+			if err != nil {
+				return err
+			}
+
+		case txscript.IsPayToWitnessScriptHash(pkScript):
+			return fmt.Errorf("input %d spends a witness script and must be signed first", i)
+
+		default:
+			txIn.SignatureScript, err = txscript.SignTxOutput(chainParams, t.Tx, i,
+				pkScript, txscript.SigHashAll, secrets, secrets, txIn.SignatureScript)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func (t *BtcTransaction) GetFee() int64 {
 	fee := t.TotalInput - txauthor.SumOutputValues(t.Tx.TxOut)
 	return int64(fee)
@@ -107,13 +320,13 @@ func (t *BtcTransaction) HasChange() bool {
 	return t.ChangeIndex >= 0
 }
 
+// CoinSelection returns the result of the CoinSelector, or nil if none was used.
+func (t *BtcTransaction) CoinSelection() *CoinSelection {
+	return t.selection
+}
+
 func (t *BtcTransaction) Serialize() (string, error) {
-	// Serialize the transaction and convert to hex string.
-	buf := bytes.NewBuffer(make([]byte, 0, t.Tx.SerializeSize()))
-	if err := t.Tx.Serialize(buf); err != nil {
-		return "", err
-	}
-	return hex.EncodeToString(buf.Bytes()), nil
+	return SerializeMsgTx(t.Tx)
 }
 
 func (t *BtcTransaction) GetTxid() string {
@@ -125,6 +338,19 @@ func (t *BtcTransaction) Decode() *btcjson.TxRawDecodeResult {
 }
 
 func (t *BtcTransaction) Send(client *rpcclient.Client, allowHighFees bool) (*chainhash.Hash, error) {
+	policy := t.policy
+	if policy == nil {
+		policy = &DefaultPolicy
+	}
+	if allowHighFees {
+		relaxed := *policy
+		relaxed.MaxFee = 0
+		relaxed.MaxFeePerKb = 0
+		policy = &relaxed
+	}
+	if err := t.CheckPolicy(policy); err != nil {
+		return nil, err
+	}
 	hash, err := client.SendRawTransaction(t.Tx, allowHighFees)
 	if err != nil {
 		return nil, err
@@ -132,7 +358,7 @@ func (t *BtcTransaction) Send(client *rpcclient.Client, allowHighFees bool) (*ch
 	return hash, nil
 }
 
-func makeTxOutputs(outputs []BtcOutput, relayFeePerKb btcutil.Amount, chainCfg *chaincfg.Params) ([]*wire.TxOut, error) {
+func makeTxOutputs(outputs []BtcOutput, policy *Policy, chainCfg *chaincfg.Params) ([]*wire.TxOut, error) {
 	outLen := len(outputs)
 	if outLen == 0 {
 		return nil, errors.New("tx output is empty")
@@ -142,12 +368,7 @@ func makeTxOutputs(outputs []BtcOutput, relayFeePerKb btcutil.Amount, chainCfg *
 	for i := 0; i < outLen; i++ {
 		out := &outputs[i]
 
-		if !out.Address.IsForNet(chainCfg) {
-			return nil, errors.New("out address is not the corresponding network address")
-		}
-
-		// Create a new script which pays to the provided address.
-		pkScript, err := txscript.PayToAddrScript(out.Address)
+		pkScript, err := out.makePkScript(chainCfg)
 		if err != nil {
 			return nil, err
 		}
@@ -156,15 +377,40 @@ func makeTxOutputs(outputs []BtcOutput, relayFeePerKb btcutil.Amount, chainCfg *
 			PkScript: pkScript,
 		}
 
-		//if err = txrules.CheckOutput(txOut, relayFeePerKb); err != nil {
-		//	return nil, err
-		//}
+		if err = policy.CheckOutput(txOut); err != nil {
+			return nil, fmt.Errorf("output %d: %w", i, err)
+		}
 
 		txOuts = append(txOuts, txOut)
 	}
 	return txOuts, nil
 }
 
+func (out *BtcOutput) makePkScript(chainCfg *chaincfg.Params) ([]byte, error) {
+	n := 0
+	for _, set := range []bool{out.Address != nil, out.PkScript != nil, out.OpReturn != nil} {
+		if set {
+			n++
+		}
+	}
+	if n != 1 {
+		return nil, errors.New("output needs exactly one of address, pkScript or opReturn")
+	}
+
+	switch {
+	case out.PkScript != nil:
+		return out.PkScript, nil
+	case out.OpReturn != nil:
+		return NewOpReturnScript(out.OpReturn)
+	}
+
+	if !out.Address.IsForNet(chainCfg) {
+		return nil, errors.New("out address is not the corresponding network address")
+	}
+	// Create a new script which pays to the provided address.
+	return txscript.PayToAddrScript(out.Address)
+}
+
 func makeInputSource(unspents []BtcUnspent) txauthor.InputSource {
 	sz := len(unspents)
 	// Current inputs and their total value.  These are closed over by the
@@ -185,7 +431,7 @@ func makeInputSource(unspents []BtcUnspent) txauthor.InputSource {
 				Index: u.Vout,
 			}, nil, nil)
 
-			amount, _ := btcutil.NewAmount(u.Amount)
+			amount := btcutil.Amount(u.Amount)
 			s, _ := hex.DecodeString(u.ScriptPubKey)
 
 			currentTotal += amount
@@ -197,6 +443,34 @@ func makeInputSource(unspents []BtcUnspent) txauthor.InputSource {
 	}
 }
 
+// makeSelectedInputSource returns all unspents whatever the target, for the
+// unspents of a coin selection.
+func makeSelectedInputSource(unspents []BtcUnspent) txauthor.InputSource {
+	inputSource := makeInputSource(unspents)
+	return func(btcutil.Amount) (btcutil.Amount, []*wire.TxIn, []btcutil.Amount, [][]byte, error) {
+		return inputSource(btcutil.Amount(math.MaxInt64))
+	}
+}
+
+func excludeUnspents(unspents []BtcUnspent, exclude []BtcUnspent) []BtcUnspent {
+	excluded := make(map[wire.OutPoint]bool, len(exclude))
+	for _, u := range exclude {
+		hash, _ := chainhash.NewHashFromStr(u.TxID)
+		if hash != nil {
+			excluded[wire.OutPoint{Hash: *hash, Index: u.Vout}] = true
+		}
+	}
+	rest := make([]BtcUnspent, 0, len(unspents))
+	for _, u := range unspents {
+		hash, _ := chainhash.NewHashFromStr(u.TxID)
+		if hash != nil && excluded[wire.OutPoint{Hash: *hash, Index: u.Vout}] {
+			continue
+		}
+		rest = append(rest, u)
+	}
+	return rest
+}
+
 // validateMsgTx verifies transaction input scripts for tx.  All previous output
 // scripts from outputs redeemed by the transaction, in the same order they are
 // spent, must be passed in the prevScripts slice.
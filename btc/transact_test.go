@@ -0,0 +1,86 @@
+package btc
+
+import (
+	"bytes"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/lizc2003/hdwallet/wallet"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNewBtcTransaction_Keyring(t *testing.T) {
+	hdw, err := wallet.NewHDWallet(testMnemonic, "", wallet.BtcChainRegtest, wallet.ChainMainNet)
+	require.NoError(t, err)
+	keyring, err := hdw.NewBtcKeyring()
+	require.NoError(t, err)
+
+	var unspents []BtcUnspent
+	for _, segWitType := range []wallet.SegWitType{wallet.SegWitNone, wallet.SegWitScript, wallet.SegWitNative} {
+		wallets, err := keyring.AddWindow(segWitType, 0, wallet.ChangeTypeExternal, 0, 10)
+		require.NoError(t, err)
+		for _, w := range wallets {
+			_, us := newFundingTx(t, []*wallet.BtcWallet{w}, 10000)
+			unspents = append(unspents, us...)
+		}
+	}
+
+	chainParams := keyring.ChainParams()
+	dest, err := btcutil.NewAddressWitnessPubKeyHash(bytes.Repeat([]byte{1}, 20), chainParams)
+	require.NoError(t, err)
+	tx, err := NewBtcTransaction(unspents, []BtcOutput{{Address: dest, Amount: 290000}},
+		keyring.Wallets()[0].DeriveNativeAddress(), 1000, chainParams)
+	require.NoError(t, err)
+	require.Len(t, tx.Tx.TxIn, 30)
+	require.NoError(t, tx.SignWithSecretsSource(keyring))
+}
+
+func TestNewBtcTransaction_ScriptOutputs(t *testing.T) {
+	wallets := newTestWallets(t)
+	w := wallets[2]
+	chainParams := w.ChainParams()
+	unspents := newTestUnspents(t, w.DeriveNativeAddress(), 100000)
+	dest, err := btcutil.NewAddressWitnessPubKeyHash(bytes.Repeat([]byte{1}, 20), chainParams)
+	require.NoError(t, err)
+	witnessScript := []byte{txscript.OP_TRUE}
+	p2wsh, err := btcutil.NewAddressWitnessScriptHash(chainhash.HashB(witnessScript), chainParams)
+	require.NoError(t, err)
+	p2wshScript, err := txscript.PayToAddrScript(p2wsh)
+	require.NoError(t, err)
+
+	memo := []byte("invoice:2f1c9a7d")
+	outputs := []BtcOutput{{Address: dest, Amount: 10000}, NewOpReturnOutput(memo), NewScriptOutput(p2wshScript, 20000)}
+	tx, err := NewBtcTransaction(unspents, outputs, w.DeriveNativeAddress(), 1000, chainParams)
+	require.NoError(t, err)
+	require.NoError(t, tx.Sign(w))
+
+	decoded := tx.Decode()
+	types := make(map[string]string)
+	for _, vout := range decoded.Vout {
+		types[vout.ScriptPubKey.Type] = vout.ScriptPubKey.Asm
+	}
+	require.Equal(t, "OP_RETURN "+hexString(memo), types["nulldata"])
+	require.Contains(t, types, "witness_v0_scripthash")
+
+	// The OP_RETURN output is part of the size estimation.
+	fee1, _, err := EstimateFee(0, 1, 0, outputs[:1], 1000, -1, chainParams)
+	require.NoError(t, err)
+	fee2, _, err := EstimateFee(0, 1, 0, outputs[:2], 1000, -1, chainParams)
+	require.NoError(t, err)
+	require.Equal(t, int64(8+1+2+len(memo)), fee2-fee1)
+
+	_, err = NewBtcTransaction(unspents, []BtcOutput{NewOpReturnOutput(make([]byte, 81))},
+		w.DeriveNativeAddress(), 1000, chainParams)
+	require.ErrorIs(t, err, ErrNonStandardScript)
+	_, err = NewBtcTransactionWithOptions(unspents, []BtcOutput{NewOpReturnOutput(make([]byte, 81))},
+		w.DeriveNativeAddress(), 1000, chainParams, &BtcTxOptions{Policy: &Policy{MaxDataCarrierSize: 100}})
+	require.NoError(t, err)
+
+	_, err = NewBtcTransaction(unspents, []BtcOutput{{Address: dest, OpReturn: memo}},
+		w.DeriveNativeAddress(), 1000, chainParams)
+	require.Error(t, err)
+	_, err = NewBtcTransaction(unspents, []BtcOutput{NewOpReturnOutput(memo), NewOpReturnOutput(memo)},
+		w.DeriveNativeAddress(), 1000, chainParams)
+	require.ErrorIs(t, err, ErrNonStandardScript)
+}
@@ -0,0 +1,203 @@
+package btc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lizc2003/hdwallet/wallet"
+)
+
+// CoinbaseMaturity is the number of confirmations after which a coinbase
+// output can be spent. Like Bitcoin Core's wallet, one more than consensus
+// requires is waited for, so the spend stays valid if the tip is reorged.
+const CoinbaseMaturity = 100
+
+var ErrImmatureCoinbase = errors.New("immature coinbase output")
+
+type BtcUnspent struct {
+	TxID         string `json:"txid"`
+	Vout         uint32 `json:"vout"`
+	ScriptPubKey string `json:"scriptPubKey"`
+	RedeemScript string `json:"redeemScript,omitempty"`
+	// Amount is the value in satoshi. The JSON key is not "amount", which was
+	// the value in BTC, see UnmarshalJSON.
+	Amount int64 `json:"amountSat"`
+	// Address owning the output, if it has one.
+	Address string `json:"address,omitempty"`
+	// ScriptType is the txscript class of ScriptPubKey, e.g. "witness_v0_keyhash".
+	ScriptType string `json:"scriptType,omitempty"`
+	// Confirmations is 0 for an output in the mempool.
+	Confirmations int64 `json:"confirmations"`
+	Coinbase      bool  `json:"coinbase,omitempty"`
+	// KeyOrigin is the HD derivation of the key owning the output, if known.
+	KeyOrigin *wallet.KeyOrigin `json:"keyOrigin,omitempty"`
+	// Spend describes how the output is spent, for the estimation of the
+	// fee. If nil, it is derived from ScriptPubKey, see NewInputDescriptor.
+	Spend *InputDescriptor `json:"spend,omitempty"`
+}
+
+// UnmarshalJSON also reads the payloads written when the value was a float
+// BTC "amount", and converts it to satoshi.
+func (u *BtcUnspent) UnmarshalJSON(data []byte) error {
+	type plain BtcUnspent
+	aux := struct {
+		*plain
+		AmountSat *int64   `json:"amountSat"`
+		AmountBtc *float64 `json:"amount"`
+	}{plain: (*plain)(u)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	switch {
+	case aux.AmountSat != nil:
+		u.Amount = *aux.AmountSat
+	case aux.AmountBtc != nil:
+		amount, err := btcutil.NewAmount(*aux.AmountBtc)
+		if err != nil {
+			return fmt.Errorf("unspent amount: %w", err)
+		}
+		u.Amount = int64(amount)
+	}
+	return nil
+}
+
+// IsMature reports whether the output can be spent, which is false for a
+// coinbase output with CoinbaseMaturity confirmations or less.
+func (u *BtcUnspent) IsMature() bool {
+	return !u.Coinbase || u.Confirmations > CoinbaseMaturity
+}
+
+// InputDescriptor returns Spend, or the descriptor of the usual spend of
+// ScriptPubKey and RedeemScript.
+func (u *BtcUnspent) InputDescriptor() (InputDescriptor, error) {
+	if u.Spend != nil {
+		return *u.Spend, nil
+	}
+	pkScript, err := hex.DecodeString(u.ScriptPubKey)
+	if err != nil {
+		return InputDescriptor{}, err
+	}
+	redeemScript, err := hex.DecodeString(u.RedeemScript)
+	if err != nil {
+		return InputDescriptor{}, err
+	}
+	return NewInputDescriptor(pkScript, redeemScript)
+}
+
+// spendDescriptor is InputDescriptor, with the spends of unknown scripts
+// estimated as P2PKH as txauthor does.
+func (u *BtcUnspent) spendDescriptor() InputDescriptor {
+	d, err := u.InputDescriptor()
+	if err != nil {
+		return InputDescriptor{Type: InputP2PKH}
+	}
+	return d
+}
+
+// OutPoint returns the outpoint of the unspent.
+func (u *BtcUnspent) OutPoint() (wire.OutPoint, error) {
+	hash, err := HexToHash(u.TxID)
+	if err != nil {
+		return wire.OutPoint{}, err
+	}
+	return wire.OutPoint{Hash: *hash, Index: u.Vout}, nil
+}
+
+// NewBtcUnspentFromListUnspent converts a result of bitcoind's listunspent.
+// Bitcoind doesn't list immature coinbase outputs, so Coinbase is left false.
+func NewBtcUnspentFromListUnspent(r *btcjson.ListUnspentResult) (BtcUnspent, error) {
+	amount, err := btcutil.NewAmount(r.Amount)
+	if err != nil {
+		return BtcUnspent{}, err
+	}
+	u := BtcUnspent{TxID: r.TxID, Vout: r.Vout, ScriptPubKey: r.ScriptPubKey, RedeemScript: r.RedeemScript,
+		Amount: int64(amount), Address: r.Address, Confirmations: r.Confirmations}
+	if u.ScriptType, err = scriptTypeOf(u.ScriptPubKey); err != nil {
+		return BtcUnspent{}, err
+	}
+	return u, nil
+}
+
+// NewBtcUnspentsFromListUnspent converts the result of bitcoind's listunspent.
+func NewBtcUnspentsFromListUnspent(results []btcjson.ListUnspentResult) ([]BtcUnspent, error) {
+	unspents := make([]BtcUnspent, 0, len(results))
+	for i := range results {
+		u, err := NewBtcUnspentFromListUnspent(&results[i])
+		if err != nil {
+			return nil, fmt.Errorf("unspent %d: %w", i, err)
+		}
+		unspents = append(unspents, u)
+	}
+	return unspents, nil
+}
+
+// EsploraUtxo is an element of the response of GET /address/:address/utxo of
+// an Esplora server, e.g. blockstream.info or mempool.space.
+type EsploraUtxo struct {
+	TxID   string          `json:"txid"`
+	Vout   uint32          `json:"vout"`
+	Status EsploraTxStatus `json:"status"`
+	Value  int64           `json:"value"`
+}
+
+type EsploraTxStatus struct {
+	Confirmed   bool   `json:"confirmed"`
+	BlockHeight int64  `json:"block_height,omitempty"`
+	BlockHash   string `json:"block_hash,omitempty"`
+	BlockTime   int64  `json:"block_time,omitempty"`
+}
+
+// NewBtcUnspentsFromEsplora converts the utxo list of address returned by an
+// Esplora server. The response has no output script, it is derived from
+// address, and confirmations are computed from tipHeight.
+func NewBtcUnspentsFromEsplora(data []byte, address string, tipHeight int64, chainCfg *chaincfg.Params) ([]BtcUnspent, error) {
+	var utxos []EsploraUtxo
+	if err := json.Unmarshal(data, &utxos); err != nil {
+		return nil, err
+	}
+	addr, err := btcutil.DecodeAddress(address, chainCfg)
+	if err != nil {
+		return nil, err
+	}
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		return nil, err
+	}
+	scriptType := txscript.GetScriptClass(pkScript).String()
+
+	unspents := make([]BtcUnspent, 0, len(utxos))
+	for _, utxo := range utxos {
+		u := BtcUnspent{TxID: utxo.TxID, Vout: utxo.Vout, ScriptPubKey: hex.EncodeToString(pkScript),
+			Amount: utxo.Value, Address: address, ScriptType: scriptType}
+		if utxo.Status.Confirmed && tipHeight >= utxo.Status.BlockHeight {
+			u.Confirmations = tipHeight - utxo.Status.BlockHeight + 1
+		}
+		unspents = append(unspents, u)
+	}
+	return unspents, nil
+}
+
+func scriptTypeOf(scriptPubKey string) (string, error) {
+	script, err := hex.DecodeString(scriptPubKey)
+	if err != nil {
+		return "", err
+	}
+	return txscript.GetScriptClass(script).String(), nil
+}
+
+// matureUnspents returns the unspents which can be spent now.
+func matureUnspents(unspents []BtcUnspent) []BtcUnspent {
+	mature := make([]BtcUnspent, 0, len(unspents))
+	for _, u := range unspents {
+		if u.IsMature() {
+			mature = append(mature, u)
+		}
+	}
+	return mature
+}
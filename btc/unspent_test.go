@@ -0,0 +1,83 @@
+package btc
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestUnspent_Convert(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	addr, err := btcutil.NewAddressWitnessPubKeyHash(bytes.Repeat([]byte{1}, 20), params)
+	require.NoError(t, err)
+	script := newTestUnspents(t, addr, 1)[0].ScriptPubKey
+
+	u, err := NewBtcUnspentFromListUnspent(&btcjson.ListUnspentResult{TxID: "aa", Vout: 1,
+		Address: addr.String(), ScriptPubKey: script, Amount: 0.1, Confirmations: 3})
+	require.NoError(t, err)
+	require.Equal(t, BtcUnspent{TxID: "aa", Vout: 1, ScriptPubKey: script, Amount: 10000000,
+		Address: addr.String(), ScriptType: "witness_v0_keyhash", Confirmations: 3}, u)
+
+	data := []byte(`[{"txid":"bb","vout":0,"status":{"confirmed":true,"block_height":100,"block_hash":"cc","block_time":1},"value":5000},
+		{"txid":"dd","vout":2,"status":{"confirmed":false},"value":7000}]`)
+	unspents, err := NewBtcUnspentsFromEsplora(data, addr.String(), 109, params)
+	require.NoError(t, err)
+	require.Equal(t, []BtcUnspent{
+		{TxID: "bb", Vout: 0, ScriptPubKey: script, Amount: 5000, Address: addr.String(),
+			ScriptType: "witness_v0_keyhash", Confirmations: 10},
+		{TxID: "dd", Vout: 2, ScriptPubKey: script, Amount: 7000, Address: addr.String(),
+			ScriptType: "witness_v0_keyhash"},
+	}, unspents)
+
+	_, err = NewBtcUnspentsFromEsplora(data, "invalid", 109, params)
+	require.Error(t, err)
+}
+
+func TestUnspent_JSON(t *testing.T) {
+	u := BtcUnspent{TxID: "aa", Vout: 1, ScriptPubKey: "0014", Amount: 150000000, Confirmations: 3}
+	data, err := json.Marshal(u)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"amountSat":150000000`)
+	require.NotContains(t, string(data), `"amount":`)
+	var decoded BtcUnspent
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	require.Equal(t, u, decoded)
+
+	// The float BTC amount of older payloads is converted.
+	var legacy []BtcUnspent
+	require.NoError(t, json.Unmarshal([]byte(`[{"txid":"aa","vout":1,"scriptPubKey":"0014","amount":1.5,"confirmations":3},
+		{"txid":"aa","vout":1,"scriptPubKey":"0014","amount":1}]`), &legacy))
+	require.Equal(t, u, legacy[0])
+	require.Equal(t, int64(100000000), legacy[1].Amount)
+	require.Error(t, json.Unmarshal([]byte(`{"amount":"1"}`), &decoded))
+}
+
+func TestUnspent_ImmatureCoinbase(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	addr, err := btcutil.NewAddressWitnessPubKeyHash(bytes.Repeat([]byte{1}, 20), params)
+	require.NoError(t, err)
+	unspents := newTestUnspents(t, addr, 5000000000, 100000)
+	unspents[0].Coinbase = true
+	unspents[0].Confirmations = CoinbaseMaturity
+	require.False(t, unspents[0].IsMature())
+	require.True(t, unspents[1].IsMature())
+
+	outputs := []BtcOutput{{Address: addr, Amount: 200000}}
+	for _, selector := range []CoinSelector{nil, DefaultCoinSelector, LargestFirstSelector} {
+		_, err = NewBtcTransactionWithOptions(unspents, outputs, addr, 1000, params,
+			&BtcTxOptions{CoinSelector: selector})
+		require.Error(t, err)
+	}
+	_, err = NewSweepTransaction(unspents, addr, 1000, params, nil)
+	require.ErrorIs(t, err, ErrImmatureCoinbase)
+
+	unspents[0].Confirmations = CoinbaseMaturity + 1
+	tx, err := NewBtcTransactionWithOptions(unspents, outputs, addr, 1000, params,
+		&BtcTxOptions{CoinSelector: LargestFirstSelector})
+	require.NoError(t, err)
+	require.Len(t, tx.Tx.TxIn, 1)
+}
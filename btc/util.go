@@ -4,9 +4,7 @@ import (
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
-	"github.com/btcsuite/btcwallet/wallet/txauthor"
-	"github.com/btcsuite/btcwallet/wallet/txrules"
-	"github.com/btcsuite/btcwallet/wallet/txsizes"
+	"github.com/lizc2003/hdwallet/wallet"
 )
 
 func DecodeAddress(addr string, chainParams *chaincfg.Params) (btcutil.Address, error) {
@@ -17,35 +15,58 @@ func HexToHash(s string) (*chainhash.Hash, error) {
 	return chainhash.NewHashFromStr(s)
 }
 
+// Deprecated: float64 loses precision, use ParseBtc instead.
 func BtcToSatoshi(v float64) int64 {
+	return btcToSatoshi(v)
+}
+
+// btcToSatoshi converts a float BTC value as returned by bitcoind's JSON-RPC,
+// rounded to the nearest satoshi.
+func btcToSatoshi(v float64) int64 {
 	amt, _ := btcutil.NewAmount(v)
 	return int64(amt)
 }
 
+// Deprecated: float64 loses precision, use FormatSatoshi instead.
 func SatoshiToBtc(v int64) float64 {
 	a := btcutil.Amount(v)
 	return a.ToBTC()
 }
 
+// EstimateFee estimates the fee and returns it with the sum of the outputs.
+// See PreviewFee for inputs other than P2PKH, P2WPKH and P2SH-P2WPKH.
 func EstimateFee(numP2PKHIns, numP2WPKHIns, numNestedP2WPKHIns int,
 	outputs []BtcOutput, feePerKb int64, changeScriptSize int, chainCfg *chaincfg.Params) (int64, int64, error) {
 
-	feeRatePerKb := btcutil.Amount(feePerKb)
-	if changeScriptSize < 0 {
-		// using P2WPKH as change output.
-		changeScriptSize = txsizes.P2WPKHPkScriptSize
+	inputs := make([]InputDescriptor, 0, numP2PKHIns+numP2WPKHIns+numNestedP2WPKHIns)
+	for _, in := range []struct {
+		typ InputType
+		num int
+	}{{InputP2PKH, numP2PKHIns}, {InputP2WPKH, numP2WPKHIns}, {InputNestedP2WPKH, numNestedP2WPKHIns}} {
+		for i := 0; i < in.num; i++ {
+			inputs = append(inputs, InputDescriptor{Type: in.typ})
+		}
 	}
-
-	txOuts, err := makeTxOutputs(outputs, feeRatePerKb, chainCfg)
+	preview, err := previewFee(inputs, outputs, changeScriptSize, feePerKb, chainCfg)
 	if err != nil {
 		return 0, 0, err
 	}
+	return preview.Fee, preview.Amount, nil
+}
 
-	maxSignedSize := txsizes.EstimateVirtualSize(numP2PKHIns, 0, numP2WPKHIns,
-		numNestedP2WPKHIns, txOuts, changeScriptSize)
-
-	targetFee := txrules.FeeForSerializeSize(feeRatePerKb, maxSignedSize)
-	targetAmount := txauthor.SumOutputValues(txOuts)
+// ParseBtc converts a decimal BTC string, e.g. "0.0001", to satoshi exactly.
+func ParseBtc(s string) (int64, error) {
+	a, err := wallet.ParseUnits(s, wallet.DecimalsBtc)
+	if err != nil {
+		return 0, err
+	}
+	v, ok := a.Int64()
+	if !ok {
+		return 0, wallet.ErrInvalidAmount
+	}
+	return v, nil
+}
 
-	return int64(targetFee), int64(targetAmount), nil
+func FormatSatoshi(v int64) string {
+	return wallet.SatoshiAmount(v).String()
 }
@@ -0,0 +1,352 @@
+package btc
+
+import (
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightninglabs/gozmq"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultWatcherPollInterval     = 30 * time.Second
+	DefaultWatcherMaxConfirmations = 6
+)
+
+var ErrWatcherStopped = errors.New("watcher stopped")
+
+// DepositStatus is the kind of change reported by a DepositEvent.
+type DepositStatus int
+
+const (
+	// DepositSeen is a new deposit, in the mempool or already confirmed.
+	DepositSeen DepositStatus = iota
+	// DepositConfirmed is a deposit which got more confirmations.
+	DepositConfirmed
+	// DepositReorged is a deposit which lost confirmations, its block was
+	// reorged out and it is back in the mempool or in another block.
+	DepositReorged
+	// DepositRemoved is a deposit which is gone: spent, double spent or
+	// reorged out and dropped from the mempool.
+	DepositRemoved
+)
+
+func (s DepositStatus) String() string {
+	switch s {
+	case DepositSeen:
+		return "seen"
+	case DepositConfirmed:
+		return "confirmed"
+	case DepositReorged:
+		return "reorged"
+	case DepositRemoved:
+		return "removed"
+	default:
+		return fmt.Sprintf("DepositStatus(%d)", int(s))
+	}
+}
+
+type DepositEvent struct {
+	Status DepositStatus
+	// Deposit is the current state of the output, the last known one if removed.
+	Deposit BtcUnspent
+}
+
+type WatcherConfig struct {
+	Backend     UtxoBackend
+	ChainParams *chaincfg.Params
+	// PollInterval is the time between two polls of the backend,
+	// DefaultWatcherPollInterval if zero.
+	PollInterval time.Duration
+	// ZmqAddress is the ZMQ endpoint of bitcoind publishing rawtx and
+	// rawblock (-zmqpubrawtx and -zmqpubrawblock), e.g. tcp://127.0.0.1:28332.
+	// If set, every notification triggers a poll so deposits are reported at once.
+	ZmqAddress string
+	// MaxConfirmations is the number of confirmations after which a deposit
+	// is no longer updated, DefaultWatcherMaxConfirmations if zero. It is
+	// still reported if it loses confirmations or is removed.
+	MaxConfirmations int64
+}
+
+// Watcher reports deposits to a set of addresses. Each poll lists the
+// unspents of the watched addresses from the backend and compares them with
+// the previous state, so reorgs are reported as state changes too.
+type Watcher struct {
+	cfg WatcherConfig
+
+	mu        sync.Mutex
+	addresses map[string]bool
+	deposits  map[wire.OutPoint]BtcUnspent
+
+	pollMu  sync.Mutex
+	events  chan DepositEvent
+	errors  chan error
+	trigger chan struct{}
+	quit    chan struct{}
+	wg      sync.WaitGroup
+	started bool
+	zmq     *gozmq.Conn
+}
+
+func NewWatcher(cfg WatcherConfig) (*Watcher, error) {
+	if cfg.Backend == nil || cfg.ChainParams == nil {
+		return nil, errors.New("wrong params")
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = DefaultWatcherPollInterval
+	}
+	if cfg.MaxConfirmations <= 0 {
+		cfg.MaxConfirmations = DefaultWatcherMaxConfirmations
+	}
+	return &Watcher{cfg: cfg,
+		addresses: make(map[string]bool),
+		deposits:  make(map[wire.OutPoint]BtcUnspent),
+		events:    make(chan DepositEvent, 100),
+		errors:    make(chan error, 10),
+		trigger:   make(chan struct{}, 1),
+		quit:      make(chan struct{})}, nil
+}
+
+// Events returns the channel of deposit changes. Polls block until the
+// events are received.
+func (w *Watcher) Events() <-chan DepositEvent {
+	return w.events
+}
+
+// Errors returns the channel of poll errors. Errors are dropped if it is full.
+func (w *Watcher) Errors() <-chan error {
+	return w.errors
+}
+
+// WatchAddress adds an address to watch. The backend must be able to list
+// its unspents, e.g. it must be imported in bitcoind.
+func (w *Watcher) WatchAddress(address string) error {
+	if _, err := decodeAddresses([]string{address}, w.cfg.ChainParams); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.addresses[address] = true
+	w.mu.Unlock()
+	w.Trigger()
+	return nil
+}
+
+// WatchScript adds the address of a standard output script to watch.
+func (w *Watcher) WatchScript(pkScript []byte) error {
+	class, addrs, _, err := txscript.ExtractPkScriptAddrs(pkScript, w.cfg.ChainParams)
+	if err != nil {
+		return err
+	}
+	if len(addrs) != 1 || class == txscript.PubKeyTy || class == txscript.MultiSigTy {
+		return fmt.Errorf("%w: %v script has no address", ErrNonStandardScript, class)
+	}
+	return w.WatchAddress(addrs[0].EncodeAddress())
+}
+
+// Unwatch stops watching address. Its deposits are forgotten without event.
+func (w *Watcher) Unwatch(address string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.addresses, address)
+	for outPoint, u := range w.deposits {
+		if u.Address == address {
+			delete(w.deposits, outPoint)
+		}
+	}
+}
+
+// Deposits returns the current deposits.
+func (w *Watcher) Deposits() []BtcUnspent {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	deposits := make([]BtcUnspent, 0, len(w.deposits))
+	for _, u := range w.deposits {
+		deposits = append(deposits, u)
+	}
+	sortUnspents(deposits)
+	return deposits
+}
+
+// Start polls in the background until Stop, and subscribes to ZMQ if configured.
+func (w *Watcher) Start() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.started {
+		return errors.New("watcher already started")
+	}
+	if w.cfg.ZmqAddress != "" {
+		conn, err := gozmq.Subscribe(w.cfg.ZmqAddress, []string{"rawtx", "rawblock"}, w.cfg.PollInterval)
+		if err != nil {
+			return err
+		}
+		w.zmq = conn
+		w.wg.Add(1)
+		go w.zmqLoop()
+	}
+	w.started = true
+	w.wg.Add(1)
+	go w.pollLoop()
+	return nil
+}
+
+// Stop stops the background polling and closes Events and Errors.
+func (w *Watcher) Stop() {
+	w.mu.Lock()
+	select {
+	case <-w.quit:
+		w.mu.Unlock()
+		return
+	default:
+	}
+	close(w.quit)
+	if w.zmq != nil {
+		w.zmq.Close()
+	}
+	w.mu.Unlock()
+
+	w.wg.Wait()
+	w.pollMu.Lock()
+	close(w.events)
+	close(w.errors)
+	w.pollMu.Unlock()
+}
+
+// Trigger asks the background loop to poll now.
+func (w *Watcher) Trigger() {
+	select {
+	case w.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Poll lists the unspents of the watched addresses and sends the changes to
+// Events. It is called by the background loop, or directly without Start.
+func (w *Watcher) Poll() error {
+	w.pollMu.Lock()
+	defer w.pollMu.Unlock()
+	select {
+	case <-w.quit:
+		return ErrWatcherStopped
+	default:
+	}
+
+	w.mu.Lock()
+	addresses := make([]string, 0, len(w.addresses))
+	for address := range w.addresses {
+		addresses = append(addresses, address)
+	}
+	w.mu.Unlock()
+	if len(addresses) == 0 {
+		return nil
+	}
+	sort.Strings(addresses)
+
+	unspents, err := w.cfg.Backend.ListUnspentByAddress(addresses)
+	if err != nil {
+		return err
+	}
+	current := make(map[wire.OutPoint]BtcUnspent, len(unspents))
+	for _, u := range unspents {
+		outPoint, err := u.OutPoint()
+		if err != nil {
+			return err
+		}
+		current[outPoint] = u
+	}
+
+	w.mu.Lock()
+	var events []DepositEvent
+	for outPoint, u := range current {
+		if !w.addresses[u.Address] && u.Address != "" {
+			// unwatched during the poll
+			continue
+		}
+		prev, ok := w.deposits[outPoint]
+		switch {
+		case !ok:
+			events = append(events, DepositEvent{Status: DepositSeen, Deposit: u})
+		case u.Confirmations < prev.Confirmations:
+			events = append(events, DepositEvent{Status: DepositReorged, Deposit: u})
+		case u.Confirmations > prev.Confirmations && prev.Confirmations < w.cfg.MaxConfirmations:
+			events = append(events, DepositEvent{Status: DepositConfirmed, Deposit: u})
+		}
+		w.deposits[outPoint] = u
+	}
+	for outPoint, prev := range w.deposits {
+		if _, ok := current[outPoint]; !ok {
+			events = append(events, DepositEvent{Status: DepositRemoved, Deposit: prev})
+			delete(w.deposits, outPoint)
+		}
+	}
+	w.mu.Unlock()
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return lessUnspent(&events[i].Deposit, &events[j].Deposit)
+	})
+	for _, event := range events {
+		select {
+		case w.events <- event:
+		case <-w.quit:
+			return ErrWatcherStopped
+		}
+	}
+	return nil
+}
+
+func (w *Watcher) pollLoop() {
+	defer w.wg.Done()
+	ticker := time.NewTicker(w.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		if err := w.Poll(); err != nil && !errors.Is(err, ErrWatcherStopped) {
+			select {
+			case w.errors <- err:
+			default:
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-w.trigger:
+		case <-w.quit:
+			return
+		}
+	}
+}
+
+func (w *Watcher) zmqLoop() {
+	defer w.wg.Done()
+	for {
+		_, err := w.zmq.Receive(nil)
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			select {
+			case <-w.quit:
+				return
+			default:
+			}
+			// The connection timed out or is reconnecting, polls go on.
+			continue
+		}
+		w.Trigger()
+	}
+}
+
+func sortUnspents(unspents []BtcUnspent) {
+	sort.Slice(unspents, func(i, j int) bool {
+		return lessUnspent(&unspents[i], &unspents[j])
+	})
+}
+
+func lessUnspent(a, b *BtcUnspent) bool {
+	if a.TxID != b.TxID {
+		return a.TxID < b.TxID
+	}
+	return a.Vout < b.Vout
+}
@@ -0,0 +1,143 @@
+package btc
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryBackend is a UtxoBackend listing a mutable set of unspents.
+type memoryBackend struct {
+	mu       sync.Mutex
+	unspents []BtcUnspent
+}
+
+func (b *memoryBackend) set(unspents ...BtcUnspent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.unspents = unspents
+}
+
+func (b *memoryBackend) ListUnspentByAddress(addresses []string) ([]BtcUnspent, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var unspents []BtcUnspent
+	for _, u := range b.unspents {
+		for _, address := range addresses {
+			if u.Address == address {
+				unspents = append(unspents, u)
+			}
+		}
+	}
+	return unspents, nil
+}
+
+func (b *memoryBackend) GetTransaction(txid string) (*wire.MsgTx, error) {
+	return nil, fmt.Errorf("tx %s not found", txid)
+}
+
+func (b *memoryBackend) Broadcast(tx *wire.MsgTx) (string, error) {
+	return tx.TxHash().String(), nil
+}
+
+func (b *memoryBackend) EstimateFeeRate(confTarget int64) (int64, error) {
+	return DefaultMinRelayFeePerKb, nil
+}
+
+func (b *memoryBackend) TipHeight() (int64, error) {
+	return 100, nil
+}
+
+func TestWatcher_Poll(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	addr, err := btcutil.NewAddressWitnessPubKeyHash(bytes.Repeat([]byte{1}, 20), params)
+	require.NoError(t, err)
+	other, err := btcutil.NewAddressWitnessPubKeyHash(bytes.Repeat([]byte{2}, 20), params)
+	require.NoError(t, err)
+	deposit := func(i int, confirmations int64, a btcutil.Address) BtcUnspent {
+		u := newTestUnspents(t, a, 10000*int64(i+1))[0]
+		u.TxID = fmt.Sprintf("%064x", i+1)
+		u.Address = a.String()
+		u.Confirmations = confirmations
+		return u
+	}
+
+	backend := &memoryBackend{}
+	w, err := NewWatcher(WatcherConfig{Backend: backend, ChainParams: params, MaxConfirmations: 2})
+	require.NoError(t, err)
+	pkScript, err := txscript.PayToAddrScript(addr)
+	require.NoError(t, err)
+	require.NoError(t, w.WatchScript(pkScript))
+	require.Error(t, w.WatchAddress("invalid"))
+
+	poll := func(expected ...DepositEvent) {
+		require.NoError(t, w.Poll())
+		for _, e := range expected {
+			require.Equal(t, e, <-w.Events())
+		}
+		require.Len(t, w.Events(), 0)
+	}
+
+	backend.set(deposit(0, 0, addr), deposit(1, 3, addr), deposit(2, 0, other))
+	poll(DepositEvent{DepositSeen, deposit(0, 0, addr)}, DepositEvent{DepositSeen, deposit(1, 3, addr)})
+	poll()
+
+	backend.set(deposit(0, 1, addr), deposit(1, 4, addr))
+	poll(DepositEvent{DepositConfirmed, deposit(0, 1, addr)})
+	backend.set(deposit(0, 2, addr), deposit(1, 5, addr))
+	poll(DepositEvent{DepositConfirmed, deposit(0, 2, addr)})
+	// MaxConfirmations reached
+	backend.set(deposit(0, 3, addr), deposit(1, 6, addr))
+	poll()
+
+	// The block of deposit 0 is reorged, deposit 1 is spent.
+	backend.set(deposit(0, 0, addr))
+	poll(DepositEvent{DepositReorged, deposit(0, 0, addr)}, DepositEvent{DepositRemoved, deposit(1, 6, addr)})
+	require.Equal(t, []BtcUnspent{deposit(0, 0, addr)}, w.Deposits())
+
+	// Double spent
+	backend.set()
+	poll(DepositEvent{DepositRemoved, deposit(0, 0, addr)})
+
+	require.NoError(t, w.WatchAddress(other.String()))
+	backend.set(deposit(2, 1, other))
+	poll(DepositEvent{DepositSeen, deposit(2, 1, other)})
+	w.Unwatch(other.String())
+	require.Empty(t, w.Deposits())
+}
+
+func TestWatcher_Start(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	addr, err := btcutil.NewAddressWitnessPubKeyHash(bytes.Repeat([]byte{1}, 20), params)
+	require.NoError(t, err)
+	u := newTestUnspents(t, addr, 10000)[0]
+	u.Address = addr.String()
+
+	backend := &memoryBackend{}
+	w, err := NewWatcher(WatcherConfig{Backend: backend, ChainParams: params, PollInterval: time.Hour})
+	require.NoError(t, err)
+	require.NoError(t, w.Start())
+	require.Error(t, w.Start())
+
+	backend.set(u)
+	require.NoError(t, w.WatchAddress(addr.String()))
+	select {
+	case e := <-w.Events():
+		require.Equal(t, DepositEvent{DepositSeen, u}, e)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+
+	w.Stop()
+	w.Stop()
+	_, ok := <-w.Events()
+	require.False(t, ok)
+	require.ErrorIs(t, w.Poll(), ErrWatcherStopped)
+}
@@ -0,0 +1,156 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/lizc2003/hdwallet/wallet"
+	"math/big"
+)
+
+// EthUnsignedTx is the unsigned transaction of an ETH envelope.
+type EthUnsignedTx struct {
+	From common.Address `json:"from"`
+	// To is nil for a contract deployment.
+	To    *common.Address `json:"to"`
+	Nonce uint64          `json:"nonce"`
+	Gas   uint64          `json:"gas"`
+	// GasPrice is set for a legacy transaction, GasFeeCap and GasTipCap for
+	// an EIP-1559 one.
+	GasPrice  *big.Int      `json:"gasPrice,omitempty"`
+	GasFeeCap *big.Int      `json:"gasFeeCap,omitempty"`
+	GasTipCap *big.Int      `json:"gasTipCap,omitempty"`
+	Value     *big.Int      `json:"value"`
+	Data      hexutil.Bytes `json:"data,omitempty"`
+}
+
+// PrepareTx fills in the nonce, gas limit and gas price of a transaction from
+// opts.From, like TransferEther does, without signing it. The values set in
+// opts are kept.
+func PrepareTx(opts *bind.TransactOpts, backend bind.ContractBackend, to *common.Address, data []byte) (*EthUnsignedTx, error) {
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	u := &EthUnsignedTx{From: opts.From, To: to, Gas: opts.GasLimit, Value: opts.Value, Data: data}
+	if u.Value == nil {
+		u.Value = new(big.Int)
+	}
+
+	if opts.Nonce != nil {
+		u.Nonce = opts.Nonce.Uint64()
+	} else {
+		nonce, err := backend.PendingNonceAt(ctx, opts.From)
+		if err != nil {
+			return nil, err
+		}
+		u.Nonce = nonce
+	}
+
+	param := TransactBaseParam{GasPrice: opts.GasPrice,
+		GasFeeCap: opts.GasFeeCap,
+		GasTipCap: opts.GasTipCap,
+	}
+	if err := param.EnsureGasPrice(backend); err != nil {
+		return nil, err
+	}
+	u.GasPrice = param.GasPrice
+	u.GasFeeCap = param.GasFeeCap
+	u.GasTipCap = param.GasTipCap
+	if u.GasFeeCap != nil {
+		u.GasPrice = nil
+	}
+
+	if u.Gas == 0 {
+		if to != nil && len(data) == 0 {
+			u.Gas = wallet.EtherTransferGas
+		} else {
+			gas, err := backend.EstimateGas(ctx, ethereum.CallMsg{From: opts.From, To: to,
+				GasPrice: u.GasPrice, GasFeeCap: u.GasFeeCap, GasTipCap: u.GasTipCap,
+				Value: u.Value, Data: data})
+			if err != nil {
+				return nil, err
+			}
+			u.Gas = gas
+		}
+	}
+	return u, nil
+}
+
+// Transaction returns the unsigned transaction.
+func (u *EthUnsignedTx) Transaction() *types.Transaction {
+	if u.GasFeeCap == nil {
+		return types.NewTx(&types.LegacyTx{
+			Nonce:    u.Nonce,
+			To:       u.To,
+			GasPrice: u.GasPrice,
+			Gas:      u.Gas,
+			Value:    u.Value,
+			Data:     u.Data,
+		})
+	}
+	return types.NewTx(&types.DynamicFeeTx{
+		Nonce:     u.Nonce,
+		To:        u.To,
+		GasFeeCap: u.GasFeeCap,
+		GasTipCap: u.GasTipCap,
+		Gas:       u.Gas,
+		Value:     u.Value,
+		Data:      u.Data,
+	})
+}
+
+// NewEnvelope exports the unsigned transaction for chainId, to be signed
+// offline by SignEnvelope.
+func (u *EthUnsignedTx) NewEnvelope(chainId int) (*wallet.Envelope, error) {
+	if u.GasPrice == nil && u.GasFeeCap == nil {
+		return nil, errors.New("gas price not set")
+	}
+	if u.GasFeeCap != nil && u.GasTipCap == nil {
+		return nil, errors.New("gas tip cap not set")
+	}
+	return wallet.NewUnsignedEnvelope(wallet.SymbolEth, chainId, u)
+}
+
+// SignEnvelope signs the transaction of an envelope without network access
+// and returns the signed envelope. The wallet must be the sender and be on the
+// chain of the envelope.
+func SignEnvelope(env *wallet.Envelope, w *wallet.EthWallet) (*wallet.Envelope, error) {
+	var u EthUnsignedTx
+	if err := env.DecodeUnsigned(wallet.SymbolEth, &u); err != nil {
+		return nil, err
+	}
+	if env.ChainId != w.ChainId() {
+		return nil, fmt.Errorf("%w: chainId %d", wallet.ErrEnvelopeSymbol, env.ChainId)
+	}
+	if u.From != w.DeriveNativeAddress() {
+		return nil, fmt.Errorf("transaction is from %s, not from the wallet", u.From.Hex())
+	}
+	if u.Value == nil || (u.GasPrice == nil && (u.GasFeeCap == nil || u.GasTipCap == nil)) {
+		return nil, errors.New("incomplete transaction")
+	}
+
+	signedTx, err := SignTx(w, u.Transaction())
+	if err != nil {
+		return nil, err
+	}
+	signedHex, err := SerializeTransaction(signedTx)
+	if err != nil {
+		return nil, err
+	}
+	return env.NewSignedEnvelope(signedHex, signedTx.Hash().Hex()), nil
+}
+
+// BroadcastEnvelope sends the transaction of a signed envelope.
+func (this *EthClient) BroadcastEnvelope(ctx context.Context, env *wallet.Envelope) (string, error) {
+	signedHex, err := env.SignedTx(wallet.SymbolEth)
+	if err != nil {
+		return "", err
+	}
+	return this.SendRawTransaction(ctx, signedHex)
+}
@@ -1,7 +1,29 @@
 package eth
 
+import (
+	"github.com/lizc2003/hdwallet/wallet"
+	"math/big"
+)
+
 type Erc20Meta struct {
 	Symbol   string `toml:"symbol" json:"symbol"`
 	Address  string `toml:"address" json:"address"`
 	Decimals int    `toml:"decimals" json:"decimals"`
 }
+
+// ParseAmount converts a decimal token string, e.g. "12.5", to base units.
+func (this *Erc20Meta) ParseAmount(s string) (*big.Int, error) {
+	a, err := wallet.ParseUnits(s, this.Decimals)
+	if err != nil {
+		return nil, err
+	}
+	return a.BaseUnits(), nil
+}
+
+func (this *Erc20Meta) FormatAmount(v *big.Int) string {
+	return wallet.FormatUnits(v, this.Decimals)
+}
+
+func (this *Erc20Meta) ToAmount(v *big.Int) wallet.Amount {
+	return wallet.NewAmount(v, this.Decimals)
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/lizc2003/hdwallet/wallet"
 	"math/big"
 )
 
@@ -30,6 +31,32 @@ func GweiToWei(v int64) *big.Int {
 	return big.NewInt(0).Mul(big.NewInt(v), BigIntEthGWei)
 }
 
+// ParseEther converts a decimal ETH string, e.g. "0.01", to wei exactly.
+func ParseEther(s string) (*big.Int, error) {
+	a, err := wallet.ParseUnits(s, wallet.DecimalsEther)
+	if err != nil {
+		return nil, err
+	}
+	return a.BaseUnits(), nil
+}
+
+func FormatEther(wei *big.Int) string {
+	return wallet.FormatUnits(wei, wallet.DecimalsEther)
+}
+
+// ParseGwei converts a decimal gwei string, e.g. "1.5", to wei exactly.
+func ParseGwei(s string) (*big.Int, error) {
+	a, err := wallet.ParseUnits(s, wallet.DecimalsEther-wallet.DecimalsGwei)
+	if err != nil {
+		return nil, err
+	}
+	return a.BaseUnits(), nil
+}
+
+func FormatGwei(wei *big.Int) string {
+	return wallet.FormatUnits(wei, wallet.DecimalsEther-wallet.DecimalsGwei)
+}
+
 func CalcEthFee(gasPrice *big.Int, gas int64) int64 {
 	return WeiToGwei(big.NewInt(0).Mul(big.NewInt(gas), gasPrice))
 }
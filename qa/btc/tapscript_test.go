@@ -0,0 +1,133 @@
+package btc
+
+import (
+	"fmt"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/lizc2003/hdwallet/btc"
+	"github.com/lizc2003/hdwallet/wallet"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestTapscriptVault(t *testing.T) {
+	rq := require.New(t)
+
+	cli, killBitcoind, err := RunBitcoind(&RunOptions{NewTmpDir: true})
+	rq.Nil(err)
+	defer killBitcoind()
+
+	mnemonic, err := wallet.NewMnemonic(128)
+	rq.Nil(err)
+	hdw, err := wallet.NewHDWallet(mnemonic, "", wallet.BtcChainRegtest, wallet.ChainMainNet)
+	rq.Nil(err)
+	chainParams, _ := wallet.GetBtcChainParams(wallet.BtcChainRegtest)
+
+	w, err := hdw.NewNativeSegWitWallet(0, 0, 0)
+	rq.Nil(err)
+	hot, err := hdw.NewNativeSegWitWallet(1, 0, 0)
+	rq.Nil(err)
+	recovery, err := hdw.NewNativeSegWitWallet(2, 0, 0)
+	rq.Nil(err)
+	funder := w.(*wallet.BtcWallet)
+	hotKey := hot.(*wallet.BtcWallet)
+	recoveryKey := recovery.(*wallet.BtcWallet)
+
+	const delay = 5
+	hotLeaf, err := btc.TapscriptChecksig(hotKey.DeriveNativePublicKey())
+	rq.Nil(err)
+	recoveryLeaf, err := btc.TapscriptDelayedChecksig(recoveryKey.DeriveNativePublicKey(), btc.CsvBlocks(delay), false)
+	rq.Nil(err)
+	// The hot key spends by the key path of the first vault, by a script
+	// path of the second one which has no key path.
+	keyPathVault, err := btc.NewTaprootTree(hotKey.DeriveNativePublicKey(), recoveryLeaf)
+	rq.Nil(err)
+	scriptPathVault, err := btc.NewTaprootTree(btc.TaprootNUMSKey, hotLeaf, recoveryLeaf)
+	rq.Nil(err)
+	vaults := []*btc.TaprootTree{keyPathVault, scriptPathVault, keyPathVault, scriptPathVault}
+
+	addr := funder.DeriveNativeAddress()
+	rq.Nil(cli.RpcClient.ImportAddress(addr.EncodeAddress()))
+	_, err = cli.RpcClient.GenerateToAddress(101, addr, nil)
+	rq.Nil(err)
+	utxos, err := cli.RpcClient.ListUnspentMinMaxAddresses(1, 999, []btcutil.Address{addr})
+	rq.Nil(err)
+	rq.Equal(1, len(utxos))
+	utxo := utxos[0]
+
+	// fund the vaults, one output per spend
+	var outputs []btc.BtcOutput
+	for i, vault := range vaults {
+		vaultAddr, err := vault.Address(chainParams)
+		rq.Nil(err)
+		outputs = append(outputs, btc.BtcOutput{Address: vaultAddr, Amount: int64(100000000 + i)})
+	}
+	unspent, err := btc.NewBtcUnspentFromListUnspent(&utxo)
+	rq.Nil(err)
+	fundTx, err := btc.NewBtcTransaction([]btc.BtcUnspent{unspent}, outputs, addr, 2000, chainParams)
+	rq.Nil(err)
+	rq.Nil(fundTx.Sign(funder))
+	_, err = fundTx.Send(cli.RpcClient, false)
+	rq.Nil(err)
+	_, err = cli.RpcClient.GenerateToAddress(1, addr, nil)
+	rq.Nil(err)
+
+	spend := func(index int, sign func(tx *btc.BtcTransaction) error) (*btc.BtcTransaction, error) {
+		vout := -1
+		for i, txOut := range fundTx.Tx.TxOut {
+			if txOut.Value == outputs[index].Amount {
+				vout = i
+			}
+		}
+		unspent := btc.BtcUnspent{TxID: fundTx.GetTxid(), Vout: uint32(vout),
+			ScriptPubKey: fmt.Sprintf("%x", vaults[index].PkScript()), Amount: outputs[index].Amount}
+		tx, err := btc.NewBtcTransaction([]btc.BtcUnspent{unspent},
+			[]btc.BtcOutput{{Address: addr, Amount: 50000000}}, addr, 2000, chainParams)
+		if err != nil {
+			return nil, err
+		}
+		if err = sign(tx); err != nil {
+			return nil, err
+		}
+		if err = tx.Validate(); err != nil {
+			return nil, err
+		}
+		_, err = tx.Send(cli.RpcClient, false)
+		return tx, err
+	}
+
+	// the hot key spends at once
+	tx, err := spend(0, func(tx *btc.BtcTransaction) error {
+		return tx.SignTaprootKeyPath(0, keyPathVault, hotKey)
+	})
+	rq.Nil(err)
+	rq.Equal(1, len(tx.Tx.TxIn[0].Witness))
+	tx, err = spend(1, func(tx *btc.BtcTransaction) error {
+		return tx.SignTapscriptInput(0, scriptPathVault, hotKey)
+	})
+	rq.Nil(err)
+	rq.Equal(hotLeaf.Script, []byte(tx.Tx.TxIn[0].Witness[1]))
+
+	// the recovery key has to wait
+	recoverySpend := func(index int) (*btc.BtcTransaction, error) {
+		return spend(index, func(tx *btc.BtcTransaction) error {
+			return tx.SignTapscriptInput(0, vaults[index], recoveryKey)
+		})
+	}
+	_, err = recoverySpend(2)
+	rq.NotNil(err)
+	fmt.Println("tapscript csv spend too early:", err)
+
+	_, err = cli.RpcClient.GenerateToAddress(delay, addr, nil)
+	rq.Nil(err)
+	tx, err = recoverySpend(2)
+	rq.Nil(err)
+	rq.Equal(btc.CsvBlocks(delay), tx.Tx.TxIn[0].Sequence)
+	_, err = txscript.ParseControlBlock(tx.Tx.TxIn[0].Witness[2])
+	rq.Nil(err)
+	_, err = recoverySpend(3)
+	rq.Nil(err)
+
+	_, err = cli.RpcClient.GenerateToAddress(1, addr, nil)
+	rq.Nil(err)
+}
@@ -0,0 +1,118 @@
+package btc
+
+import (
+	"fmt"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/lizc2003/hdwallet/btc"
+	"github.com/lizc2003/hdwallet/wallet"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestTimelockVault(t *testing.T) {
+	rq := require.New(t)
+
+	cli, killBitcoind, err := RunBitcoind(&RunOptions{NewTmpDir: true})
+	rq.Nil(err)
+	defer killBitcoind()
+
+	mnemonic, err := wallet.NewMnemonic(128)
+	rq.Nil(err)
+	hdw, err := wallet.NewHDWallet(mnemonic, "", wallet.BtcChainRegtest, wallet.ChainMainNet)
+	rq.Nil(err)
+	chainParams, _ := wallet.GetBtcChainParams(wallet.BtcChainRegtest)
+
+	w, err := hdw.NewNativeSegWitWallet(0, 0, 0)
+	rq.Nil(err)
+	hot, err := hdw.NewNativeSegWitWallet(1, 0, 0)
+	rq.Nil(err)
+	recovery, err := hdw.NewNativeSegWitWallet(2, 0, 0)
+	rq.Nil(err)
+	funder := w.(*wallet.BtcWallet)
+	hotKey := hot.(*wallet.BtcWallet)
+	recoveryKey := recovery.(*wallet.BtcWallet)
+
+	const delay = 5
+	height, err := cli.RpcClient.GetBlockCount()
+	rq.Nil(err)
+	vaults := []*btc.VaultScript{
+		{HotKey: hotKey.DeriveNativePublicKey(), RecoveryKey: recoveryKey.DeriveNativePublicKey(),
+			Delay: btc.CsvBlocks(delay)},
+		{HotKey: hotKey.DeriveNativePublicKey(), RecoveryKey: recoveryKey.DeriveNativePublicKey(),
+			Delay: uint32(height + 101 + 1 + delay), Absolute: true},
+		{HotKey: hotKey.DeriveNativePublicKey(), RecoveryKey: recoveryKey.DeriveNativePublicKey(),
+			Delay: btc.CsvBlocks(delay)},
+	}
+
+	addr := funder.DeriveNativeAddress()
+	rq.Nil(cli.RpcClient.ImportAddress(addr.EncodeAddress()))
+	_, err = cli.RpcClient.GenerateToAddress(101, addr, nil)
+	rq.Nil(err)
+	utxos, err := cli.RpcClient.ListUnspentMinMaxAddresses(1, 999, []btcutil.Address{addr})
+	rq.Nil(err)
+	rq.Equal(1, len(utxos))
+	utxo := utxos[0]
+
+	// fund the vaults
+	var outputs []btc.BtcOutput
+	for _, vault := range vaults {
+		vaultAddr, err := vault.Address(chainParams)
+		rq.Nil(err)
+		outputs = append(outputs, btc.BtcOutput{Address: vaultAddr, Amount: 100000000})
+	}
+	unspent, err := btc.NewBtcUnspentFromListUnspent(&utxo)
+	rq.Nil(err)
+	fundTx, err := btc.NewBtcTransaction([]btc.BtcUnspent{unspent}, outputs, addr, 2000, chainParams)
+	rq.Nil(err)
+	rq.Nil(fundTx.Sign(funder))
+	_, err = fundTx.Send(cli.RpcClient, false)
+	rq.Nil(err)
+	_, err = cli.RpcClient.GenerateToAddress(1, addr, nil)
+	rq.Nil(err)
+
+	spend := func(index int, w *wallet.BtcWallet) (*btc.BtcTransaction, error) {
+		vaultAddr, _ := vaults[index].Address(chainParams)
+		pkScript, _ := txscript.PayToAddrScript(vaultAddr)
+		vout := -1
+		for i, txOut := range fundTx.Tx.TxOut {
+			if string(txOut.PkScript) == string(pkScript) {
+				vout = i
+			}
+		}
+		unspent := btc.BtcUnspent{TxID: fundTx.GetTxid(), Vout: uint32(vout),
+			ScriptPubKey: fmt.Sprintf("%x", pkScript), Amount: 100000000}
+		tx, err := btc.NewBtcTransaction([]btc.BtcUnspent{unspent},
+			[]btc.BtcOutput{{Address: addr, Amount: 50000000}}, addr, 2000, chainParams)
+		if err != nil {
+			return nil, err
+		}
+		if err = tx.SignVaultInput(0, vaults[index], w); err != nil {
+			return nil, err
+		}
+		_, err = tx.Send(cli.RpcClient, false)
+		return tx, err
+	}
+
+	// the hot key spends at once
+	_, err = spend(2, hotKey)
+	rq.Nil(err)
+
+	// the recovery key has to wait
+	_, err = spend(0, recoveryKey)
+	rq.NotNil(err)
+	fmt.Println("csv spend too early:", err)
+	_, err = spend(1, recoveryKey)
+	rq.NotNil(err)
+	fmt.Println("cltv spend too early:", err)
+
+	_, err = cli.RpcClient.GenerateToAddress(delay, addr, nil)
+	rq.Nil(err)
+	_, err = spend(0, recoveryKey)
+	rq.Nil(err)
+	_, err = spend(1, recoveryKey)
+	rq.Nil(err)
+
+	_, err = cli.RpcClient.GenerateToAddress(1, addr, nil)
+	rq.Nil(err)
+}
@@ -78,9 +78,8 @@ func TestTransaction(t *testing.T) {
 		//rq.Nil(err)
 		feePerKb := int64(80 * 1000)
 
-		unspent := btc.BtcUnspent{TxID: utxo.TxID, Vout: utxo.Vout,
-			ScriptPubKey: utxo.ScriptPubKey, RedeemScript: utxo.RedeemScript,
-			Amount: utxo.Amount}
+		unspent, err := btc.NewBtcUnspentFromListUnspent(&utxo)
+		rq.Nil(err)
 
 		out1 := btc.BtcOutput{Address: addrA1, Amount: btc.BtcToSatoshi(transferAmount)}
 		out2 := btc.BtcOutput{Address: addrA2, Amount: btc.BtcToSatoshi(transferAmount)}
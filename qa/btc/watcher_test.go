@@ -0,0 +1,99 @@
+package btc
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/lizc2003/hdwallet/btc"
+	"github.com/lizc2003/hdwallet/wallet"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestWatcher(t *testing.T) {
+	rq := require.New(t)
+
+	const zmqAddress = "tcp://127.0.0.1:28332"
+	cli, killBitcoind, err := RunBitcoind(&RunOptions{NewTmpDir: true,
+		Args: []string{"-zmqpubrawtx=" + zmqAddress, "-zmqpubrawblock=" + zmqAddress}})
+	rq.Nil(err)
+	defer killBitcoind()
+
+	mnemonic, err := wallet.NewMnemonic(128)
+	rq.Nil(err)
+	hdw, err := wallet.NewHDWallet(mnemonic, "", wallet.BtcChainRegtest, wallet.ChainMainNet)
+	rq.Nil(err)
+	chainParams, _ := wallet.GetBtcChainParams(wallet.BtcChainRegtest)
+	w0, err := hdw.NewNativeSegWitWallet(0, 0, 0)
+	rq.Nil(err)
+	w1, err := hdw.NewNativeSegWitWallet(0, 0, 1)
+	rq.Nil(err)
+	funder := w0.(*wallet.BtcWallet)
+	funderAddr := funder.DeriveNativeAddress()
+	depositAddr := w1.DeriveAddress()
+	rq.Nil(cli.RpcClient.ImportAddress(funderAddr.EncodeAddress()))
+	rq.Nil(cli.RpcClient.ImportAddress(depositAddr))
+	_, err = cli.RpcClient.GenerateToAddress(101, funderAddr, nil)
+	rq.Nil(err)
+
+	watcher, err := btc.NewWatcher(btc.WatcherConfig{Backend: cli, ChainParams: chainParams,
+		PollInterval: time.Minute, ZmqAddress: zmqAddress})
+	rq.Nil(err)
+	rq.Nil(watcher.WatchAddress(depositAddr))
+	rq.Nil(watcher.Start())
+	defer watcher.Stop()
+
+	next := func() btc.DepositEvent {
+		select {
+		case e := <-watcher.Events():
+			fmt.Println("deposit", e.Status, e.Deposit.TxID, e.Deposit.Confirmations)
+			return e
+		case err := <-watcher.Errors():
+			t.Fatal(err)
+		case <-time.After(10 * time.Second):
+			t.Fatal("no deposit event")
+		}
+		return btc.DepositEvent{}
+	}
+
+	utxos, err := cli.RpcClient.ListUnspentMinMaxAddresses(1, 999, []btcutil.Address{funderAddr})
+	rq.Nil(err)
+	unspents, err := btc.NewBtcUnspentsFromListUnspent(utxos)
+	rq.Nil(err)
+	depositAddress, _ := btc.DecodeAddress(depositAddr, chainParams)
+	tx, err := btc.NewBtcTransaction(unspents, []btc.BtcOutput{{Address: depositAddress, Amount: 100000000}},
+		funderAddr, 2000, chainParams)
+	rq.Nil(err)
+	rq.Nil(tx.Sign(funder))
+	_, err = tx.Send(cli.RpcClient, false)
+	rq.Nil(err)
+
+	// rawtx wakes the watcher up
+	e := next()
+	rq.Equal(btc.DepositSeen, e.Status)
+	rq.Equal(tx.GetTxid(), e.Deposit.TxID)
+	rq.Equal(int64(100000000), e.Deposit.Amount)
+	rq.Equal(int64(0), e.Deposit.Confirmations)
+
+	blocks, err := cli.RpcClient.GenerateToAddress(1, funderAddr, nil)
+	rq.Nil(err)
+	e = next()
+	rq.Equal(btc.DepositConfirmed, e.Status)
+	rq.Equal(int64(1), e.Deposit.Confirmations)
+
+	// reorg the deposit back to the mempool
+	rq.Nil(cli.RpcClient.InvalidateBlock(blocks[0]))
+	watcher.Trigger()
+	e = next()
+	rq.Equal(btc.DepositReorged, e.Status)
+	rq.Equal(int64(0), e.Deposit.Confirmations)
+
+	hash, _ := json.Marshal(blocks[0].String())
+	_, err = cli.RpcClient.RawRequest("reconsiderblock", []json.RawMessage{hash})
+	rq.Nil(err)
+	watcher.Trigger()
+	e = next()
+	rq.Equal(btc.DepositConfirmed, e.Status)
+	rq.Equal(int64(1), e.Deposit.Confirmations)
+}
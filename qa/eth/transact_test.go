@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/lizc2003/hdwallet/eth"
@@ -127,6 +128,35 @@ func TestTransaction(t *testing.T) {
 		}
 	}
 
+	{ // air-gapped transfer, a1 pays back 1 ether to a0
+		fmt.Println("Air-gapped transfer --------")
+		opts := &bind.TransactOpts{From: addrA1, Value: big.NewInt(wallet.WeiPerEther)}
+		unsigned, err := eth.PrepareTx(opts, cli.RpcClient, &addrA0, nil)
+		rq.Nil(err)
+		env, err := unsigned.NewEnvelope(ethChainId)
+		rq.Nil(err)
+		chunks, err := env.Chunks(0)
+		rq.Nil(err)
+		fmt.Println("envelope chunks:", len(chunks))
+
+		// offline host
+		env, err = wallet.JoinEnvelopeChunks(chunks)
+		rq.Nil(err)
+		signedEnv, err := eth.SignEnvelope(env, w1.(*wallet.EthWallet))
+		rq.Nil(err)
+
+		txid, err := cli.BroadcastEnvelope(context.Background(), signedEnv)
+		rq.Nil(err)
+		rq.Equal(signedEnv.TxID, txid)
+		receipt, err := cli.RpcClient.TransactionReceipt(context.Background(), common.HexToHash(txid))
+		rq.Nil(err)
+		rq.Equal(types.ReceiptStatusSuccessful, receipt.Status)
+
+		bal, err := cli.RpcClient.BalanceAt(context.Background(), addrA1, nil)
+		rq.Nil(err)
+		rq.True(bal.Cmp(big.NewInt(5*wallet.WeiPerEther)) < 0, "Wrong balance")
+	}
+
 	totalSupply := big.NewInt(1000 * 1000000)
 	var contractAddr common.Address
 
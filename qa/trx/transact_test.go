@@ -52,7 +52,7 @@ func TestTransaction(t *testing.T) {
 
 	acct, err := client.RpcClient.GetAccount(w.DeriveAddress())
 	require.NoError(t, err)
-	fmt.Println("Trx balance", acct.Balance, trx.SunToTrx(acct.Balance))
+	fmt.Println("Trx balance", acct.Balance, trx.FormatSun(acct.Balance))
 	fmt.Println("Energy usage", acct.AccountResource.EnergyUsage)
 
 	{ // Trx transfer
@@ -64,13 +64,34 @@ func TestTransaction(t *testing.T) {
 
 		acct2, err := client.RpcClient.GetAccount(w.DeriveAddress())
 		require.NoError(t, err)
-		fmt.Println("Trx balance after transfer:", acct2.Balance, trx.SunToTrx(acct2.Balance))
+		fmt.Println("Trx balance after transfer:", acct2.Balance, trx.FormatSun(acct2.Balance))
 		require.Greater(t, acct.Balance, acct2.Balance)
 	}
 
+	{ // air-gapped trx transfer
+		fmt.Println("------------- air-gapped transfer trx")
+		txExt, err := client.RpcClient.Transfer(w.DeriveAddress(), "TUU9bqm9CCA1dAU9iaa6HcJF4twMBi5N86", 1000)
+		require.NoError(t, err)
+		env, err := trx.NewEnvelope(txExt, time.Hour)
+		require.NoError(t, err)
+		chunks, err := env.Chunks(0)
+		require.NoError(t, err)
+
+		// offline host
+		env, err = wallet.JoinEnvelopeChunks(chunks)
+		require.NoError(t, err)
+		signedEnv, err := trx.SignEnvelope(env, w)
+		require.NoError(t, err)
+
+		txId, err := trx.BroadcastEnvelope(client.RpcClient, signedEnv)
+		require.NoError(t, err)
+		require.Equal(t, "0x"+signedEnv.TxID, txId)
+		time.Sleep(waitTime)
+	}
+
 	if acct.AccountResource.EnergyUsage < 100 {
 		fmt.Println("------------- freeze balance for energy")
-		_, err := trx.FreezeEnergyBalance(w, client.RpcClient, "", trx.TrxToSun(500))
+		_, err := trx.FreezeEnergyBalance(w, client.RpcClient, "", 500*wallet.SunPerTrx)
 		require.NoError(t, err)
 		time.Sleep(waitTime)
 	}
@@ -0,0 +1,165 @@
+package trx
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/crypto"
+	protov1 "github.com/golang/protobuf/proto"
+	"github.com/lizc2003/gotron-sdk/pkg/client"
+	"github.com/lizc2003/gotron-sdk/pkg/proto/api"
+	"github.com/lizc2003/gotron-sdk/pkg/proto/core"
+	"github.com/lizc2003/hdwallet/wallet"
+	"google.golang.org/protobuf/proto"
+	"time"
+)
+
+// MaxEnvelopeExpiration is the longest validity of a transaction accepted by
+// the TRON network, counted from its creation.
+const MaxEnvelopeExpiration = 24 * time.Hour
+
+// TrxUnsignedTx is the unsigned transaction of a TRX envelope.
+type TrxUnsignedTx struct {
+	TxID string `json:"txid"`
+	// RawData is the protobuf encoded raw data of the transaction, the one
+	// which is hashed and signed. The other fields are decoded from it for
+	// review, SignEnvelope checks they match.
+	RawData       string `json:"rawData"`
+	ContractType  string `json:"contractType"`
+	Owner         string `json:"owner"`
+	RefBlockBytes string `json:"refBlockBytes"`
+	RefBlockHash  string `json:"refBlockHash"`
+	// Timestamp and Expiration are in milliseconds.
+	Timestamp  int64 `json:"timestamp"`
+	Expiration int64 `json:"expiration"`
+	FeeLimit   int64 `json:"feeLimit,omitempty"`
+}
+
+// NewEnvelope exports a transaction created by the node, e.g. by
+// client.Transfer, to be signed offline by SignEnvelope. The node sets the
+// reference block and an expiration of one minute, which is too short for
+// the round trip to an offline host, so it is extended to expiration after
+// the creation of the transaction if not zero.
+func NewEnvelope(txExt *api.TransactionExtention, expiration time.Duration) (*wallet.Envelope, error) {
+	if txExt == nil || txExt.Transaction == nil || txExt.Transaction.RawData == nil {
+		return nil, errors.New("wrong params")
+	}
+	if len(txExt.Transaction.Signature) > 0 {
+		return nil, wallet.ErrEnvelopeSigned
+	}
+	if expiration > MaxEnvelopeExpiration {
+		return nil, fmt.Errorf("expiration over %v", MaxEnvelopeExpiration)
+	}
+	raw := proto.Clone(txExt.Transaction.RawData).(*core.TransactionRaw)
+	if expiration > 0 {
+		created := raw.Timestamp
+		if created == 0 {
+			created = time.Now().UnixMilli()
+		}
+		raw.Expiration = created + expiration.Milliseconds()
+	}
+	u, err := newTrxUnsignedTx(raw)
+	if err != nil {
+		return nil, err
+	}
+	return wallet.NewUnsignedEnvelope(wallet.SymbolTrx, 0, u)
+}
+
+// SignEnvelope signs the transaction of an envelope without network access
+// and returns the signed envelope. The wallet must own the contract.
+func SignEnvelope(env *wallet.Envelope, w *wallet.TrxWallet) (*wallet.Envelope, error) {
+	var u TrxUnsignedTx
+	if err := env.DecodeUnsigned(wallet.SymbolTrx, &u); err != nil {
+		return nil, err
+	}
+	rawData, err := hex.DecodeString(u.RawData)
+	if err != nil {
+		return nil, err
+	}
+	raw := &core.TransactionRaw{}
+	if err = proto.Unmarshal(rawData, raw); err != nil {
+		return nil, err
+	}
+	decoded, err := newTrxUnsignedTx(raw)
+	if err != nil {
+		return nil, err
+	}
+	if *decoded != u {
+		return nil, errors.New("envelope fields do not match the raw data")
+	}
+	if u.Owner != w.DeriveAddress() {
+		return nil, fmt.Errorf("transaction is owned by %s, not by the wallet", u.Owner)
+	}
+
+	txHash := sha256.Sum256(rawData)
+	signature, err := crypto.Sign(txHash[:], w.DeriveNativePrivateKey())
+	if err != nil {
+		return nil, err
+	}
+	signed, err := proto.Marshal(&core.Transaction{RawData: raw, Signature: [][]byte{signature}})
+	if err != nil {
+		return nil, err
+	}
+	return env.NewSignedEnvelope(hex.EncodeToString(signed), u.TxID), nil
+}
+
+// BroadcastEnvelope sends the transaction of a signed envelope.
+func BroadcastEnvelope(client *client.GrpcClient, env *wallet.Envelope) (string, error) {
+	signedHex, err := env.SignedTx(wallet.SymbolTrx)
+	if err != nil {
+		return "", err
+	}
+	signed, err := hex.DecodeString(signedHex)
+	if err != nil {
+		return "", err
+	}
+	tx := &core.Transaction{}
+	if err = proto.Unmarshal(signed, tx); err != nil {
+		return "", err
+	}
+	trxTx := &TrxTransaction{tx: tx}
+	if env.TxID != "" {
+		h, err := trxTx.TxHash()
+		if err != nil {
+			return "", err
+		}
+		if hex.EncodeToString(h) != env.TxID {
+			return "", errors.New("envelope txid does not match its transaction")
+		}
+	}
+	return trxTx.Send(client)
+}
+
+func newTrxUnsignedTx(raw *core.TransactionRaw) (*TrxUnsignedTx, error) {
+	if len(raw.Contract) != 1 {
+		return nil, fmt.Errorf("transaction has %d contracts", len(raw.Contract))
+	}
+	rawData, err := proto.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	txHash := sha256.Sum256(rawData)
+
+	contract := raw.Contract[0]
+	param, err := contract.GetParameter().UnmarshalNew()
+	if err != nil {
+		return nil, err
+	}
+	// The contracts of gotron-sdk are protobuf v1 messages.
+	owned, ok := protov1.MessageV1(param).(interface{ GetOwnerAddress() []byte })
+	if !ok {
+		return nil, fmt.Errorf("contract %s has no owner", contract.Type)
+	}
+	return &TrxUnsignedTx{
+		TxID:          hex.EncodeToString(txHash[:]),
+		RawData:       hex.EncodeToString(rawData),
+		ContractType:  contract.Type.String(),
+		Owner:         EncodeAddress(owned.GetOwnerAddress()),
+		RefBlockBytes: hex.EncodeToString(raw.RefBlockBytes),
+		RefBlockHash:  hex.EncodeToString(raw.RefBlockHash),
+		Timestamp:     raw.Timestamp,
+		Expiration:    raw.Expiration,
+		FeeLimit:      raw.FeeLimit,
+	}, nil
+}
@@ -31,6 +31,27 @@ func (this *Trc20Contract) Decimals() (int, error) {
 	return int(n.Int64()), nil
 }
 
+// ParseAmount converts a decimal token string to base units using the token decimals.
+func (this *Trc20Contract) ParseAmount(s string) (*big.Int, error) {
+	decimals, err := this.Decimals()
+	if err != nil {
+		return nil, err
+	}
+	a, err := wallet.ParseUnits(s, decimals)
+	if err != nil {
+		return nil, err
+	}
+	return a.BaseUnits(), nil
+}
+
+func (this *Trc20Contract) FormatAmount(v *big.Int) (string, error) {
+	decimals, err := this.Decimals()
+	if err != nil {
+		return "", err
+	}
+	return wallet.FormatUnits(v, decimals), nil
+}
+
 func (this *Trc20Contract) BalanceOf(tokenOwner string) (*big.Int, error) {
 	return this.client.TRC20ContractBalance(tokenOwner, this.contractAddress)
 }
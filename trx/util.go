@@ -15,10 +15,29 @@ func EncodeAddress(a []byte) string {
 	return address.Address(a).String()
 }
 
+// Deprecated: float64 loses precision, use ParseTrx instead.
 func TrxToSun(v float64) int64 {
 	return int64(math.Round(v * wallet.SunPerTrx))
 }
 
+// Deprecated: float64 loses precision, use FormatSun instead.
 func SunToTrx(v int64) float64 {
 	return float64(v) / wallet.SunPerTrx
 }
+
+// ParseTrx converts a decimal TRX string, e.g. "1.5", to sun exactly.
+func ParseTrx(s string) (int64, error) {
+	a, err := wallet.ParseUnits(s, wallet.DecimalsTrx)
+	if err != nil {
+		return 0, err
+	}
+	v, ok := a.Int64()
+	if !ok {
+		return 0, wallet.ErrInvalidAmount
+	}
+	return v, nil
+}
+
+func FormatSun(v int64) string {
+	return wallet.SunAmount(v).String()
+}
@@ -0,0 +1,322 @@
+package wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+type RoundingMode int
+
+const (
+	RoundDown     RoundingMode = 0 // towards zero
+	RoundUp       RoundingMode = 1 // away from zero
+	RoundHalfUp   RoundingMode = 2
+	RoundHalfEven RoundingMode = 3
+
+	DecimalsBtc   = 8
+	DecimalsEther = 18
+	DecimalsGwei  = 9
+	DecimalsTrx   = 6
+)
+
+var (
+	ErrInvalidAmount   = errors.New("invalid amount")
+	ErrAmountPrecision = errors.New("amount has more decimal places than allowed")
+)
+
+// Amount is an exact fixed-point number: value / 10^decimals.
+// The zero value is a valid amount of 0 whose decimals are not set yet, see
+// UnmarshalText.
+//
+// Amount is not used in the signatures of the btc, eth and trx APIs: they keep
+// amounts in base units, int64 satoshi and sun and *big.Int wei, which are
+// exact, so that existing callers don't break. Amount converts them from and
+// to decimal strings at the edges, e.g. btc.ParseBtc and btc.FormatSatoshi,
+// and replaces the deprecated float64 helpers.
+type Amount struct {
+	value       *big.Int
+	decimals    int
+	hasDecimals bool
+}
+
+func NewAmount(baseUnits *big.Int, decimals int) Amount {
+	v := new(big.Int)
+	if baseUnits != nil {
+		v.Set(baseUnits)
+	}
+	return Amount{value: v, decimals: decimals, hasDecimals: true}
+}
+
+func NewAmountFromInt64(baseUnits int64, decimals int) Amount {
+	return Amount{value: big.NewInt(baseUnits), decimals: decimals, hasDecimals: true}
+}
+
+func SatoshiAmount(v int64) Amount {
+	return NewAmountFromInt64(v, DecimalsBtc)
+}
+
+func WeiAmount(v *big.Int) Amount {
+	return NewAmount(v, DecimalsEther)
+}
+
+func GweiAmount(v int64) Amount {
+	return NewAmountFromInt64(v, DecimalsGwei)
+}
+
+func SunAmount(v int64) Amount {
+	return NewAmountFromInt64(v, DecimalsTrx)
+}
+
+// ParseUnits parses a decimal string such as "1.25" into an amount with the
+// given number of decimals. It fails if s has more decimal places than allowed.
+func ParseUnits(s string, decimals int) (Amount, error) {
+	return parseUnits(s, decimals, RoundDown, true)
+}
+
+// ParseUnitsRound is like ParseUnits, but rounds excess decimal places using mode.
+func ParseUnitsRound(s string, decimals int, mode RoundingMode) (Amount, error) {
+	return parseUnits(s, decimals, mode, false)
+}
+
+// FormatUnits formats baseUnits as a decimal string with the given number of
+// decimals, trailing zeros removed.
+func FormatUnits(baseUnits *big.Int, decimals int) string {
+	return NewAmount(baseUnits, decimals).String()
+}
+
+func parseUnits(s string, decimals int, mode RoundingMode, exact bool) (Amount, error) {
+	if decimals < 0 {
+		return Amount{}, fmt.Errorf("invalid decimals: %d", decimals)
+	}
+	s = strings.TrimSpace(s)
+	neg := false
+	if strings.HasPrefix(s, "-") {
+		neg = true
+		s = s[1:]
+	} else if strings.HasPrefix(s, "+") {
+		s = s[1:]
+	}
+
+	intPart, fracPart := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, fracPart = s[:i], s[i+1:]
+	}
+	if intPart == "" && fracPart == "" {
+		return Amount{}, ErrInvalidAmount
+	}
+	if !isDigits(intPart) || !isDigits(fracPart) {
+		return Amount{}, ErrInvalidAmount
+	}
+
+	var dropped string
+	if len(fracPart) > decimals {
+		dropped = fracPart[decimals:]
+		fracPart = fracPart[:decimals]
+		if exact && strings.Trim(dropped, "0") != "" {
+			return Amount{}, ErrAmountPrecision
+		}
+	}
+	fracPart += strings.Repeat("0", decimals-len(fracPart))
+
+	digits := strings.TrimLeft(intPart+fracPart, "0")
+	v := new(big.Int)
+	if digits != "" {
+		v.SetString(digits, 10)
+	}
+	if roundUp(v, dropped, mode) {
+		v.Add(v, big.NewInt(1))
+	}
+	if neg {
+		v.Neg(v)
+	}
+	return Amount{value: v, decimals: decimals, hasDecimals: true}, nil
+}
+
+// roundUp reports whether the magnitude v must be incremented given the
+// discarded digits.
+func roundUp(v *big.Int, dropped string, mode RoundingMode) bool {
+	if strings.Trim(dropped, "0") == "" {
+		return false
+	}
+	switch mode {
+	case RoundUp:
+		return true
+	case RoundHalfUp:
+		return dropped[0] >= '5'
+	case RoundHalfEven:
+		if dropped[0] > '5' {
+			return true
+		}
+		if dropped[0] < '5' {
+			return false
+		}
+		if strings.Trim(dropped[1:], "0") != "" {
+			return true
+		}
+		return v.Bit(0) == 1
+	default:
+		return false
+	}
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (a Amount) BaseUnits() *big.Int {
+	if a.value == nil {
+		return new(big.Int)
+	}
+	return new(big.Int).Set(a.value)
+}
+
+func (a Amount) Decimals() int {
+	return a.decimals
+}
+
+// Int64 returns the amount in base units, and false if it does not fit.
+func (a Amount) Int64() (int64, bool) {
+	v := a.BaseUnits()
+	return v.Int64(), v.IsInt64()
+}
+
+func (a Amount) Sign() int {
+	if a.value == nil {
+		return 0
+	}
+	return a.value.Sign()
+}
+
+func (a Amount) IsZero() bool {
+	return a.Sign() == 0
+}
+
+// Rescale converts the amount to another number of decimals, rounding with
+// mode when precision is lost.
+func (a Amount) Rescale(decimals int, mode RoundingMode) Amount {
+	v := a.BaseUnits()
+	if decimals >= a.decimals {
+		v.Mul(v, pow10(decimals-a.decimals))
+		return Amount{value: v, decimals: decimals, hasDecimals: true}
+	}
+
+	neg := v.Sign() < 0
+	v.Abs(v)
+	q, r := new(big.Int).QuoRem(v, pow10(a.decimals-decimals), new(big.Int))
+	if r.Sign() != 0 {
+		dropped := r.String()
+		dropped = strings.Repeat("0", a.decimals-decimals-len(dropped)) + dropped
+		if roundUp(q, dropped, mode) {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	if neg {
+		q.Neg(q)
+	}
+	return Amount{value: q, decimals: decimals, hasDecimals: true}
+}
+
+func (a Amount) Add(b Amount) Amount {
+	x, y, d := align(a, b)
+	return Amount{value: x.Add(x, y), decimals: d, hasDecimals: true}
+}
+
+func (a Amount) Sub(b Amount) Amount {
+	x, y, d := align(a, b)
+	return Amount{value: x.Sub(x, y), decimals: d, hasDecimals: true}
+}
+
+func (a Amount) Cmp(b Amount) int {
+	x, y, _ := align(a, b)
+	return x.Cmp(y)
+}
+
+func align(a, b Amount) (*big.Int, *big.Int, int) {
+	d := a.decimals
+	if b.decimals > d {
+		d = b.decimals
+	}
+	x := a.BaseUnits()
+	x.Mul(x, pow10(d-a.decimals))
+	y := b.BaseUnits()
+	y.Mul(y, pow10(d-b.decimals))
+	return x, y, d
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+func (a Amount) String() string {
+	v := a.BaseUnits()
+	neg := v.Sign() < 0
+	s := v.Abs(v).String()
+	if a.decimals > 0 {
+		if len(s) <= a.decimals {
+			s = strings.Repeat("0", a.decimals-len(s)+1) + s
+		}
+		intPart, fracPart := s[:len(s)-a.decimals], strings.TrimRight(s[len(s)-a.decimals:], "0")
+		s = intPart
+		if fracPart != "" {
+			s += "." + fracPart
+		}
+	}
+	if neg {
+		s = "-" + s
+	}
+	return s
+}
+
+// MarshalText is used by TOML encoders.
+func (a Amount) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalText parses a decimal string. If the decimals of the receiver are
+// set, even to 0, the text is parsed exactly with them, otherwise they are
+// taken from the text.
+func (a *Amount) UnmarshalText(text []byte) error {
+	s := string(text)
+	decimals := a.decimals
+	if !a.hasDecimals {
+		if i := strings.IndexByte(s, '.'); i >= 0 {
+			decimals = len(strings.TrimSpace(s[i+1:]))
+		}
+	}
+	v, err := ParseUnits(s, decimals)
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
+
+// MarshalJSON encodes the amount as a string to avoid float precision loss.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+// UnmarshalJSON accepts both JSON strings and numbers.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	var s string
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+	} else {
+		var n json.Number
+		if err := json.Unmarshal(data, &n); err != nil {
+			return err
+		}
+		s = n.String()
+	}
+	return a.UnmarshalText([]byte(s))
+}
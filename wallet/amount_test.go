@@ -0,0 +1,99 @@
+package wallet
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"testing"
+)
+
+func TestParseUnits(t *testing.T) {
+	for _, tt := range []struct {
+		s        string
+		decimals int
+		want     string
+	}{
+		{"1", 8, "100000000"},
+		{"0.00000001", 8, "1"},
+		{".5", 8, "50000000"},
+		{"21000000", 8, "2100000000000000"},
+		{"-1.5", 6, "-1500000"},
+		{"0.1", 18, "100000000000000000"},
+		{"1.10", 1, "11"},
+	} {
+		a, err := ParseUnits(tt.s, tt.decimals)
+		require.NoError(t, err, tt.s)
+		require.Equal(t, tt.want, a.BaseUnits().String(), tt.s)
+	}
+
+	for _, s := range []string{"", ".", "1.2.3", "abc", "1e8", "--1"} {
+		_, err := ParseUnits(s, 8)
+		require.ErrorIs(t, err, ErrInvalidAmount, s)
+	}
+	_, err := ParseUnits("0.000000001", 8)
+	require.ErrorIs(t, err, ErrAmountPrecision)
+}
+
+func TestParseUnitsRound(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		mode RoundingMode
+		want int64
+	}{
+		{"1.25", RoundDown, 12},
+		{"1.25", RoundUp, 13},
+		{"1.25", RoundHalfUp, 13},
+		{"1.25", RoundHalfEven, 12},
+		{"1.35", RoundHalfEven, 14},
+		{"1.251", RoundHalfEven, 13},
+		{"-1.25", RoundHalfUp, -13},
+		{"1.20", RoundUp, 12},
+	} {
+		a, err := ParseUnitsRound(tt.s, 1, tt.mode)
+		require.NoError(t, err)
+		v, ok := a.Int64()
+		require.True(t, ok)
+		require.Equal(t, tt.want, v, "%s %d", tt.s, tt.mode)
+	}
+}
+
+func TestAmountFormat(t *testing.T) {
+	require.Equal(t, "0.00000001", SatoshiAmount(1).String())
+	require.Equal(t, "1", SatoshiAmount(1e8).String())
+	require.Equal(t, "-0.5", SunAmount(-500000).String())
+	require.Equal(t, "0", Amount{}.String())
+	require.Equal(t, "1.000000001", FormatEth(1000000001))
+	require.Equal(t, "0.12345678", FormatBtc(12345678))
+
+	wei, _ := new(big.Int).SetString("1234567890123456789", 10)
+	require.Equal(t, "1.234567890123456789", FormatUnits(wei, DecimalsEther))
+
+	a := WeiAmount(wei).Rescale(DecimalsGwei, RoundDown)
+	require.Equal(t, "1.23456789", a.String())
+	require.Equal(t, 0, SatoshiAmount(1).Add(SatoshiAmount(2)).Cmp(NewAmountFromInt64(3, 8)))
+	require.Equal(t, 1, SatoshiAmount(1).Cmp(NewAmountFromInt64(0, 0)))
+}
+
+func TestAmountJSON(t *testing.T) {
+	type payload struct {
+		Value Amount `json:"value"`
+	}
+	b, err := json.Marshal(payload{Value: SatoshiAmount(150000000)})
+	require.NoError(t, err)
+	require.Equal(t, `{"value":"1.5"}`, string(b))
+
+	p := payload{Value: SatoshiAmount(0)}
+	require.NoError(t, json.Unmarshal([]byte(`{"value":0.1}`), &p))
+	require.Equal(t, "10000000", p.Value.BaseUnits().String())
+
+	var q payload
+	require.NoError(t, json.Unmarshal([]byte(`{"value":"2.50"}`), &q))
+	require.Equal(t, 2, q.Value.Decimals())
+	require.Equal(t, "2.5", q.Value.String())
+
+	// Explicit 0 decimals are not inferred from the text.
+	q = payload{Value: NewAmountFromInt64(0, 0)}
+	require.ErrorIs(t, json.Unmarshal([]byte(`{"value":"2.5"}`), &q), ErrAmountPrecision)
+	require.NoError(t, json.Unmarshal([]byte(`{"value":3}`), &q))
+	require.Equal(t, "3", q.Value.String())
+}
@@ -0,0 +1,234 @@
+package wallet
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/tyler-smith/go-bip39/wordlists"
+	"math/big"
+	"strings"
+)
+
+// https://github.com/bitcoin/bips/blob/master/bip-0085.mediawiki
+const (
+	Bip85Purpose = 83696968
+
+	Bip85AppBip39  = 39
+	Bip85AppWif    = 2
+	Bip85AppXprv   = 32
+	Bip85AppHex    = 128169
+	Bip85AppPwdB64 = 707764
+
+	Bip85LangEnglish            = 0
+	Bip85LangJapanese           = 1
+	Bip85LangKorean             = 2
+	Bip85LangSpanish            = 3
+	Bip85LangChineseSimplified  = 4
+	Bip85LangChineseTraditional = 5
+	Bip85LangFrench             = 6
+	Bip85LangItalian            = 7
+	Bip85LangCzech              = 8
+)
+
+var bip85HmacKey = []byte("bip-entropy-from-k")
+
+type Bip85 struct {
+	masterKey *hdkeychain.ExtendedKey
+}
+
+func NewBip85(masterKey *hdkeychain.ExtendedKey) (*Bip85, error) {
+	if !masterKey.IsPrivate() {
+		return nil, errors.New("bip85 requires a private master key")
+	}
+	return &Bip85{masterKey: masterKey}, nil
+}
+
+// NewBip85FromXprv creates a Bip85 from a base58 encoded master xprv.
+func NewBip85FromXprv(xprv string) (*Bip85, error) {
+	masterKey, err := hdkeychain.NewKeyFromString(xprv)
+	if err != nil {
+		return nil, err
+	}
+	return NewBip85(masterKey)
+}
+
+// Bip85 returns the BIP85 deriver rooted at the master key of this wallet.
+func (this *HDWallet) Bip85() (*Bip85, error) {
+	chainParams, err := GetBtcChainParams(this.btcChainId)
+	if err != nil {
+		return nil, err
+	}
+	masterKey, err := hdkeychain.NewMaster(this.seed, chainParams)
+	if err != nil {
+		return nil, err
+	}
+	return NewBip85(masterKey)
+}
+
+// DeriveEntropy returns the 64 bytes entropy for the hardened path m/83696968'/...
+func (b *Bip85) DeriveEntropy(path string) ([]byte, error) {
+	if !strings.HasPrefix(path, fmt.Sprintf("m/%d'/", Bip85Purpose)) {
+		return nil, fmt.Errorf("invalid bip85 path: %s", path)
+	}
+	for _, s := range strings.Split(path, "/")[1:] {
+		if !strings.HasSuffix(s, "'") {
+			return nil, fmt.Errorf("bip85 path must be fully hardened: %s", path)
+		}
+	}
+
+	key, err := DerivePrivateKeyByPath(b.masterKey, path, true)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha512.New, bip85HmacKey)
+	mac.Write(key.Serialize())
+	return mac.Sum(nil), nil
+}
+
+// Mnemonic derives a BIP39 mnemonic of 12, 18 or 24 words in the given language.
+func (b *Bip85) Mnemonic(language, words, index int) (string, error) {
+	var entropyLen int
+	switch words {
+	case 12:
+		entropyLen = 16
+	case 18:
+		entropyLen = 24
+	case 24:
+		entropyLen = 32
+	default:
+		return "", fmt.Errorf("invalid bip85 mnemonic words: %d", words)
+	}
+	wordList, separator, err := bip85WordList(language)
+	if err != nil {
+		return "", err
+	}
+
+	entropy, err := b.DeriveEntropy(fmt.Sprintf("m/%d'/%d'/%d'/%d'/%d'",
+		Bip85Purpose, Bip85AppBip39, language, words, index))
+	if err != nil {
+		return "", err
+	}
+	return encodeMnemonic(entropy[:entropyLen], wordList, separator), nil
+}
+
+// WIF derives a compressed WIF private key for chainId.
+func (b *Bip85) WIF(index int, chainId int) (string, error) {
+	chainParams, err := GetBtcChainParams(chainId)
+	if err != nil {
+		return "", err
+	}
+	entropy, err := b.DeriveEntropy(fmt.Sprintf("m/%d'/%d'/%d'", Bip85Purpose, Bip85AppWif, index))
+	if err != nil {
+		return "", err
+	}
+	privKey, _ := btcec.PrivKeyFromBytes(entropy[:32])
+	wif, err := btcutil.NewWIF(privKey, chainParams, true)
+	if err != nil {
+		return "", err
+	}
+	return wif.String(), nil
+}
+
+// Xprv derives a child master extended private key, using the version of the root key.
+func (b *Bip85) Xprv(index int) (string, error) {
+	entropy, err := b.DeriveEntropy(fmt.Sprintf("m/%d'/%d'/%d'", Bip85Purpose, Bip85AppXprv, index))
+	if err != nil {
+		return "", err
+	}
+	chainCode, keyData := entropy[:32], entropy[32:]
+	var k btcec.ModNScalar
+	if overflow := k.SetByteSlice(keyData); overflow || k.IsZero() {
+		return "", hdkeychain.ErrUnusableSeed
+	}
+
+	key := hdkeychain.NewExtendedKey(b.masterKey.Version(), keyData, chainCode,
+		[]byte{0, 0, 0, 0}, 0, 0, true)
+	return key.String(), nil
+}
+
+// Hex derives numBytes (16 to 64) bytes of entropy encoded in hex.
+func (b *Bip85) Hex(numBytes, index int) (string, error) {
+	if numBytes < 16 || numBytes > 64 {
+		return "", fmt.Errorf("invalid bip85 hex length: %d", numBytes)
+	}
+	entropy, err := b.DeriveEntropy(fmt.Sprintf("m/%d'/%d'/%d'/%d'", Bip85Purpose, Bip85AppHex, numBytes, index))
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(entropy[:numBytes]), nil
+}
+
+// PasswordBase64 derives a base64 password of pwdLen (20 to 86) characters.
+func (b *Bip85) PasswordBase64(pwdLen, index int) (string, error) {
+	if pwdLen < 20 || pwdLen > 86 {
+		return "", fmt.Errorf("invalid bip85 password length: %d", pwdLen)
+	}
+	entropy, err := b.DeriveEntropy(fmt.Sprintf("m/%d'/%d'/%d'/%d'", Bip85Purpose, Bip85AppPwdB64, pwdLen, index))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(entropy)[:pwdLen], nil
+}
+
+// NewHDWallet derives an English child mnemonic and creates an HDWallet from it.
+func (b *Bip85) NewHDWallet(words, index int, password string, btcChainId int, ethChainId int) (*HDWallet, error) {
+	mnemonic, err := b.Mnemonic(Bip85LangEnglish, words, index)
+	if err != nil {
+		return nil, err
+	}
+	return NewHDWallet(mnemonic, password, btcChainId, ethChainId)
+}
+
+func bip85WordList(language int) ([]string, string, error) {
+	switch language {
+	case Bip85LangEnglish:
+		return wordlists.English, " ", nil
+	case Bip85LangJapanese:
+		return wordlists.Japanese, "\u3000", nil
+	case Bip85LangKorean:
+		return wordlists.Korean, " ", nil
+	case Bip85LangSpanish:
+		return wordlists.Spanish, " ", nil
+	case Bip85LangChineseSimplified:
+		return wordlists.ChineseSimplified, " ", nil
+	case Bip85LangChineseTraditional:
+		return wordlists.ChineseTraditional, " ", nil
+	case Bip85LangFrench:
+		return wordlists.French, " ", nil
+	case Bip85LangItalian:
+		return wordlists.Italian, " ", nil
+	case Bip85LangCzech:
+		return wordlists.Czech, " ", nil
+	default:
+		return nil, "", fmt.Errorf("unknown bip85 language: %d", language)
+	}
+}
+
+// encodeMnemonic is bip39.NewMnemonic with an explicit word list, as the
+// bip39 package only supports a global one.
+func encodeMnemonic(entropy []byte, wordList []string, separator string) string {
+	h := sha256.Sum256(entropy)
+	checksumBits := uint(len(entropy) / 4)
+	wordCount := (uint(len(entropy))*8 + checksumBits) / 11
+
+	v := new(big.Int).SetBytes(entropy)
+	v.Lsh(v, checksumBits)
+	v.Or(v, big.NewInt(int64(h[0]>>(8-checksumBits))))
+
+	words := make([]string, wordCount)
+	mask := big.NewInt(2047)
+	idx := new(big.Int)
+	for i := int(wordCount) - 1; i >= 0; i-- {
+		idx.And(v, mask)
+		v.Rsh(v, 11)
+		words[i] = wordList[idx.Int64()]
+	}
+	return strings.Join(words, separator)
+}
@@ -0,0 +1,79 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+const bip85MasterXprv = "xprv9s21ZrQH143K2LBWUUQRFXhucrQqBpKdRRxNVq2zBqsx8HVqFk2uYo8kmbaLLHRdqtQpUm98uKfu3vca1LqdGhUtyoFnCNkfmXRyPXLjbKb"
+
+func TestBip85_Entropy(t *testing.T) {
+	b, err := NewBip85FromXprv(bip85MasterXprv)
+	require.NoError(t, err)
+
+	entropy, err := b.DeriveEntropy("m/83696968'/0'/0'")
+	require.NoError(t, err)
+	require.Equal(t, "efecfbccffea313214232d29e71563d941229afb4338c21f9517c41aaa0d16f00b83d2a09ef747e7a64e8e2bd5a14869e693da66ce94ac2da570ab7ee48618f7", hex.EncodeToString(entropy))
+
+	entropy, err = b.DeriveEntropy("m/83696968'/0'/1'")
+	require.NoError(t, err)
+	require.Equal(t, "70c6e3e8ebee8dc4c0dbba66076819bb8c09672527c4277ca8729532ad711872218f826919f6b67218adde99018a6df9095ab2b58d803b5b93ec9802085a690e", hex.EncodeToString(entropy))
+
+	_, err = b.DeriveEntropy("m/83696968'/0/1'")
+	require.Error(t, err)
+}
+
+func TestBip85_Applications(t *testing.T) {
+	b, err := NewBip85FromXprv(bip85MasterXprv)
+	require.NoError(t, err)
+
+	for words, want := range map[int]string{
+		12: "girl mad pet galaxy egg matter matrix prison refuse sense ordinary nose",
+		18: "near account window bike charge season chef number sketch tomorrow excuse sniff circle vital hockey outdoor supply token",
+		24: "puppy ocean match cereal symbol another shed magic wrap hammer bulb intact gadget divorce twin tonight reason outdoor destroy simple truth cigar social volcano",
+	} {
+		mnemonic, err := b.Mnemonic(Bip85LangEnglish, words, 0)
+		require.NoError(t, err)
+		require.Equal(t, want, mnemonic)
+	}
+
+	wif, err := b.WIF(0, BtcChainMainNet)
+	require.NoError(t, err)
+	require.Equal(t, "Kzyv4uF39d4Jrw2W7UryTHwZr1zQVNk4dAFyqE6BuMrMh1Za7uhp", wif)
+
+	xprv, err := b.Xprv(0)
+	require.NoError(t, err)
+	require.Equal(t, "xprv9s21ZrQH143K2srSbCSg4m4kLvPMzcWydgmKEnMmoZUurYuBuYG46c6P71UGXMzmriLzCCBvKQWBUv3vPB3m1SATMhp3uEjXHJ42jFg7myX", xprv)
+
+	h, err := b.Hex(64, 0)
+	require.NoError(t, err)
+	require.Equal(t, "492db4698cf3b73a5a24998aa3e9d7fa96275d85724a91e71aa2d645442f878555d078fd1f1f67e368976f04137b1f7a0d19232136ca50c44614af72b5582a5c", h)
+
+	pwd, err := b.PasswordBase64(21, 0)
+	require.NoError(t, err)
+	require.Equal(t, "dKLoepugzdVJvdL56ogNV", pwd)
+}
+
+func TestBip85_HDWallet(t *testing.T) {
+	hdw, err := NewHDWallet("purse cheese cage reason cost flat jump usage hospital grit delay loan", "", BtcChainMainNet, ChainMainNet)
+	require.NoError(t, err)
+	b, err := hdw.Bip85()
+	require.NoError(t, err)
+
+	mnemonic, err := b.Mnemonic(Bip85LangEnglish, 24, 1)
+	require.NoError(t, err)
+	entropy, err := EntropyFromMnemonic(mnemonic)
+	require.NoError(t, err)
+	require.Len(t, entropy, 32)
+
+	for lang := Bip85LangEnglish; lang <= Bip85LangCzech; lang++ {
+		_, err = b.Mnemonic(lang, 12, 0)
+		require.NoError(t, err)
+	}
+
+	child, err := b.NewHDWallet(12, 0, "", BtcChainMainNet, ChainMainNet)
+	require.NoError(t, err)
+	_, err = child.NewNativeSegWitWallet(0, 0, 0)
+	require.NoError(t, err)
+}
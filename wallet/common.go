@@ -18,6 +18,8 @@ const (
 	SymbolBtc = "BTC"
 	SymbolTrx = "TRX"
 
+	SymbolNostr = "NOSTR"
+
 	BtcChainMainNet  = int(wire.MainNet)
 	BtcChainTestNet3 = int(wire.TestNet3)
 	BtcChainRegtest  = int(wire.TestNet)
@@ -99,10 +101,6 @@ func GetEthChainParams(chainId int) (*params.ChainConfig, error) {
 	}
 }
 
-func NewEntropy(bits int) (entropy []byte, err error) {
-	return bip39.NewEntropy(bits)
-}
-
 func NewMnemonic(bits int) (mnemonic string, err error) {
 	entropy, err := NewEntropy(bits)
 	if err != nil {
@@ -151,6 +149,8 @@ func MakeBipXPath(bipType int, symbol string, chainId int, accountIndex, changeT
 		coinType = int(chainParams.HDCoinType)
 	case SymbolTrx:
 		coinType = 195
+	case SymbolNostr:
+		coinType = 1237
 	default:
 		return "", fmt.Errorf("invalid symbol: %s", symbol)
 	}
@@ -165,13 +165,14 @@ func MakeBipXPath(bipType int, symbol string, chainId int, accountIndex, changeT
 }
 
 func FormatBtc(amount int64) string {
-	return FormatFloat(float64(amount)/SatoshiPerBitcoin, 8)
+	return SatoshiAmount(amount).String()
 }
 
 func FormatEth(amount int64) string {
-	return FormatFloat(float64(amount)/GweiPerEther, 9)
+	return GweiAmount(amount).String()
 }
 
+// Deprecated: float64 loses precision, use Amount or FormatUnits instead.
 func FormatFloat(f float64, precision int) string {
 	d := float64(1)
 	if precision > 0 {
@@ -0,0 +1,175 @@
+package wallet
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/text/unicode/norm"
+	"strings"
+	"unicode"
+)
+
+type ElectrumSeedType int
+
+const (
+	ElectrumSeedNone      ElectrumSeedType = 0
+	ElectrumSeedStandard  ElectrumSeedType = 1
+	ElectrumSeedSegWit    ElectrumSeedType = 2
+	ElectrumSeed2FA       ElectrumSeedType = 3
+	ElectrumSeed2FASegWit ElectrumSeedType = 4
+)
+
+var ErrNotElectrumSeed = errors.New("not an electrum v2 seed")
+
+// https://electrum.readthedocs.io/en/latest/seedphrase.html
+var electrumSeedPrefixes = []struct {
+	prefix   string
+	seedType ElectrumSeedType
+}{
+	{"01", ElectrumSeedStandard},
+	{"100", ElectrumSeedSegWit},
+	{"101", ElectrumSeed2FA},
+	{"102", ElectrumSeed2FASegWit},
+}
+
+func (t ElectrumSeedType) String() string {
+	switch t {
+	case ElectrumSeedStandard:
+		return "standard"
+	case ElectrumSeedSegWit:
+		return "segwit"
+	case ElectrumSeed2FA:
+		return "2fa"
+	case ElectrumSeed2FASegWit:
+		return "2fa_segwit"
+	default:
+		return "none"
+	}
+}
+
+// GetElectrumSeedType returns the version of an Electrum v2 seed, or
+// ElectrumSeedNone if the mnemonic is not one.
+func GetElectrumSeedType(mnemonic string) ElectrumSeedType {
+	mac := hmac.New(sha512.New, []byte("Seed version"))
+	mac.Write([]byte(normalizeElectrumText(mnemonic)))
+	h := hex.EncodeToString(mac.Sum(nil))
+	for _, p := range electrumSeedPrefixes {
+		if strings.HasPrefix(h, p.prefix) {
+			return p.seedType
+		}
+	}
+	return ElectrumSeedNone
+}
+
+// NewSeedFromElectrumMnemonic stretches an Electrum v2 seed into the BIP32 root seed.
+func NewSeedFromElectrumMnemonic(mnemonic, passphrase string) ([]byte, ElectrumSeedType, error) {
+	seedType := GetElectrumSeedType(mnemonic)
+	if seedType == ElectrumSeedNone {
+		return nil, seedType, ErrNotElectrumSeed
+	}
+	seed := pbkdf2.Key([]byte(normalizeElectrumText(mnemonic)),
+		[]byte("electrum"+normalizeElectrumText(passphrase)), 2048, 64, sha512.New)
+	return seed, seedType, nil
+}
+
+// NewHDWalletFromElectrum creates an HDWallet from an Electrum v2 "standard" or
+// "segwit" seed. Use NewElectrumWallet to derive addresses with Electrum's layout.
+func NewHDWalletFromElectrum(mnemonic, passphrase string, btcChainId int, ethChainId int) (*HDWallet, error) {
+	seed, seedType, err := NewSeedFromElectrumMnemonic(mnemonic, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if seedType != ElectrumSeedStandard && seedType != ElectrumSeedSegWit {
+		return nil, fmt.Errorf("unsupported electrum seed type: %s", seedType)
+	}
+	return &HDWallet{seed: seed, btcChainId: btcChainId, ethChainId: ethChainId,
+		electrumSeedType: seedType}, nil
+}
+
+func (this *HDWallet) ElectrumSeedType() ElectrumSeedType {
+	return this.electrumSeedType
+}
+
+// NewElectrumWallet derives the BTC wallet Electrum would use:
+// m/changeType/index for standard seeds (P2PKH) and
+// m/0'/changeType/index for segwit seeds (P2WPKH).
+func (this *HDWallet) NewElectrumWallet(changeType, index int) (*BtcWallet, error) {
+	if changeType != ChangeTypeExternal && changeType != ChangeTypeInternal {
+		return nil, errors.New("invalid change type")
+	}
+	if index < 0 {
+		return nil, errors.New("invalid index")
+	}
+
+	var path string
+	var segWitType SegWitType
+	switch this.electrumSeedType {
+	case ElectrumSeedStandard:
+		path = fmt.Sprintf("m/%d/%d", changeType, index)
+		segWitType = SegWitNone
+	case ElectrumSeedSegWit:
+		path = fmt.Sprintf("m/0'/%d/%d", changeType, index)
+		segWitType = SegWitNative
+	default:
+		return nil, ErrNotElectrumSeed
+	}
+
+	chainParams, err := GetBtcChainParams(this.btcChainId)
+	if err != nil {
+		return nil, err
+	}
+	masterKey, err := hdkeychain.NewMaster(this.seed, chainParams)
+	if err != nil {
+		return nil, err
+	}
+	// Electrum always uses standard BIP32 derivation.
+	privateKey, err := DerivePrivateKeyByPath(masterKey, path, true)
+	if err != nil {
+		return nil, err
+	}
+	keyOrigin, err := NewKeyOrigin(masterKey, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BtcWallet{symbol: SymbolBtc,
+		chainParams: chainParams, segWitType: segWitType,
+		privateKey: privateKey,
+		publicKey:  privateKey.PubKey(),
+		keyOrigin:  keyOrigin}, nil
+}
+
+// normalizeElectrumText is electrum's normalize_text: NFKD, lower case,
+// accents removed, whitespace collapsed and removed between CJK characters.
+func normalizeElectrumText(s string) string {
+	s = strings.ToLower(norm.NFKD.String(s))
+	var b strings.Builder
+	for _, r := range s {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	words := strings.Fields(b.String())
+	b.Reset()
+	for i, w := range words {
+		if i > 0 {
+			prev := []rune(words[i-1])
+			cur := []rune(w)
+			if !isCJK(prev[len(prev)-1]) || !isCJK(cur[0]) {
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString(w)
+	}
+	return b.String()
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
@@ -0,0 +1,50 @@
+package wallet
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestElectrum_SeedType(t *testing.T) {
+	require.Equal(t, ElectrumSeedStandard, GetElectrumSeedType("cycle rocket west magnet parrot shuffle foot correct salt library feed song"))
+	require.Equal(t, ElectrumSeedSegWit, GetElectrumSeedType("bitter grass shiver impose acquire brush forget axis eager alone wine silver"))
+	require.Equal(t, ElectrumSeedSegWit, GetElectrumSeedType("  Bitter grass shiver\nimpose acquire brush forget axis eager alone wine   silver "))
+	require.Equal(t, ElectrumSeedNone, GetElectrumSeedType("purse cheese cage reason cost flat jump usage hospital grit delay loan"))
+
+	_, err := NewHDWalletFromElectrum("purse cheese cage reason cost flat jump usage hospital grit delay loan", "", BtcChainMainNet, ChainMainNet)
+	require.ErrorIs(t, err, ErrNotElectrumSeed)
+}
+
+func TestElectrum_Addresses(t *testing.T) {
+	for _, tt := range []struct {
+		mnemonic string
+		seedType ElectrumSeedType
+		receive  string
+		change   string
+	}{
+		{
+			mnemonic: "cycle rocket west magnet parrot shuffle foot correct salt library feed song",
+			seedType: ElectrumSeedStandard,
+			receive:  "1NNkttn1YvVGdqBW4PR6zvc3Zx3H5owKRf",
+			change:   "1KSezYMhAJMWqFbVFB2JshYg69UpmEXR4D",
+		},
+		{
+			mnemonic: "bitter grass shiver impose acquire brush forget axis eager alone wine silver",
+			seedType: ElectrumSeedSegWit,
+			receive:  "bc1q3g5tmkmlvxryhh843v4dz026avatc0zzr6h3af",
+			change:   "bc1qdy94n2q5qcp0kg7v9yzwe6wvfkhnvyzje7nx2p",
+		},
+	} {
+		hdw, err := NewHDWalletFromElectrum(tt.mnemonic, "", BtcChainMainNet, ChainMainNet)
+		require.NoError(t, err)
+		require.Equal(t, tt.seedType, hdw.ElectrumSeedType())
+
+		w, err := hdw.NewElectrumWallet(ChangeTypeExternal, 0)
+		require.NoError(t, err)
+		require.Equal(t, tt.receive, w.DeriveAddress())
+
+		w, err = hdw.NewElectrumWallet(ChangeTypeInternal, 0)
+		require.NoError(t, err)
+		require.Equal(t, tt.change, w.DeriveAddress())
+	}
+}
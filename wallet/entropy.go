@@ -0,0 +1,339 @@
+package wallet
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// EntropySource is the randomness used by NewEntropy and for mixing user entropy.
+// Tests can replace it with NewDeterministicReader.
+var EntropySource io.Reader = rand.Reader
+
+var (
+	ErrNotEnoughEntropy  = errors.New("not enough entropy")
+	ErrLowQualityEntropy = errors.New("entropy failed quality check")
+)
+
+type EntropyOptions struct {
+	// MixWithSystem xors the user entropy with bytes from EntropySource, so
+	// the result is at least as strong as either of them.
+	MixWithSystem bool
+	// SkipQualityCheck disables the statistical sanity checks on user input.
+	SkipQualityCheck bool
+}
+
+func NewEntropy(bits int) (entropy []byte, err error) {
+	if err = validateEntropyBits(bits); err != nil {
+		return nil, err
+	}
+	entropy = make([]byte, bits/8)
+	if _, err = io.ReadFull(EntropySource, entropy); err != nil {
+		return nil, err
+	}
+	return entropy, nil
+}
+
+// NewEntropyFromDice converts dice rolls (faces 1 to sides) into entropy without bias.
+// Rolls may be separated by spaces or commas; for dice with less than 10 sides
+// they may also be written contiguously, e.g. "3516242...".
+func NewEntropyFromDice(rolls string, sides int, bits int, opts *EntropyOptions) ([]byte, error) {
+	if sides < 2 || sides > 256 {
+		return nil, fmt.Errorf("invalid dice sides: %d", sides)
+	}
+	values, err := parseSymbols(rolls, sides, 1)
+	if err != nil {
+		return nil, err
+	}
+	return newEntropyFromSymbols(values, sides, bits, opts)
+}
+
+// NewEntropyFromCoinFlips converts coin flips written as H/T or 1/0 into entropy.
+func NewEntropyFromCoinFlips(flips string, bits int, opts *EntropyOptions) ([]byte, error) {
+	s := strings.ToUpper(flips)
+	s = strings.NewReplacer("H", "1", "T", "0").Replace(s)
+	values, err := parseSymbols(s, 2, 0)
+	if err != nil {
+		return nil, err
+	}
+	return newEntropyFromSymbols(values, 2, bits, opts)
+}
+
+// NewEntropyFromHex uses externally supplied hex, e.g. from a hardware RNG.
+func NewEntropyFromHex(s string, bits int, opts *EntropyOptions) ([]byte, error) {
+	if err := validateEntropyBits(bits); err != nil {
+		return nil, err
+	}
+	b, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	if len(b)*8 < bits {
+		return nil, fmt.Errorf("%w: got %d bits, need %d", ErrNotEnoughEntropy, len(b)*8, bits)
+	}
+	b = b[:bits/8]
+	if opts == nil || !opts.SkipQualityCheck {
+		if err = CheckEntropyQuality(b); err != nil {
+			return nil, err
+		}
+	}
+	return finishEntropy(b, opts)
+}
+
+func NewMnemonicFromDice(rolls string, sides int, bits int, opts *EntropyOptions) (string, error) {
+	entropy, err := NewEntropyFromDice(rolls, sides, bits, opts)
+	if err != nil {
+		return "", err
+	}
+	return NewMnemonicByEntropy(entropy)
+}
+
+// DiceRollsNeeded returns the expected number of rolls to collect bits of entropy.
+func DiceRollsNeeded(sides int, bits int) int {
+	return int(math.Ceil(float64(bits) / expectedBitsPerSymbol(sides)))
+}
+
+// CheckEntropyQuality runs basic sanity checks: monobit frequency, longest run
+// of identical bits, repeated bytes and repeated blocks. It only catches
+// grossly broken input.
+func CheckEntropyQuality(entropy []byte) error {
+	n := len(entropy) * 8
+	if n == 0 {
+		return ErrNotEnoughEntropy
+	}
+
+	ones := 0
+	longestRun, run := 0, 0
+	var prev byte = 2
+	for i := 0; i < n; i++ {
+		bit := (entropy[i/8] >> (7 - uint(i%8))) & 1
+		ones += int(bit)
+		if bit == prev {
+			run++
+		} else {
+			run = 1
+			prev = bit
+		}
+		if run > longestRun {
+			longestRun = run
+		}
+	}
+
+	// Allow 4 standard deviations from n/2.
+	if math.Abs(float64(ones)-float64(n)/2) > 4*math.Sqrt(float64(n))/2 {
+		return fmt.Errorf("%w: %d of %d bits set", ErrLowQualityEntropy, ones, n)
+	}
+	if longestRun > 2*int(math.Log2(float64(n)))+4 {
+		return fmt.Errorf("%w: run of %d identical bits", ErrLowQualityEntropy, longestRun)
+	}
+
+	counts := make(map[byte]int)
+	for _, b := range entropy {
+		counts[b]++
+	}
+	if len(entropy) >= 16 && len(counts) <= len(entropy)/4 {
+		return fmt.Errorf("%w: only %d distinct bytes", ErrLowQualityEntropy, len(counts))
+	}
+	if period := entropyPeriod(entropy); period > 0 {
+		return fmt.Errorf("%w: repeats every %d bytes", ErrLowQualityEntropy, period)
+	}
+	return nil
+}
+
+// entropyPeriod returns the smallest period of entropy repeated at least
+// twice, 0 if none.
+func entropyPeriod(entropy []byte) int {
+	for p := 1; p <= len(entropy)/2; p++ {
+		periodic := true
+		for i := p; i < len(entropy); i++ {
+			if entropy[i] != entropy[i-p] {
+				periodic = false
+				break
+			}
+		}
+		if periodic {
+			return p
+		}
+	}
+	return 0
+}
+
+// checkSymbolQuality runs a chi-square uniformity test on dice rolls.
+func checkSymbolQuality(values []int, base int) error {
+	counts := make([]int, base)
+	for _, v := range values {
+		counts[v]++
+	}
+	for _, c := range counts {
+		if c == len(values) {
+			return fmt.Errorf("%w: all symbols are identical", ErrLowQualityEntropy)
+		}
+	}
+	expected := float64(len(values)) / float64(base)
+	if expected < 5 {
+		// not enough samples for a meaningful test
+		return nil
+	}
+
+	chi2 := 0.0
+	for _, c := range counts {
+		d := float64(c) - expected
+		chi2 += d * d / expected
+	}
+	df := float64(base - 1)
+	if chi2 > df+5*math.Sqrt(2*df) {
+		return fmt.Errorf("%w: symbol distribution is skewed (chi2 %.1f)", ErrLowQualityEntropy, chi2)
+	}
+	return nil
+}
+
+func newEntropyFromSymbols(values []int, base int, bits int, opts *EntropyOptions) ([]byte, error) {
+	if err := validateEntropyBits(bits); err != nil {
+		return nil, err
+	}
+	if opts == nil || !opts.SkipQualityCheck {
+		if err := checkSymbolQuality(values, base); err != nil {
+			return nil, err
+		}
+	}
+
+	entropy := make([]byte, bits/8)
+	got := 0
+	for _, v := range values {
+		if got >= bits {
+			break
+		}
+		value, n := symbolToBits(v, base)
+		for i := n - 1; i >= 0 && got < bits; i-- {
+			if (value>>uint(i))&1 == 1 {
+				entropy[got/8] |= 1 << (7 - uint(got%8))
+			}
+			got++
+		}
+	}
+	if got < bits {
+		return nil, fmt.Errorf("%w: got %d bits, need %d, about %d more rolls",
+			ErrNotEnoughEntropy, got, bits, DiceRollsNeeded(base, bits-got))
+	}
+	if opts == nil || !opts.SkipQualityCheck {
+		// A uniform distribution of symbols may still yield patterned bits.
+		if err := CheckEntropyQuality(entropy); err != nil {
+			return nil, err
+		}
+	}
+	return finishEntropy(entropy, opts)
+}
+
+// symbolToBits maps a uniform value in [0, base) to uniform bits without bias:
+// base is split into power of two blocks (e.g. 6 = 4 + 2), a value in a block
+// of size 2^k yields k bits. A value in a block of size 1 yields nothing.
+func symbolToBits(v int, base int) (int, int) {
+	offset := 0
+	for k := 8; k >= 0; k-- {
+		size := 1 << uint(k)
+		if base&size == 0 {
+			continue
+		}
+		if v < offset+size {
+			return v - offset, k
+		}
+		offset += size
+	}
+	return 0, 0
+}
+
+func expectedBitsPerSymbol(base int) float64 {
+	total := 0.0
+	for k := 8; k >= 0; k-- {
+		size := 1 << uint(k)
+		if base&size != 0 {
+			total += float64(size) * float64(k)
+		}
+	}
+	return total / float64(base)
+}
+
+func parseSymbols(s string, base int, first int) ([]int, error) {
+	s = strings.TrimSpace(s)
+	var fields []string
+	if strings.ContainsAny(s, " ,\t\r\n") || base+first > 10 {
+		fields = strings.FieldsFunc(s, func(r rune) bool {
+			return r == ' ' || r == ',' || r == '\t' || r == '\n' || r == '\r'
+		})
+	} else {
+		for _, c := range s {
+			fields = append(fields, string(c))
+		}
+	}
+
+	values := make([]int, 0, len(fields))
+	for _, f := range fields {
+		v, err := strconv.Atoi(f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid symbol: %q", f)
+		}
+		v -= first
+		if v < 0 || v >= base {
+			return nil, fmt.Errorf("symbol out of range: %q", f)
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func finishEntropy(entropy []byte, opts *EntropyOptions) ([]byte, error) {
+	if opts != nil && opts.MixWithSystem {
+		sys := make([]byte, len(entropy))
+		if _, err := io.ReadFull(EntropySource, sys); err != nil {
+			return nil, err
+		}
+		for i := range entropy {
+			entropy[i] ^= sys[i]
+		}
+	}
+	return entropy, nil
+}
+
+func validateEntropyBits(bits int) error {
+	if bits%32 != 0 || bits < 128 || bits > 256 {
+		return fmt.Errorf("invalid entropy bits: %d", bits)
+	}
+	return nil
+}
+
+type deterministicReader struct {
+	seed    [32]byte
+	counter uint64
+	buf     []byte
+}
+
+// NewDeterministicReader returns a reproducible byte stream derived from seed
+// with SHA256 in counter mode. It is meant for tests only.
+func NewDeterministicReader(seed []byte) io.Reader {
+	return &deterministicReader{seed: sha256.Sum256(seed)}
+}
+
+func (r *deterministicReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(r.buf) == 0 {
+			var block [40]byte
+			copy(block[:], r.seed[:])
+			binary.BigEndian.PutUint64(block[32:], r.counter)
+			r.counter++
+			h := sha256.Sum256(block[:])
+			r.buf = h[:]
+		}
+		c := copy(p[n:], r.buf)
+		r.buf = r.buf[c:]
+		n += c
+	}
+	return n, nil
+}
@@ -0,0 +1,101 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestEntropy_Deterministic(t *testing.T) {
+	old := EntropySource
+	defer func() { EntropySource = old }()
+
+	EntropySource = NewDeterministicReader([]byte("hdwallet"))
+	e1, err := NewEntropy(128)
+	require.NoError(t, err)
+	EntropySource = NewDeterministicReader([]byte("hdwallet"))
+	e2, err := NewEntropy(128)
+	require.NoError(t, err)
+	require.Equal(t, e1, e2)
+	require.NoError(t, CheckEntropyQuality(e1))
+
+	_, err = NewEntropy(100)
+	require.Error(t, err)
+}
+
+func TestEntropy_Dice(t *testing.T) {
+	v, n := symbolToBits(3, 6)
+	require.Equal(t, 3, v)
+	require.Equal(t, 2, n)
+	v, n = symbolToBits(5, 6)
+	require.Equal(t, 1, v)
+	require.Equal(t, 1, n)
+
+	rolls := "3516242561345216354162534612534261543216543261345621534261534216354126354126345162534612"
+	entropy, err := NewEntropyFromDice(rolls, 6, 128, nil)
+	require.NoError(t, err)
+	require.Len(t, entropy, 16)
+
+	again, err := NewEntropyFromDice(strings.Join(strings.Split(rolls, ""), ", "), 6, 128, nil)
+	require.NoError(t, err)
+	require.Equal(t, entropy, again)
+	again, err = NewEntropyFromDice(rolls+"\n", 6, 128, nil)
+	require.NoError(t, err)
+	require.Equal(t, entropy, again)
+
+	_, err = NewEntropyFromDice(rolls[:20], 6, 128, nil)
+	require.ErrorIs(t, err, ErrNotEnoughEntropy)
+	_, err = NewEntropyFromDice(strings.Repeat("1", 200), 6, 128, nil)
+	require.ErrorIs(t, err, ErrLowQualityEntropy)
+	_, err = NewEntropyFromDice("1 2 7", 6, 128, nil)
+	require.Error(t, err)
+
+	mnemonic, err := NewMnemonicFromDice(rolls, 6, 128, nil)
+	require.NoError(t, err)
+	e, err := EntropyFromMnemonic(mnemonic)
+	require.NoError(t, err)
+	require.Equal(t, entropy, e)
+}
+
+func TestEntropy_CoinFlipsAndHex(t *testing.T) {
+	flips := "HTHHTTTHHHHTHTHTTHTTTTTHTHTTTHTHTTTTHTTHTTTTTTHHHHHTTHTHHTTTTTTTTHTTHHHTTTHTTTTTHTTTHTTTHTTHTTTHHTHTHHHTHHHHHTTHHTTHHTHTHTHHTTTH"
+	entropy, err := NewEntropyFromCoinFlips(flips, 128, nil)
+	require.NoError(t, err)
+	require.Equal(t, "b1ea41450903e5804e208891aef99ab1", hex.EncodeToString(entropy))
+
+	// Evenly distributed flips with a period are rejected.
+	periodic := strings.Repeat("HTTHHTHTTTHHTHHTHTHHTTHTTHTHHTTH", 4)
+	_, err = NewEntropyFromCoinFlips(periodic, 128, nil)
+	require.ErrorIs(t, err, ErrLowQualityEntropy)
+	entropy, err = NewEntropyFromCoinFlips(periodic, 128, &EntropyOptions{SkipQualityCheck: true})
+	require.NoError(t, err)
+	require.Equal(t, "9a36b2599a36b2599a36b2599a36b259", hex.EncodeToString(entropy))
+	block, err := hex.DecodeString("b1ea41450903e580")
+	require.NoError(t, err)
+	require.ErrorIs(t, CheckEntropyQuality(append(block, block...)), ErrLowQualityEntropy)
+
+	entropy, err = NewEntropyFromHex("9ad9b4d64f1c8be07a3512cd86e4f1a3", 128, nil)
+	require.NoError(t, err)
+	require.Equal(t, "9ad9b4d64f1c8be07a3512cd86e4f1a3", hex.EncodeToString(entropy))
+
+	_, err = NewEntropyFromHex(strings.Repeat("00", 16), 128, nil)
+	require.ErrorIs(t, err, ErrLowQualityEntropy)
+	_, err = NewEntropyFromHex(strings.Repeat("00", 16), 128, &EntropyOptions{SkipQualityCheck: true})
+	require.NoError(t, err)
+}
+
+func TestEntropy_MixWithSystem(t *testing.T) {
+	old := EntropySource
+	defer func() { EntropySource = old }()
+
+	EntropySource = NewDeterministicReader([]byte("mix"))
+	sys, err := NewEntropy(128)
+	require.NoError(t, err)
+
+	EntropySource = NewDeterministicReader([]byte("mix"))
+	mixed, err := NewEntropyFromHex(strings.Repeat("00", 16), 128,
+		&EntropyOptions{SkipQualityCheck: true, MixWithSystem: true})
+	require.NoError(t, err)
+	require.Equal(t, sys, mixed)
+}
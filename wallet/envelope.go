@@ -0,0 +1,211 @@
+package wallet
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+)
+
+const (
+	EnvelopeVersion = 1
+
+	// EnvelopeChunkPrefix starts every chunk of an envelope.
+	EnvelopeChunkPrefix = "HDWENV"
+	// DefaultEnvelopeChunkSize is the payload size of a chunk, small enough
+	// for an animated QR code frame that phones scan reliably.
+	DefaultEnvelopeChunkSize = 200
+)
+
+var (
+	ErrEnvelopeSymbol    = errors.New("envelope is for another chain")
+	ErrEnvelopeNotSigned = errors.New("envelope is not signed")
+	ErrEnvelopeSigned    = errors.New("envelope is already signed")
+	ErrEnvelopeChunk     = errors.New("invalid envelope chunk")
+)
+
+// Envelope carries a transaction between an online host, which builds it with
+// everything signing needs (prevouts, nonce, gas, reference block), and an
+// offline host, which signs it without network access. The online host then
+// broadcasts the signed envelope.
+type Envelope struct {
+	Version int    `json:"version"`
+	Symbol  string `json:"symbol"`
+	ChainId int    `json:"chainId"`
+	// Unsigned is the chain specific unsigned transaction, see the
+	// NewEnvelope and SignEnvelope functions of the btc, eth and trx packages.
+	Unsigned json.RawMessage `json:"unsigned,omitempty"`
+	// Signed is the signed raw transaction in hex, ready to broadcast.
+	Signed string `json:"signed,omitempty"`
+	TxID   string `json:"txid,omitempty"`
+}
+
+// NewUnsignedEnvelope wraps the chain specific unsigned transaction.
+func NewUnsignedEnvelope(symbol string, chainId int, unsigned interface{}) (*Envelope, error) {
+	data, err := json.Marshal(unsigned)
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{Version: EnvelopeVersion, Symbol: symbol, ChainId: chainId, Unsigned: data}, nil
+}
+
+// NewSignedEnvelope returns the envelope of the signed transaction of e.
+func (e *Envelope) NewSignedEnvelope(signedHex, txid string) *Envelope {
+	return &Envelope{Version: EnvelopeVersion, Symbol: e.Symbol, ChainId: e.ChainId,
+		Signed: signedHex, TxID: txid}
+}
+
+// DecodeUnsigned checks the envelope is an unsigned one of symbol and decodes
+// its transaction into v.
+func (e *Envelope) DecodeUnsigned(symbol string, v interface{}) error {
+	if err := e.check(symbol); err != nil {
+		return err
+	}
+	if e.Signed != "" {
+		return ErrEnvelopeSigned
+	}
+	if len(e.Unsigned) == 0 {
+		return errors.New("envelope has no unsigned transaction")
+	}
+	return json.Unmarshal(e.Unsigned, v)
+}
+
+// SignedTx checks the envelope is a signed one of symbol and returns its raw
+// transaction in hex.
+func (e *Envelope) SignedTx(symbol string) (string, error) {
+	if err := e.check(symbol); err != nil {
+		return "", err
+	}
+	if e.Signed == "" {
+		return "", ErrEnvelopeNotSigned
+	}
+	return e.Signed, nil
+}
+
+func (e *Envelope) check(symbol string) error {
+	if e.Version != EnvelopeVersion {
+		return fmt.Errorf("unsupported envelope version: %d", e.Version)
+	}
+	if e.Symbol != symbol {
+		return fmt.Errorf("%w: %s", ErrEnvelopeSymbol, e.Symbol)
+	}
+	return nil
+}
+
+func (e *Envelope) Marshal() ([]byte, error) {
+	return json.Marshal(e)
+}
+
+func UnmarshalEnvelope(data []byte) (*Envelope, error) {
+	var e Envelope
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// Chunks splits the JSON envelope into chunks of chunkSize bytes of payload,
+// DefaultEnvelopeChunkSize if zero, to be shown as an animated QR code. A chunk
+// is "HDWENV:<index>/<count>:<crc32 of the envelope>:<base64url payload>", so
+// they can be scanned in any order and frames of another envelope are rejected.
+func (e *Envelope) Chunks(chunkSize int) ([]string, error) {
+	if chunkSize <= 0 {
+		chunkSize = DefaultEnvelopeChunkSize
+	}
+	data, err := e.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	checksum := crc32.ChecksumIEEE(data)
+	count := (len(data) + chunkSize - 1) / chunkSize
+	chunks := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		chunks = append(chunks, fmt.Sprintf("%s:%d/%d:%08x:%s", EnvelopeChunkPrefix, i+1, count,
+			checksum, base64.RawURLEncoding.EncodeToString(data[i*chunkSize:end])))
+	}
+	return chunks, nil
+}
+
+// EnvelopeJoiner reassembles an envelope from its chunks, as scanned from an
+// animated QR code: in any order and with repeats.
+type EnvelopeJoiner struct {
+	checksum uint32
+	parts    [][]byte
+	received int
+}
+
+// Add adds a chunk and reports whether all chunks are received.
+func (j *EnvelopeJoiner) Add(chunk string) (bool, error) {
+	fields := strings.SplitN(chunk, ":", 4)
+	if len(fields) != 4 || fields[0] != EnvelopeChunkPrefix {
+		return false, ErrEnvelopeChunk
+	}
+	indexes := strings.SplitN(fields[1], "/", 2)
+	if len(indexes) != 2 {
+		return false, ErrEnvelopeChunk
+	}
+	index, err1 := strconv.Atoi(indexes[0])
+	count, err2 := strconv.Atoi(indexes[1])
+	checksum, err3 := strconv.ParseUint(fields[2], 16, 32)
+	if err1 != nil || err2 != nil || err3 != nil || count < 1 || index < 1 || index > count {
+		return false, ErrEnvelopeChunk
+	}
+	part, err := base64.RawURLEncoding.DecodeString(fields[3])
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrEnvelopeChunk, err)
+	}
+
+	if j.parts == nil {
+		j.checksum = uint32(checksum)
+		j.parts = make([][]byte, count)
+	} else if j.checksum != uint32(checksum) || len(j.parts) != count {
+		return false, fmt.Errorf("%w: chunk of another envelope", ErrEnvelopeChunk)
+	}
+	if j.parts[index-1] == nil {
+		j.parts[index-1] = part
+		j.received++
+	}
+	return j.Complete(), nil
+}
+
+func (j *EnvelopeJoiner) Complete() bool {
+	return j.parts != nil && j.received == len(j.parts)
+}
+
+// Progress returns the number of chunks received and expected.
+func (j *EnvelopeJoiner) Progress() (int, int) {
+	return j.received, len(j.parts)
+}
+
+// Envelope returns the reassembled envelope once all chunks are received.
+func (j *EnvelopeJoiner) Envelope() (*Envelope, error) {
+	if !j.Complete() {
+		return nil, errors.New("envelope chunks missing")
+	}
+	var data []byte
+	for _, part := range j.parts {
+		data = append(data, part...)
+	}
+	if crc32.ChecksumIEEE(data) != j.checksum {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrEnvelopeChunk)
+	}
+	return UnmarshalEnvelope(data)
+}
+
+// JoinEnvelopeChunks reassembles an envelope from all its chunks.
+func JoinEnvelopeChunks(chunks []string) (*Envelope, error) {
+	var j EnvelopeJoiner
+	for _, chunk := range chunks {
+		if _, err := j.Add(chunk); err != nil {
+			return nil, err
+		}
+	}
+	return j.Envelope()
+}
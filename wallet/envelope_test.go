@@ -0,0 +1,67 @@
+package wallet
+
+import (
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestEnvelope_Chunks(t *testing.T) {
+	env, err := NewUnsignedEnvelope(SymbolEth, ChainMainNet, map[string]string{"data": strings.Repeat("ab", 300)})
+	require.NoError(t, err)
+	chunks, err := env.Chunks(100)
+	require.NoError(t, err)
+	require.Len(t, chunks, 7)
+	for _, chunk := range chunks {
+		require.True(t, strings.HasPrefix(chunk, EnvelopeChunkPrefix+":"))
+	}
+
+	// As scanned from an animated QR code: out of order and repeated.
+	var j EnvelopeJoiner
+	for i := len(chunks) - 1; i > 0; i-- {
+		done, err := j.Add(chunks[i])
+		require.NoError(t, err)
+		require.False(t, done)
+		_, err = j.Add(chunks[i])
+		require.NoError(t, err)
+	}
+	received, total := j.Progress()
+	require.Equal(t, 6, received)
+	require.Equal(t, 7, total)
+	_, err = j.Envelope()
+	require.Error(t, err)
+	done, err := j.Add(chunks[0])
+	require.NoError(t, err)
+	require.True(t, done)
+	joined, err := j.Envelope()
+	require.NoError(t, err)
+	require.Equal(t, env, joined)
+
+	var m map[string]string
+	require.NoError(t, joined.DecodeUnsigned(SymbolEth, &m))
+	require.ErrorIs(t, joined.DecodeUnsigned(SymbolBtc, &m), ErrEnvelopeSymbol)
+	_, err = joined.SignedTx(SymbolEth)
+	require.ErrorIs(t, err, ErrEnvelopeNotSigned)
+
+	signed := joined.NewSignedEnvelope("00", "01")
+	signedHex, err := signed.SignedTx(SymbolEth)
+	require.NoError(t, err)
+	require.Equal(t, "00", signedHex)
+	require.ErrorIs(t, signed.DecodeUnsigned(SymbolEth, &m), ErrEnvelopeSigned)
+
+	// A chunk of another envelope is rejected.
+	other, err := NewUnsignedEnvelope(SymbolEth, ChainMainNet, map[string]string{"data": strings.Repeat("cd", 300)})
+	require.NoError(t, err)
+	otherChunks, err := other.Chunks(100)
+	require.NoError(t, err)
+	j = EnvelopeJoiner{}
+	_, err = j.Add(chunks[0])
+	require.NoError(t, err)
+	_, err = j.Add(otherChunks[1])
+	require.ErrorIs(t, err, ErrEnvelopeChunk)
+	_, err = j.Add("HDWENV:1/2:zz:AA")
+	require.ErrorIs(t, err, ErrEnvelopeChunk)
+
+	_, err = JoinEnvelopeChunks(chunks[:3])
+	require.Error(t, err)
+}
@@ -9,6 +9,8 @@ type HDWallet struct {
 	seed       []byte
 	btcChainId int
 	ethChainId int
+
+	electrumSeedType ElectrumSeedType
 }
 
 func NewHDWallet(mnemonic, password string, btcChainId int, ethChainId int) (*HDWallet, error) {
@@ -47,6 +49,15 @@ func (this *HDWallet) NewNativeSegWitWallet(accountIndex, changeType, index int)
 	return this.NewWalletByPath(SymbolBtc, path, SegWitNative)
 }
 
+// NewNostrWallet derives the NIP-06 key m/44'/1237'/account'/0/0.
+func (this *HDWallet) NewNostrWallet(accountIndex int) (Wallet, error) {
+	path, err := MakeBip44Path(SymbolNostr, 0, accountIndex, ChangeTypeExternal, 0)
+	if err != nil {
+		return nil, err
+	}
+	return this.NewWalletByPath(SymbolNostr, path, SegWitNone)
+}
+
 func (this *HDWallet) NewWalletByPath(symbol string, path string, segWitType SegWitType) (Wallet, error) {
 	var w Wallet
 	var err error
@@ -58,6 +69,8 @@ func (this *HDWallet) NewWalletByPath(symbol string, path string, segWitType Seg
 		w, err = NewEthWalletByPath(path, this.seed, this.ethChainId)
 	case SymbolTrx:
 		w, err = NewTrxWalletByPath(path, this.seed)
+	case SymbolNostr:
+		w, err = NewNostrWalletByPath(path, this.seed)
 	default:
 		err = fmt.Errorf("invalid symbol: %s", symbol)
 	}
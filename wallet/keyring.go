@@ -0,0 +1,177 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/ethereum/go-ethereum/accounts"
+	"strings"
+)
+
+// BtcKeyring holds the keys of many HD derived addresses. It implements
+// txauthor.SecretsSource, so one transaction can spend from all of them.
+type BtcKeyring struct {
+	masterKey   *hdkeychain.ExtendedKey
+	chainId     int
+	chainParams *chaincfg.Params
+	wallets     map[string]*BtcWallet
+	ordered     []*BtcWallet
+}
+
+// NewBtcKeyring creates an empty keyring rooted at the master key of this wallet.
+func (this *HDWallet) NewBtcKeyring() (*BtcKeyring, error) {
+	chainParams, err := GetBtcChainParams(this.btcChainId)
+	if err != nil {
+		return nil, err
+	}
+	masterKey, err := hdkeychain.NewMaster(this.seed, chainParams)
+	if err != nil {
+		return nil, err
+	}
+	return &BtcKeyring{masterKey: masterKey, chainId: this.btcChainId, chainParams: chainParams,
+		wallets: make(map[string]*BtcWallet)}, nil
+}
+
+// AddPath derives the key of path and adds its address of segWitType.
+func (k *BtcKeyring) AddPath(path string, segWitType SegWitType) (*BtcWallet, error) {
+	w, err := newBtcWalletFromMaster(k.masterKey, path, k.chainParams, segWitType)
+	if err != nil {
+		return nil, err
+	}
+	if err = k.AddWallet(w); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// AddWallet adds a wallet, which may also be created from a WIF key.
+func (k *BtcKeyring) AddWallet(w *BtcWallet) error {
+	if w.chainParams.Net != k.chainParams.Net {
+		return errors.New("key network doesn't match")
+	}
+	addr := w.DeriveAddress()
+	if _, ok := k.wallets[addr]; !ok {
+		k.wallets[addr] = w
+		k.ordered = append(k.ordered, w)
+	}
+	return nil
+}
+
+// AddWindow adds count addresses starting at index of the standard path of
+// segWitType: BIP44 for SegWitNone, BIP49 for SegWitScript, BIP84 for SegWitNative.
+func (k *BtcKeyring) AddWindow(segWitType SegWitType, accountIndex, changeType, start, count int) ([]*BtcWallet, error) {
+	var bipType int
+	switch segWitType {
+	case SegWitNone:
+		bipType = 44
+	case SegWitScript:
+		bipType = 49
+	case SegWitNative:
+		bipType = 84
+	default:
+		return nil, fmt.Errorf("invalid segwit type: %d", segWitType)
+	}
+	if start < 0 || count < 0 {
+		return nil, errors.New("invalid index")
+	}
+
+	// Derive the change level key once, then only the last index per address.
+	path, err := MakeBipXPath(bipType, SymbolBtc, k.chainId, accountIndex, changeType, 0)
+	if err != nil {
+		return nil, err
+	}
+	parentPath := strings.TrimSuffix(path, "/0")
+	parent, err := deriveExtendedKeyByPath(k.masterKey, parentPath, IsFixIssue172)
+	if err != nil {
+		return nil, err
+	}
+	parentOrigin, err := NewKeyOrigin(k.masterKey, parentPath)
+	if err != nil {
+		return nil, err
+	}
+
+	wallets := make([]*BtcWallet, 0, count)
+	for i := start; i < start+count; i++ {
+		child, err := deriveExtendedKeyByPath(parent, fmt.Sprintf("m/%d", i), IsFixIssue172)
+		if err != nil {
+			return nil, err
+		}
+		privateKey, err := child.ECPrivKey()
+		if err != nil {
+			return nil, err
+		}
+		w := &BtcWallet{symbol: SymbolBtc,
+			chainParams: k.chainParams, segWitType: segWitType,
+			privateKey: privateKey,
+			publicKey:  privateKey.PubKey(),
+			keyOrigin: &KeyOrigin{MasterFingerprint: parentOrigin.MasterFingerprint,
+				Path: fmt.Sprintf("%s/%d", parentPath, i)}}
+		if err = k.AddWallet(w); err != nil {
+			return nil, err
+		}
+		wallets = append(wallets, w)
+	}
+	return wallets, nil
+}
+
+// Wallet returns the wallet of an address in the keyring.
+func (k *BtcKeyring) Wallet(address string) (*BtcWallet, bool) {
+	w, ok := k.wallets[address]
+	return w, ok
+}
+
+// Wallets returns all wallets in the order they were added.
+func (k *BtcKeyring) Wallets() []*BtcWallet {
+	return k.ordered
+}
+
+func (k *BtcKeyring) Len() int {
+	return len(k.ordered)
+}
+
+// txauthor.SecretsSource
+func (k *BtcKeyring) GetKey(addr btcutil.Address) (*btcec.PrivateKey, bool, error) {
+	w, ok := k.wallets[addr.EncodeAddress()]
+	if !ok {
+		return nil, false, ErrAddressNotMatch
+	}
+	return w.privateKey, true, nil
+}
+
+// GetScript returns the redeem script of a P2SH-P2WPKH address.
+func (k *BtcKeyring) GetScript(addr btcutil.Address) ([]byte, error) {
+	w, ok := k.wallets[addr.EncodeAddress()]
+	if !ok {
+		return nil, ErrAddressNotMatch
+	}
+	script := w.RedeemScript()
+	if script == nil {
+		return nil, errors.New("address has no redeem script")
+	}
+	return script, nil
+}
+
+func (k *BtcKeyring) ChainParams() *chaincfg.Params {
+	return k.chainParams
+}
+
+func deriveExtendedKeyByPath(key *hdkeychain.ExtendedKey, path string, fixIssue172 bool) (*hdkeychain.ExtendedKey, error) {
+	dpath, err := accounts.ParseDerivationPath(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range dpath {
+		if fixIssue172 && key.IsAffectedByIssue172() {
+			key, err = key.Derive(n)
+		} else {
+			key, err = key.DeriveNonStandard(n)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
@@ -0,0 +1,69 @@
+package wallet
+
+import (
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestBtcKeyring(t *testing.T) {
+	mnemonic := "purse cheese cage reason cost flat jump usage hospital grit delay loan"
+	hdw, err := NewHDWallet(mnemonic, "", BtcChainRegtest, ChainMainNet)
+	require.NoError(t, err)
+	keyring, err := hdw.NewBtcKeyring()
+	require.NoError(t, err)
+
+	for _, segWitType := range []SegWitType{SegWitNone, SegWitScript, SegWitNative} {
+		wallets, err := keyring.AddWindow(segWitType, 0, ChangeTypeInternal, 3, 5)
+		require.NoError(t, err)
+		require.Len(t, wallets, 5)
+
+		var expected Wallet
+		switch segWitType {
+		case SegWitNone:
+			expected, err = hdw.NewWallet(SymbolBtc, 0, ChangeTypeInternal, 7)
+		case SegWitScript:
+			expected, err = hdw.NewSegWitWallet(0, ChangeTypeInternal, 7)
+		case SegWitNative:
+			expected, err = hdw.NewNativeSegWitWallet(0, ChangeTypeInternal, 7)
+		}
+		require.NoError(t, err)
+		w := expected.(*BtcWallet)
+		require.Equal(t, w.DeriveAddress(), wallets[4].DeriveAddress())
+		require.Equal(t, *w.KeyOrigin(), *wallets[4].KeyOrigin())
+
+		addr := w.DeriveNativeAddress()
+		key, compressed, err := keyring.GetKey(addr)
+		require.NoError(t, err)
+		require.True(t, compressed)
+		require.Equal(t, w.DeriveNativePrivateKey().Serialize(), key.Serialize())
+
+		script, err := keyring.GetScript(addr)
+		if segWitType == SegWitScript {
+			require.NoError(t, err)
+			require.Equal(t, w.RedeemScript(), script)
+		} else {
+			require.Error(t, err)
+		}
+	}
+	require.Equal(t, 15, keyring.Len())
+
+	// Adding the same address again is a no-op.
+	path, err := MakeBip84Path(SymbolBtc, BtcChainRegtest, 0, ChangeTypeInternal, 3)
+	require.NoError(t, err)
+	w, err := keyring.AddPath(path, SegWitNative)
+	require.NoError(t, err)
+	require.Equal(t, 15, keyring.Len())
+	found, ok := keyring.Wallet(w.DeriveAddress())
+	require.True(t, ok)
+	require.Equal(t, w.DeriveAddress(), found.DeriveAddress())
+
+	other, err := btcutil.NewAddressPubKeyHash(make([]byte, 20), keyring.ChainParams())
+	require.NoError(t, err)
+	_, _, err = keyring.GetKey(other)
+	require.ErrorIs(t, err, ErrAddressNotMatch)
+
+	mainnet, err := NewBtcWallet("KwdMAjGmerYanjeui5SHS7JkmpZvVipYvB2LJGU1ZxJwYvP98617", BtcChainMainNet, SegWitNone)
+	require.NoError(t, err)
+	require.Error(t, keyring.AddWallet(mainnet))
+}
@@ -1,6 +1,7 @@
 package wallet
 
 import (
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"github.com/btcsuite/btcd/btcec/v2"
@@ -20,6 +21,14 @@ type BtcWallet struct {
 	chainParams *chaincfg.Params
 	privateKey  *btcec.PrivateKey
 	publicKey   *btcec.PublicKey
+	keyOrigin   *KeyOrigin
+}
+
+// KeyOrigin describes where an HD derived key comes from, as used by PSBT.
+type KeyOrigin struct {
+	// MasterFingerprint is the first 4 bytes of hash160(master public key), little endian.
+	MasterFingerprint uint32
+	Path              string
 }
 
 func NewBtcWallet(privateKey string, chainId int, segWitType SegWitType) (*BtcWallet, error) {
@@ -51,16 +60,25 @@ func NewBtcWalletByPath(path string, seed []byte, chainId int, segWitType SegWit
 	if err != nil {
 		return nil, err
 	}
+	return newBtcWalletFromMaster(masterKey, path, chainParams, segWitType)
+}
 
+func newBtcWalletFromMaster(masterKey *hdkeychain.ExtendedKey, path string,
+	chainParams *chaincfg.Params, segWitType SegWitType) (*BtcWallet, error) {
 	privateKey, err := DerivePrivateKeyByPath(masterKey, path, IsFixIssue172)
 	if err != nil {
 		return nil, err
 	}
+	keyOrigin, err := NewKeyOrigin(masterKey, path)
+	if err != nil {
+		return nil, err
+	}
 
 	return &BtcWallet{symbol: SymbolBtc,
 		chainParams: chainParams, segWitType: segWitType,
 		privateKey: privateKey,
-		publicKey:  privateKey.PubKey()}, nil
+		publicKey:  privateKey.PubKey(),
+		keyOrigin:  keyOrigin}, nil
 }
 
 func (w *BtcWallet) ChainId() int {
@@ -138,22 +156,55 @@ func (w *BtcWallet) DeriveNativePrivateKey() *btcec.PrivateKey {
 	return w.privateKey
 }
 
-func DerivePrivateKeyByPath(masterKey *hdkeychain.ExtendedKey, path string, fixIssue172 bool) (*btcec.PrivateKey, error) {
-	dpath, err := accounts.ParseDerivationPath(path)
+func (w *BtcWallet) DeriveNativePublicKey() *btcec.PublicKey {
+	return w.publicKey
+}
+
+func (w *BtcWallet) SegWitType() SegWitType {
+	return w.segWitType
+}
+
+// KeyOrigin returns nil if the wallet was not derived from an HD seed.
+func (w *BtcWallet) KeyOrigin() *KeyOrigin {
+	return w.keyOrigin
+}
+
+// RedeemScript returns the P2SH redeem script of a SegWitScript wallet, nil otherwise.
+func (w *BtcWallet) RedeemScript() []byte {
+	if w.segWitType != SegWitScript {
+		return nil
+	}
+	keyHash := btcutil.Hash160(w.publicKey.SerializeCompressed())
+	script, err := txscript.NewScriptBuilder().AddOp(txscript.OP_0).AddData(keyHash).Script()
+	if err != nil {
+		log.Println("RedeemScript error:", err)
+		return nil
+	}
+	return script
+}
+
+func NewKeyOrigin(masterKey *hdkeychain.ExtendedKey, path string) (*KeyOrigin, error) {
+	pubKey, err := masterKey.ECPubKey()
 	if err != nil {
 		return nil, err
 	}
+	fingerprint := btcutil.Hash160(pubKey.SerializeCompressed())[:4]
+	return &KeyOrigin{MasterFingerprint: binary.LittleEndian.Uint32(fingerprint), Path: path}, nil
+}
 
-	key := masterKey
-	for _, n := range dpath {
-		if fixIssue172 && key.IsAffectedByIssue172() {
-			key, err = key.Derive(n)
-		} else {
-			key, err = key.DeriveNonStandard(n)
-		}
-		if err != nil {
-			return nil, err
-		}
+// PathIndexes returns the derivation path as child indexes, hardened ones offset by 2^31.
+func (o *KeyOrigin) PathIndexes() ([]uint32, error) {
+	dpath, err := accounts.ParseDerivationPath(o.Path)
+	if err != nil {
+		return nil, err
+	}
+	return dpath, nil
+}
+
+func DerivePrivateKeyByPath(masterKey *hdkeychain.ExtendedKey, path string, fixIssue172 bool) (*btcec.PrivateKey, error) {
+	key, err := deriveExtendedKeyByPath(masterKey, path, fixIssue172)
+	if err != nil {
+		return nil, err
 	}
 
 	privateKey, err := key.ECPrivKey()
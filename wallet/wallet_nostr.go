@@ -0,0 +1,254 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil/bech32"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"log"
+	"unicode/utf8"
+)
+
+const (
+	NostrHrpPublicKey  = "npub"
+	NostrHrpPrivateKey = "nsec"
+)
+
+var ErrNostrInvalidSignature = errors.New("invalid nostr event signature")
+
+type NostrWallet struct {
+	symbol     string
+	privateKey *btcec.PrivateKey
+	publicKey  *btcec.PublicKey
+}
+
+// NostrEvent is a NIP-01 event.
+type NostrEvent struct {
+	ID        string     `json:"id"`
+	PubKey    string     `json:"pubkey"`
+	CreatedAt int64      `json:"created_at"`
+	Kind      int        `json:"kind"`
+	Tags      [][]string `json:"tags"`
+	Content   string     `json:"content"`
+	Sig       string     `json:"sig"`
+}
+
+// NewNostrWallet accepts a private key either as nsec (NIP-19) or as hex.
+func NewNostrWallet(privateKey string) (*NostrWallet, error) {
+	var keyBytes []byte
+	var err error
+	if len(privateKey) > len(NostrHrpPrivateKey) && privateKey[:len(NostrHrpPrivateKey)] == NostrHrpPrivateKey {
+		keyBytes, err = DecodeNostrBech32(NostrHrpPrivateKey, privateKey)
+	} else {
+		keyBytes, err = hex.DecodeString(privateKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(keyBytes) != 32 {
+		return nil, errors.New("invalid nostr private key length")
+	}
+
+	privKey, pubKey := btcec.PrivKeyFromBytes(keyBytes)
+	return &NostrWallet{symbol: SymbolNostr,
+		privateKey: privKey, publicKey: pubKey}, nil
+}
+
+// NewNostrWalletByPath derives the key along path, NIP-06 uses m/44'/1237'/account'/0/0.
+func NewNostrWalletByPath(path string, seed []byte) (*NostrWallet, error) {
+	masterKey, err := hdkeychain.NewMaster(seed, &chaincfg.MainNetParams)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, err := DerivePrivateKeyByPath(masterKey, path, IsFixIssue172)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NostrWallet{symbol: SymbolNostr,
+		privateKey: privateKey, publicKey: privateKey.PubKey()}, nil
+}
+
+func (w *NostrWallet) ChainId() int {
+	return 0
+}
+
+func (w *NostrWallet) Symbol() string {
+	return w.symbol
+}
+
+// DeriveAddress returns the npub encoded public key.
+func (w *NostrWallet) DeriveAddress() string {
+	s, err := EncodeNostrBech32(NostrHrpPublicKey, schnorr.SerializePubKey(w.publicKey))
+	if err != nil {
+		log.Println("DeriveAddress error:", err)
+		return ""
+	}
+	return s
+}
+
+// DerivePublicKey returns the 32 bytes x-only public key in hex, as used in events.
+func (w *NostrWallet) DerivePublicKey() string {
+	return hex.EncodeToString(schnorr.SerializePubKey(w.publicKey))
+}
+
+// DerivePrivateKey returns the nsec encoded private key.
+func (w *NostrWallet) DerivePrivateKey() string {
+	s, err := EncodeNostrBech32(NostrHrpPrivateKey, w.privateKey.Serialize())
+	if err != nil {
+		log.Println("DerivePrivateKey error:", err)
+		return ""
+	}
+	return s
+}
+
+func (w *NostrWallet) DeriveNativePrivateKey() *btcec.PrivateKey {
+	return w.privateKey
+}
+
+// SignEvent fills in the pubkey, id and sig of the event.
+func (w *NostrWallet) SignEvent(ev *NostrEvent) error {
+	ev.PubKey = w.DerivePublicKey()
+	id, err := ev.Hash()
+	if err != nil {
+		return err
+	}
+	sig, err := schnorr.Sign(w.privateKey, id)
+	if err != nil {
+		return err
+	}
+	ev.ID = hex.EncodeToString(id)
+	ev.Sig = hex.EncodeToString(sig.Serialize())
+	return nil
+}
+
+// Serialize returns the canonical NIP-01 serialization used to compute the id.
+func (ev *NostrEvent) Serialize() ([]byte, error) {
+	pubKey, err := hex.DecodeString(ev.PubKey)
+	if err != nil || len(pubKey) != 32 {
+		return nil, errors.New("invalid nostr event pubkey")
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(`[0,"`)
+	buf.WriteString(ev.PubKey)
+	buf.WriteString(`",`)
+	buf.WriteString(fmt.Sprintf("%d,%d,[", ev.CreatedAt, ev.Kind))
+	for i, tag := range ev.Tags {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteByte('[')
+		for j, s := range tag {
+			if j > 0 {
+				buf.WriteByte(',')
+			}
+			writeNostrString(&buf, s)
+		}
+		buf.WriteByte(']')
+	}
+	buf.WriteString("],")
+	writeNostrString(&buf, ev.Content)
+	buf.WriteByte(']')
+	return buf.Bytes(), nil
+}
+
+func (ev *NostrEvent) Hash() ([]byte, error) {
+	b, err := ev.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256(b)
+	return h[:], nil
+}
+
+// Verify checks the id and the BIP340 signature of the event.
+func (ev *NostrEvent) Verify() error {
+	id, err := ev.Hash()
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(id) != ev.ID {
+		return errors.New("nostr event id mismatch")
+	}
+
+	pubKeyBytes, _ := hex.DecodeString(ev.PubKey)
+	pubKey, err := schnorr.ParsePubKey(pubKeyBytes)
+	if err != nil {
+		return err
+	}
+	sigBytes, err := hex.DecodeString(ev.Sig)
+	if err != nil {
+		return err
+	}
+	sig, err := schnorr.ParseSignature(sigBytes)
+	if err != nil {
+		return err
+	}
+	if !sig.Verify(id, pubKey) {
+		return ErrNostrInvalidSignature
+	}
+	return nil
+}
+
+// writeNostrString escapes s the way NIP-01 requires: only the quote, the
+// backslash and control characters are escaped, everything else is verbatim.
+func writeNostrString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			_, size := utf8.DecodeRuneInString(s[i:])
+			buf.WriteString(s[i : i+size])
+			i += size
+			continue
+		}
+		switch c {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		default:
+			if c < 0x20 {
+				buf.WriteString(fmt.Sprintf(`\u%04x`, c))
+			} else {
+				buf.WriteByte(c)
+			}
+		}
+		i++
+	}
+	buf.WriteByte('"')
+}
+
+// EncodeNostrBech32 encodes raw key bytes with the NIP-19 hrp (npub, nsec, note).
+func EncodeNostrBech32(hrp string, data []byte) (string, error) {
+	return bech32.EncodeFromBase256(hrp, data)
+}
+
+func DecodeNostrBech32(hrp string, s string) ([]byte, error) {
+	gotHrp, data, err := bech32.DecodeToBase256(s)
+	if err != nil {
+		return nil, err
+	}
+	if gotHrp != hrp {
+		return nil, fmt.Errorf("invalid nostr bech32 prefix: %s", gotHrp)
+	}
+	return data, nil
+}
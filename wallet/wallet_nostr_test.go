@@ -0,0 +1,67 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNostr_Nip06(t *testing.T) {
+	for _, tt := range []struct {
+		mnemonic   string
+		privateKey string
+		nsec       string
+		publicKey  string
+		npub       string
+	}{
+		{
+			mnemonic:   "leader monkey parrot ring guide accident before fence cannon height naive bean",
+			privateKey: "7f7ff03d123792d6ac594bfa67bf6d0c0ab55b6b1fdb6249303fe861f1ccba9a",
+			nsec:       "nsec10allq0gjx7fddtzef0ax00mdps9t2kmtrldkyjfs8l5xruwvh2dq0lhhkp",
+			publicKey:  "17162c921dc4d2518f9a101db33695df1afb56ab82f5ff3e5da6eec3ca5cd917",
+			npub:       "npub1zutzeysacnf9rru6zqwmxd54mud0k44tst6l70ja5mhv8jjumytsd2x7nu",
+		},
+		{
+			mnemonic:   "what bleak badge arrange retreat wolf trade produce cricket blur garlic valid proud rude strong choose busy staff weather area salt hollow arm fade",
+			privateKey: "c15d739894c81a2fcfd3a2df85a0d2c0dbc47a280d092799f144d73d7ae78add",
+			nsec:       "nsec1c9wh8xy5eqdzln7n5t0ctgxjcrdug73gp5yj0x03gntn67h83twssdfhel",
+			publicKey:  "d41b22899549e1f3d335a31002cfd382174006e166d3e658e3a5eecdb6463573",
+			npub:       "npub16sdj9zv4f8sl85e45vgq9n7nsgt5qphpvmf7vk8r5hhvmdjxx4es8rq74h",
+		},
+	} {
+		hdw, err := NewHDWallet(tt.mnemonic, "", BtcChainMainNet, ChainMainNet)
+		require.NoError(t, err)
+
+		w, err := hdw.NewNostrWallet(0)
+		require.NoError(t, err)
+		require.Equal(t, tt.nsec, w.DerivePrivateKey())
+		require.Equal(t, tt.publicKey, w.DerivePublicKey())
+		require.Equal(t, tt.npub, w.DeriveAddress())
+
+		w2, err := NewNostrWallet(tt.privateKey)
+		require.NoError(t, err)
+		require.Equal(t, tt.npub, w2.DeriveAddress())
+
+		w3, err := NewNostrWallet(tt.nsec)
+		require.NoError(t, err)
+		require.Equal(t, tt.privateKey, hex.EncodeToString(w3.DeriveNativePrivateKey().Serialize()))
+	}
+}
+
+func TestNostr_SignEvent(t *testing.T) {
+	w, err := NewNostrWallet("nsec10allq0gjx7fddtzef0ax00mdps9t2kmtrldkyjfs8l5xruwvh2dq0lhhkp")
+	require.NoError(t, err)
+
+	ev := &NostrEvent{CreatedAt: 1700000000, Kind: 1,
+		Tags:    [][]string{{"t", "hdwallet"}},
+		Content: "hello \"nostr\"\n<&> \u2028 ünïcode"}
+	require.NoError(t, w.SignEvent(ev))
+	require.NoError(t, ev.Verify())
+
+	b, err := ev.Serialize()
+	require.NoError(t, err)
+	require.Equal(t, `[0,"17162c921dc4d2518f9a101db33695df1afb56ab82f5ff3e5da6eec3ca5cd917",1700000000,1,[["t","hdwallet"]],"hello \"nostr\"\n<&> `+"\u2028"+` ünïcode"]`, string(b))
+
+	ev.Content = "tampered"
+	require.Error(t, ev.Verify())
+}